@@ -1 +1 @@
-protoc grpc-chatapp/schema/chat.proto --go_out=plugins=grpc:. 
\ No newline at end of file
+protoc -I . -I third_party/googleapis grpc-chatapp/schema/chat.proto --go_out=plugins=grpc:. --grpc-gateway_out=. --swagger_out=.
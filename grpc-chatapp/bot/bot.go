@@ -0,0 +1,202 @@
+// Package bot runs the bots of the chat. A bot claims slash commands and the server sends it their
+// invocations, the replies of the handlers are posted to the room the command was sent in:
+//
+//	b, err := bot.New(bot.Config{
+//		Servers:     []string{"localhost:50051"},
+//		APIKey:      os.Getenv("CHAT_API_KEY"),
+//		DialOptions: []grpc.DialOption{grpc.WithInsecure()},
+//	})
+//	...
+//	b.Command("echo", "repeats the message", func(ctx context.Context, inv bot.Invocation) string {
+//		return inv.Args
+//	})
+//	err = b.Run(ctx)
+package bot
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultRetryDelay is how long the bot waits before connecting to the next server
+const defaultRetryDelay = 5 * time.Second
+
+type Config struct {
+	// Servers are the addresses of the servers to connect to, in order
+	Servers []string
+	// APIKey is the key of the bot account set up on the server
+	APIKey string
+	// DialOptions are passed to grpc.Dial, they have to set up the transport security e.g. with grpc.WithInsecure()
+	DialOptions []grpc.DialOption
+	// RetryDelay is how long the bot waits before connecting again once disconnected, 5s when zero
+	RetryDelay time.Duration
+	// Logger logs the connections and the failed replies, nothing is logged when nil
+	Logger log.Logger
+}
+
+// Invocation is a slash command sent by a user
+type Invocation struct {
+	Command string
+	// Args is the text following the command
+	Args string
+	// Name is the user who sent the command, Room where it was sent
+	Name, Room string
+}
+
+// Handler answers an invocation, the reply is posted to the room of the invocation unless it is empty
+type Handler func(ctx context.Context, inv Invocation) string
+
+type command struct {
+	description string
+	handler     Handler
+}
+
+type Bot struct {
+	cfg    Config
+	logger log.Logger
+
+	mu       sync.Mutex
+	commands map[string]command
+}
+
+func New(cfg Config) (*Bot, error) {
+
+	if len(cfg.Servers) == 0 {
+		return nil, errors.New("bot: no server to connect to")
+	}
+	if cfg.APIKey == "" {
+		return nil, errors.New("bot: an api key is required")
+	}
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	logger := cfg.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	return &Bot{cfg: cfg, logger: logger, commands: make(map[string]command)}, nil
+}
+
+// Command handles the slash command name, without the slash. The commands are registered with the
+// server whenever the bot connects.
+func (b *Bot) Command(name, description string, h Handler) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.commands[name] = command{description: description, handler: h}
+}
+
+// Run connects the bot and handles the invocations one at a time until ctx is done, connecting again
+// whenever the connection is lost. It returns early when the server refuses the API key or a command.
+func (b *Bot) Run(ctx context.Context) error {
+
+	for current := 0; ; current = (current + 1) % len(b.cfg.Servers) {
+		addr := b.cfg.Servers[current]
+		err := b.session(ctx, addr)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !retryable(err) {
+			return err
+		}
+		level.Warn(b.logger).Log("message", "disconnected from the server", "addr", addr, "err", err)
+		if !sleep(ctx, b.cfg.RetryDelay) {
+			return nil
+		}
+	}
+}
+
+// retryable reports whether connecting again may help, the other errors are about the configuration
+func retryable(err error) bool {
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.PermissionDenied, codes.InvalidArgument, codes.AlreadyExists:
+		return false
+	}
+	return true
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// session subscribes to the server at addr, registers the commands and handles their invocations until
+// the stream ends
+func (b *Bot) session(ctx context.Context, addr string) error {
+
+	cc, err := grpc.Dial(addr, b.cfg.DialOptions...)
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	conn := chat.NewChatClient(cc)
+
+	b.mu.Lock()
+	commands := make(map[string]command, len(b.commands))
+	names := make([]string, 0, len(b.commands))
+	for name, c := range b.commands {
+		commands[name] = c
+		names = append(names, name)
+	}
+	b.mu.Unlock()
+	sort.Strings(names)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stream, err := conn.Subscribe(ctx, &chat.SubscribeRequest{Token: b.cfg.APIKey})
+	if err != nil {
+		return err
+	}
+	// The commands are registered once the stream is, so that none of their invocations is missed
+	if _, err := stream.Header(); err != nil {
+		return err
+	}
+	for _, name := range names {
+		req := &chat.RegisterCommandRequest{Token: b.cfg.APIKey, Command: name, Description: commands[name].description}
+		if _, err := conn.RegisterCommand(ctx, req); err != nil {
+			return err
+		}
+	}
+	level.Info(b.logger).Log("message", "connected to the server", "addr", addr, "commands", len(names))
+	for {
+		res, err := stream.Recv()
+		if err == io.EOF {
+			return status.Error(codes.Unavailable, "the server closed the stream")
+		}
+		if err != nil {
+			return err
+		}
+		inv := res.GetCommandInvocation()
+		if inv == nil {
+			continue
+		}
+		c, ok := commands[inv.Command]
+		if !ok {
+			continue
+		}
+		reply := c.handler(ctx, Invocation{Command: inv.Command, Args: inv.Args, Name: inv.Name, Room: inv.Room})
+		if reply == "" {
+			continue
+		}
+		if _, err := conn.Post(ctx, &chat.PostRequest{Token: b.cfg.APIKey, Room: inv.Room, Message: reply}); err != nil {
+			level.Warn(b.logger).Log("message", "failed to reply", "command", inv.Command, "room", inv.Room, "err", err)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package bot_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/bot"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const apiKey = "echo-0123456789abcdef"
+
+func newBot(t *testing.T, s *chattest.Server, key string) *bot.Bot {
+
+	t.Helper()
+	b, err := bot.New(bot.Config{Servers: []string{chattest.Addr}, APIKey: key, DialOptions: s.DialOptions(), RetryDelay: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	return b
+}
+
+func TestBot(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{Bots: []chatserver.Bot{{Name: "echo", APIKey: apiKey}}})
+	b := newBot(t, s, apiKey)
+	invoked := make(chan bot.Invocation, 10)
+	b.Command("echo", "repeats the message", func(ctx context.Context, inv bot.Invocation) string {
+		invoked <- inv
+		return strings.ToUpper(inv.Args)
+	})
+	b.Command("quiet", "replies nothing", func(ctx context.Context, inv bot.Invocation) string {
+		return ""
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- b.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run() error = %v", err)
+		}
+	}()
+
+	// The bot is ready once its commands are registered
+	client := chat.NewChatClient(s.Dial())
+	login, err := client.Login(ctx, &chat.LoginRequest{Username: "carol"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	for deadline := time.Now().Add(chattest.Timeout); ; {
+		res, err := client.ListCommands(ctx, &chat.ListCommandsRequest{Token: login.Token})
+		if err != nil {
+			t.Fatalf("ListCommands() error = %v", err)
+		}
+		if len(res.Commands) == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the bot registered %v, want echo and quiet", res.Commands)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	alice := s.Client("alice")
+	if err := alice.Send(ctx, "", "/echo hello"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if inv := <-invoked; inv.Command != "echo" || inv.Args != "hello" || inv.Name != "alice" || inv.Room != "lobby" {
+		t.Fatalf("the handler got %+v, want alice's echo in the lobby", inv)
+	}
+	if got := alice.Next(chatclient.Message{}).(chatclient.Message); got.Name != "echo" || got.Room != "lobby" || got.Text != "HELLO" {
+		t.Fatalf("alice received %q in %v from %v, want the reply of the bot", got.Text, got.Room, got.Name)
+	}
+
+	// Empty replies are not posted
+	for _, text := range []string{"/quiet", "/echo again"} {
+		if err := alice.Send(ctx, "", text); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if got := alice.Next(chatclient.Message{}).(chatclient.Message); got.Name != "echo" || got.Text != "AGAIN" {
+		t.Fatalf("alice received %q from %v, want the reply to the second echo", got.Text, got.Name)
+	}
+}
+
+func TestRunRefused(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{Bots: []chatserver.Bot{{Name: "echo", APIKey: apiKey}}})
+	tests := []struct {
+		name    string
+		key     string
+		command string
+		want    codes.Code
+	}{
+		{"unknown key", "unknown-0123456789abcdef", "echo", codes.Unauthenticated},
+		{"invalid command", apiKey, "Echo", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		b := newBot(t, s, tt.key)
+		b.Command(tt.command, "", func(ctx context.Context, inv bot.Invocation) string { return "" })
+		ctx, cancel := context.WithTimeout(context.Background(), chattest.Timeout)
+		err := b.Run(ctx)
+		cancel()
+		if code := status.Code(err); code != tt.want {
+			t.Errorf("%v: Run() error = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := bot.New(bot.Config{Servers: []string{chattest.Addr}}); err == nil {
+		t.Errorf("New() without an api key returned no error")
+	}
+}
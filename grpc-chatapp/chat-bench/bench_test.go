@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+)
+
+func TestProbe(t *testing.T) {
+
+	sent := time.Unix(0, 1600000000123456789)
+	tests := []struct {
+		name   string
+		text   string
+		want   probe
+		wantOK bool
+	}{
+		{"plain", probe{run: "ab12", sender: 3, seq: 42, sent: sent}.encode(0), probe{run: "ab12", sender: 3, seq: 42, sent: sent}, true},
+		{"padded", probe{run: "ab12", sender: 0, seq: 7, sent: sent}.encode(256), probe{run: "ab12", sender: 0, seq: 7, sent: sent}, true},
+		{"chat message", "hello there", probe{}, false},
+		{"missing fields", "bench ab12 3 42", probe{}, false},
+		{"bad sequence", "bench ab12 3 x 1600000000123456789", probe{}, false},
+	}
+
+	for _, tt := range tests {
+		got, ok := decode(tt.text)
+		if ok != tt.wantOK || got.run != tt.want.run || got.sender != tt.want.sender || got.seq != tt.want.seq || !got.sent.Equal(tt.want.sent) {
+			t.Errorf("%v: decode(%q) = %+v, %v, want %+v, %v", tt.name, tt.text, got, ok, tt.want, tt.wantOK)
+		}
+	}
+	if text := (probe{run: "ab12", sent: sent}).encode(256); len(text) != 256 {
+		t.Errorf("encode(256) is %v bytes long", len(text))
+	}
+}
+
+func TestReport(t *testing.T) {
+
+	sent := time.Now()
+	at := func(ms int) time.Time { return sent.Add(time.Duration(ms) * time.Millisecond) }
+
+	// Both clients sent 2 messages. The first one got everything and the second message of
+	// the second client twice, the second one lost the first message of the first client.
+	first, second := newReceiver(2), newReceiver(2)
+	first.record(probe{sender: 0, seq: 0, sent: sent}, at(1))
+	first.record(probe{sender: 0, seq: 1, sent: sent}, at(2))
+	first.record(probe{sender: 1, seq: 0, sent: sent}, at(3))
+	first.record(probe{sender: 1, seq: 1, sent: sent}, at(4))
+	first.record(probe{sender: 1, seq: 1, sent: sent}, at(5))
+	second.record(probe{sender: 0, seq: 1, sent: sent}, at(6))
+	second.record(probe{sender: 1, seq: 0, sent: sent}, at(7))
+	second.record(probe{sender: 1, seq: 1, sent: sent}, at(8))
+
+	r := newReport(time.Second, []int{2, 2}, 0, []*receiver{first, second})
+	if r.delivered != 7 || r.dropped != 1 || r.duplicated != 1 || r.unknown != 0 {
+		t.Fatalf("delivered %v, dropped %v, duplicated %v, unknown %v, want 7, 1, 1, 0", r.delivered, r.dropped, r.duplicated, r.unknown)
+	}
+
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 4 * time.Millisecond},
+		{90, 7 * time.Millisecond},
+		{100, 8 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if got := r.percentile(tt.p); got != tt.want {
+			t.Errorf("percentile(%v) = %v, want %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestBench(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	r, err := bench(context.Background(), config{
+		servers:        []string{chattest.Addr},
+		dialOptions:    s.DialOptions(),
+		clients:        3,
+		rate:           50,
+		duration:       200 * time.Millisecond,
+		drain:          200 * time.Millisecond,
+		connectTimeout: 5 * time.Second,
+		size:           64,
+		username:       "bench",
+	})
+	if err != nil {
+		t.Fatalf("bench() error = %v", err)
+	}
+	if sent := r.totalSent(); sent == 0 || r.delivered != sent*3 || r.dropped != 0 || r.duplicated != 0 {
+		t.Fatalf("sent %v, delivered %v, dropped %v, duplicated %v, want every message delivered to the 3 clients once",
+			sent, r.delivered, r.dropped, r.duplicated)
+	}
+	if len(r.latencies) != r.delivered {
+		t.Fatalf("%v latencies for %v deliveries", len(r.latencies), r.delivered)
+	}
+
+	_, err = bench(context.Background(), config{
+		servers:        []string{chattest.Addr},
+		dialOptions:    s.DialOptions(),
+		clients:        1,
+		rate:           1,
+		duration:       time.Second,
+		connectTimeout: 5 * time.Second,
+		room:           "missing",
+		username:       "bench",
+	})
+	if err == nil {
+		t.Fatalf("bench() in a missing room succeeded")
+	}
+}
@@ -0,0 +1,307 @@
+// chat-bench spawns simulated clients that log in, stream and post at a steady rate, and reports
+// the delivery latency along with the messages that were dropped or duplicated on the way.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"google.golang.org/grpc"
+)
+
+type config struct {
+	servers     []string
+	dialOptions []grpc.DialOption
+	clients     int
+	// rate is the number of messages every client posts per second
+	rate     float64
+	duration time.Duration
+	// drain is how long the clients keep receiving once they stopped posting
+	drain          time.Duration
+	connectTimeout time.Duration
+	room           string
+	size           int
+	username       string
+}
+
+// client is a simulated user, it records what it receives until its events are closed
+type client struct {
+	*chatclient.Client
+	index    int
+	receiver *receiver
+	run      string
+
+	connected chan []string
+	done      chan error
+	received  chan struct{}
+}
+
+func (c *client) receive() {
+
+	defer close(c.received)
+	for ev := range c.Events() {
+		switch ev := ev.(type) {
+		case chatclient.Connected:
+			c.connected <- ev.Rooms
+		case chatclient.Message:
+			if p, ok := decode(ev.Text); ok && p.run == c.run {
+				c.receiver.record(p, time.Now())
+			}
+		}
+	}
+}
+
+// connect starts the clients and waits for all of them to be logged in with their stream open
+func connect(ctx context.Context, cfg config, run string) ([]*client, error) {
+
+	clients := make([]*client, cfg.clients)
+	for i := range clients {
+		clientCfg := chatclient.Config{
+			Servers:     cfg.servers,
+			Username:    fmt.Sprintf("%v-%v", cfg.username, i),
+			DialOptions: cfg.dialOptions,
+		}
+		if cfg.room != "" {
+			clientCfg.Rooms = []string{cfg.room}
+		}
+		sdk, err := chatclient.New(clientCfg)
+		if err != nil {
+			return nil, err
+		}
+		c := &client{
+			Client:    sdk,
+			index:     i,
+			receiver:  newReceiver(cfg.clients),
+			run:       run,
+			connected: make(chan []string, 1),
+			done:      make(chan error, 1),
+			received:  make(chan struct{}),
+		}
+		clients[i] = c
+		go c.receive()
+		go func() { c.done <- c.Run(ctx) }()
+	}
+
+	timeout := time.After(cfg.connectTimeout)
+	for _, c := range clients {
+		select {
+		case rooms := <-c.connected:
+			if cfg.room != "" && (len(rooms) == 0 || rooms[0] != cfg.room) {
+				return clients, fmt.Errorf("%v could not join %v, it has to be a public room", c.Name(), cfg.room)
+			}
+		case err := <-c.done:
+			c.done <- err
+			return clients, fmt.Errorf("%v could not connect: %v", c.Name(), err)
+		case <-timeout:
+			return clients, fmt.Errorf("timed out waiting for %v to connect", c.Name())
+		case <-ctx.Done():
+			return clients, ctx.Err()
+		}
+	}
+	return clients, nil
+}
+
+// post sends messages at the configured rate until ctx is done, it returns the number of messages sent
+func (c *client) post(ctx context.Context, cfg config) (sent int, err error) {
+
+	interval := time.Duration(float64(time.Second) / cfg.rate)
+	// Spread the clients over the interval rather than having them all post at once
+	offset := time.Duration(int64(interval) * int64(c.index) / int64(cfg.clients))
+	select {
+	case <-time.After(offset):
+	case <-ctx.Done():
+		return 0, nil
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		p := probe{run: c.run, sender: c.index, seq: sent, sent: time.Now()}
+		if err := c.Send(ctx, cfg.room, p.encode(cfg.size)); err != nil {
+			if ctx.Err() != nil {
+				return sent, nil
+			}
+			return sent, err
+		}
+		sent++
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return sent, nil
+		}
+	}
+}
+
+// bench runs the benchmark and returns what the clients sent and received
+func bench(ctx context.Context, cfg config) (*report, error) {
+
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	run := hex.EncodeToString(b)
+
+	clientsCtx, stopClients := context.WithCancel(context.Background())
+	clients, err := connect(clientsCtx, cfg, run)
+	// The clients log out once they stopped, they are waited for on every path
+	defer func() {
+		stopClients()
+		for _, c := range clients {
+			if c != nil {
+				<-c.received
+			}
+		}
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	postCtx, stopPosting := context.WithTimeout(ctx, cfg.duration)
+	defer stopPosting()
+	start := time.Now()
+	sent := make([]int, len(clients))
+	var sendErrors int
+	var mu sync.Mutex
+	var posters sync.WaitGroup
+	for _, c := range clients {
+		posters.Add(1)
+		go func(c *client) {
+			defer posters.Done()
+			n, err := c.post(postCtx, cfg)
+			mu.Lock()
+			defer mu.Unlock()
+			sent[c.index] = n
+			if err != nil {
+				sendErrors++
+				log.Printf("%v stopped posting: %v", c.Name(), err)
+			}
+		}(c)
+	}
+	posters.Wait()
+	duration := time.Since(start)
+
+	// Give the server the time to deliver what is still queued
+	select {
+	case <-time.After(cfg.drain):
+	case <-ctx.Done():
+	}
+	stopClients()
+	receivers := make([]*receiver, len(clients))
+	for i, c := range clients {
+		<-c.received
+		if err := <-c.done; err != nil {
+			log.Printf("%v stopped: %v", c.Name(), err)
+		}
+		receivers[i] = c.receiver
+	}
+	return newReport(duration, sent, sendErrors, receivers), nil
+}
+
+// serveLocal starts a server in the process on a free port and returns its address and a function stopping it
+func serveLocal() (string, func(), error) {
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", nil, err
+	}
+	s, err := chatserver.NewServer(chatserver.Options{})
+	if err != nil {
+		lis.Close()
+		return "", nil, err
+	}
+	go s.Serve(lis)
+	return lis.Addr().String(), func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Shutdown(ctx)
+	}, nil
+}
+
+func (cfg config) validate() error {
+
+	switch {
+	case cfg.clients < 1:
+		return errors.New("-clients has to be at least 1")
+	case cfg.rate <= 0:
+		return errors.New("-rate has to be positive")
+	case cfg.duration <= 0:
+		return errors.New("-duration has to be positive")
+	case cfg.size < 0:
+		return errors.New("-size can not be negative")
+	}
+	return nil
+}
+
+func main() {
+
+	serverList := flag.String("servers", "localhost:50051", "comma separated addresses of the servers, the clients connect to the first one available")
+	local := flag.Bool("local", false, "start a server in the process and run against it, -servers is ignored")
+	clients := flag.Int("clients", 10, "number of simulated clients")
+	rate := flag.Float64("rate", 1, "messages every client posts per second")
+	duration := flag.Duration("duration", 10*time.Second, "how long the clients post")
+	drain := flag.Duration("drain", 2*time.Second, "how long the clients keep receiving once they stopped posting")
+	connectTimeout := flag.Duration("connect.timeout", 30*time.Second, "how long to wait for every client to connect")
+	room := flag.String("room", "", "public room to post to, the lobby when empty")
+	size := flag.Int("size", 0, "size of the messages in bytes, padded when larger than the timestamps they carry")
+	username := flag.String("username", "bench", "prefix of the names of the clients")
+	flag.Parse()
+
+	cfg := config{
+		servers:        strings.Split(*serverList, ","),
+		dialOptions:    []grpc.DialOption{grpc.WithInsecure()},
+		clients:        *clients,
+		rate:           *rate,
+		duration:       *duration,
+		drain:          *drain,
+		connectTimeout: *connectTimeout,
+		room:           *room,
+		size:           *size,
+		username:       *username,
+	}
+	if err := cfg.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if *local {
+		addr, stop, err := serveLocal()
+		if err != nil {
+			log.Fatalf("could not start the local server: %v", err)
+		}
+		defer stop()
+		cfg.servers = []string{addr}
+	}
+
+	// An interrupt stops the posting early, the report covers what was sent until then
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+	go func() {
+		select {
+		case <-signals:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	fmt.Printf("%v clients posting %v messages/s each to %v for %v\n", cfg.clients, cfg.rate, strings.Join(cfg.servers, ","), cfg.duration)
+	r, err := bench(ctx, cfg)
+	if err != nil {
+		log.Fatalf("benchmark failed: %v", err)
+	}
+	r.write(os.Stdout)
+}
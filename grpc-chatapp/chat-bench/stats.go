@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// messagePrefix starts the messages of the benchmark, the messages of other runs and users are ignored
+const messagePrefix = "bench"
+
+// probe is what a benchmark message carries: who sent it, its sequence number and when it was sent
+type probe struct {
+	run    string
+	sender int
+	seq    int
+	sent   time.Time
+}
+
+// encode returns the text of the message, padded with dots to size bytes
+func (p probe) encode(size int) string {
+
+	text := fmt.Sprintf("%v %v %v %v %v", messagePrefix, p.run, p.sender, p.seq, p.sent.UnixNano())
+	if len(text) < size {
+		text += " " + strings.Repeat(".", size-len(text)-1)
+	}
+	return text
+}
+
+// decode parses the text of a benchmark message, ok is false for the other messages
+func decode(text string) (p probe, ok bool) {
+
+	fields := strings.Fields(text)
+	if len(fields) < 5 || fields[0] != messagePrefix {
+		return probe{}, false
+	}
+	sender, err := strconv.Atoi(fields[2])
+	if err != nil {
+		return probe{}, false
+	}
+	seq, err := strconv.Atoi(fields[3])
+	if err != nil {
+		return probe{}, false
+	}
+	nanos, err := strconv.ParseInt(fields[4], 10, 64)
+	if err != nil {
+		return probe{}, false
+	}
+	return probe{run: fields[1], sender: sender, seq: seq, sent: time.Unix(0, nanos)}, true
+}
+
+// receiver counts what one client received, it is only written by the goroutine reading the events of the client
+type receiver struct {
+	// counts holds the number of times every message was received, by sender and sequence number
+	counts    [][]uint8
+	latencies []time.Duration
+	unknown   int
+}
+
+func newReceiver(senders int) *receiver {
+	return &receiver{counts: make([][]uint8, senders)}
+}
+
+func (r *receiver) record(p probe, at time.Time) {
+
+	if p.sender < 0 || p.sender >= len(r.counts) || p.seq < 0 {
+		r.unknown++
+		return
+	}
+	counts := r.counts[p.sender]
+	for len(counts) <= p.seq {
+		counts = append(counts, 0)
+	}
+	if counts[p.seq] < 255 {
+		counts[p.seq]++
+	}
+	r.counts[p.sender] = counts
+	r.latencies = append(r.latencies, at.Sub(p.sent))
+}
+
+// delivered returns the number of distinct messages received
+func (r *receiver) delivered() int {
+
+	n := 0
+	for _, counts := range r.counts {
+		for _, c := range counts {
+			if c > 0 {
+				n++
+			}
+		}
+	}
+	return n
+}
+
+type report struct {
+	clients  int
+	duration time.Duration
+	// sent is the number of messages every client sent, each of them is expected by every client
+	sent       []int
+	sendErrors int
+	delivered  int
+	dropped    int
+	duplicated int
+	unknown    int
+	latencies  []time.Duration
+}
+
+// newReport adds up what the clients sent and received
+func newReport(duration time.Duration, sent []int, sendErrors int, receivers []*receiver) *report {
+
+	r := &report{clients: len(receivers), duration: duration, sent: sent, sendErrors: sendErrors}
+	for _, rcv := range receivers {
+		for sender, total := range sent {
+			counts := rcv.counts[sender]
+			for seq := 0; seq < total; seq++ {
+				switch {
+				case seq >= len(counts) || counts[seq] == 0:
+					r.dropped++
+				case counts[seq] > 1:
+					r.duplicated += int(counts[seq]) - 1
+				}
+			}
+			// Messages numbered past what was sent can only be duplicates of a miscounted send
+			for seq := total; seq < len(counts); seq++ {
+				r.unknown += int(counts[seq])
+			}
+		}
+		r.delivered += rcv.delivered()
+		r.unknown += rcv.unknown
+		r.latencies = append(r.latencies, rcv.latencies...)
+	}
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+	return r
+}
+
+func (r *report) totalSent() int {
+
+	n := 0
+	for _, s := range r.sent {
+		n += s
+	}
+	return n
+}
+
+// percentile returns the latency below which p percent of the deliveries fall
+func (r *report) percentile(p float64) time.Duration {
+
+	if len(r.latencies) == 0 {
+		return 0
+	}
+	i := int(float64(len(r.latencies))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(r.latencies) {
+		i = len(r.latencies) - 1
+	}
+	return r.latencies[i]
+}
+
+func (r *report) write(w io.Writer) {
+
+	sent, expected := r.totalSent(), r.totalSent()*r.clients
+	rate := func(n int) float64 { return float64(n) / r.duration.Seconds() }
+	ratio := func(n int) float64 {
+		if expected == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(expected)
+	}
+
+	fmt.Fprintf(w, "clients      %v\n", r.clients)
+	fmt.Fprintf(w, "duration     %v\n", r.duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "sent         %v (%.1f/s), %v send errors\n", sent, rate(sent), r.sendErrors)
+	fmt.Fprintf(w, "delivered    %v of %v (%.1f/s)\n", r.delivered, expected, rate(r.delivered))
+	fmt.Fprintf(w, "dropped      %v (%.2f%%)\n", r.dropped, ratio(r.dropped))
+	fmt.Fprintf(w, "duplicated   %v (%.2f%%)\n", r.duplicated, ratio(r.duplicated))
+	if r.unknown > 0 {
+		fmt.Fprintf(w, "unknown      %v\n", r.unknown)
+	}
+	if len(r.latencies) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "latency      p50 %v, p90 %v, p99 %v, p99.9 %v, max %v\n",
+		r.percentile(50).Round(time.Microsecond), r.percentile(90).Round(time.Microsecond),
+		r.percentile(99).Round(time.Microsecond), r.percentile(99.9).Round(time.Microsecond),
+		r.latencies[len(r.latencies)-1].Round(time.Microsecond))
+}
@@ -0,0 +1,634 @@
+// Package chatclient is a client for the chat service. It logs in, keeps the stream open across lost
+// connections and server restarts, and delivers what happens in the chat as typed events:
+//
+//	c, err := chatclient.New(chatclient.Config{
+//		Servers:     []string{"localhost:50051"},
+//		Username:    "bot",
+//		DialOptions: []grpc.DialOption{grpc.WithInsecure()},
+//		Reconnect:   true,
+//	})
+//	...
+//	go c.Run(ctx)
+//	for ev := range c.Events() {
+//		if msg, ok := ev.(chatclient.Message); ok {
+//			c.Send(ctx, msg.Room, "hello "+msg.Name)
+//		}
+//	}
+package chatclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tokenHeader = "x-chat-token"
+	// defaultRetryDelay is how long the client waits before going through the server list again
+	defaultRetryDelay = 5 * time.Second
+	// closeTimeout is how long the client waits for the server to end the stream after closing its side
+	closeTimeout    = 5 * time.Second
+	eventBufferSize = 100
+)
+
+var (
+	// ErrClosed is returned by Send once Run returned without an error
+	ErrClosed = errors.New("chatclient: the client is closed")
+	// ErrNotConnected is returned by the calls made while the client is not logged in
+	ErrNotConnected = status.Error(codes.Unavailable, "not connected")
+	// errStreamClosed is returned by Run when the server ends the stream of a client that does not reconnect
+	errStreamClosed = status.Error(codes.Unavailable, "the server closed the stream")
+	errReconnect    = errors.New("reconnect")
+)
+
+type Config struct {
+	// Servers are the addresses of the servers to connect to, in order
+	Servers  []string
+	Username string
+	// Rooms are joined after every login, along with the rooms joined since
+	Rooms []string
+	// DialOptions are passed to grpc.Dial, they have to set up the transport security e.g. with grpc.WithInsecure()
+	DialOptions []grpc.DialOption
+	// Reconnect keeps the client connected, waiting for restarting servers and failing over to the others.
+	// Otherwise Run returns once the stream ends or when no server is available.
+	Reconnect bool
+	// RetryDelay is how long the client waits when no server is available, 5s when zero
+	RetryDelay time.Duration
+}
+
+type Client struct {
+	cfg    Config
+	events chan Event
+
+	mu    sync.Mutex
+	name  string
+	conn  chat.ChatClient
+	token string
+	// previous is the token of the last login when the client could not log out, it is handed to the
+	// next login so that the server lets go of the name
+	previous string
+	rooms []string
+	// stream is set while the client is connected, ready is closed then
+	stream chat.Chat_StreamClient
+	ready  chan struct{}
+	// sendMu serialises the sends on the stream
+	sendMu sync.Mutex
+
+	// restart asks the current session to log in again, e.g. after a change of name
+	restart chan struct{}
+	done    chan struct{}
+	err     error
+}
+
+func New(cfg Config) (*Client, error) {
+
+	if len(cfg.Servers) == 0 {
+		return nil, errors.New("chatclient: no server to connect to")
+	}
+	if cfg.Username == "" {
+		return nil, errors.New("chatclient: a username is required")
+	}
+	if cfg.RetryDelay == 0 {
+		cfg.RetryDelay = defaultRetryDelay
+	}
+	return &Client{
+		cfg:     cfg,
+		events:  make(chan Event, eventBufferSize),
+		name:    cfg.Username,
+		rooms:   append([]string(nil), cfg.Rooms...),
+		ready:   make(chan struct{}),
+		restart: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}, nil
+}
+
+// Events delivers what happens in the chat, it is closed when Run returns
+func (c *Client) Events() <-chan Event {
+	return c.events
+}
+
+func (c *Client) Name() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.name
+}
+
+// Rename logs in again under the new name
+func (c *Client) Rename(name string) {
+
+	c.mu.Lock()
+	c.name = name
+	c.mu.Unlock()
+	select {
+	case c.restart <- struct{}{}:
+	default:
+	}
+}
+
+// Run connects to the servers and delivers the events until ctx is done, then it closes its stream once
+// the server got everything that was sent, and logs out. Without Config.Reconnect it returns the error
+// that ended the stream as well.
+func (c *Client) Run(ctx context.Context) (err error) {
+
+	defer func() {
+		c.mu.Lock()
+		c.err = err
+		c.mu.Unlock()
+		close(c.done)
+		close(c.events)
+	}()
+
+	servers := c.cfg.Servers
+	reconnecting := false
+	for current, failures := 0, 0; ; {
+		addr := servers[current]
+		shutdown, connected, err := c.session(ctx, addr)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err == errReconnect {
+			continue
+		}
+		if connected {
+			reconnecting = true
+			c.emit(ctx, Disconnected{Addr: addr, Err: err})
+		}
+		// The name stays taken on the other instances until they notice the lost connection
+		if !connected && !retryable(err) && !(reconnecting && status.Code(err) == codes.AlreadyExists) {
+			return err
+		}
+
+		if !c.cfg.Reconnect {
+			if connected {
+				if err == nil {
+					err = errStreamClosed
+				}
+				return err
+			}
+			if current++; current == len(servers) {
+				return err
+			}
+			continue
+		}
+
+		var wait time.Duration
+		switch {
+		case len(shutdown.GetAlternateAddresses()) > 0:
+			servers, current, failures = failover(servers, current, shutdown), 0, 0
+		case shutdown.GetRestartEta() != nil:
+			eta, _ := ptypes.Timestamp(shutdown.GetRestartEta())
+			wait, failures = time.Until(eta), 0
+		default:
+			current = (current + 1) % len(servers)
+			if failures++; failures >= len(servers) {
+				wait, failures = c.cfg.RetryDelay, 0
+			}
+		}
+		if wait > 0 {
+			c.emit(ctx, Reconnecting{Addr: servers[current], At: time.Now().Add(wait)})
+			if !sleep(ctx, wait) {
+				return nil
+			}
+		}
+	}
+}
+
+// retryable reports whether connecting again may help after a failed login
+func retryable(err error) bool {
+	code := status.Code(err)
+	return code == codes.Unavailable || code == codes.DeadlineExceeded
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// failover orders the servers to try after a shutdown: the alternates announced by the server come
+// first, then the configured servers starting with the one after the server that went away
+func failover(servers []string, current int, shutdown *chat.StreamResponse_Shutdown) []string {
+
+	var next []string
+	seen := make(map[string]bool)
+	add := func(addr string) {
+		if addr != "" && !seen[addr] {
+			seen[addr] = true
+			next = append(next, addr)
+		}
+	}
+	for _, addr := range shutdown.GetAlternateAddresses() {
+		add(addr)
+	}
+	for i := 1; i <= len(servers); i++ {
+		add(servers[(current+i)%len(servers)])
+	}
+	return next
+}
+
+func (c *Client) emit(ctx context.Context, ev Event) {
+	select {
+	case c.events <- ev:
+	case <-ctx.Done():
+	}
+}
+
+// session logs in to the server at addr and delivers the events of the stream until it ends. connected
+// reports whether the login succeeded, the shutdown notice is returned when the server sent one.
+func (c *Client) session(ctx context.Context, addr string) (shutdown *chat.StreamResponse_Shutdown, connected bool, err error) {
+
+	cc, err := grpc.Dial(addr, c.cfg.DialOptions...)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cc.Close()
+	conn := chat.NewChatClient(cc)
+
+	// The new name is used by this login already
+	select {
+	case <-c.restart:
+	default:
+	}
+	name := c.Name()
+	c.mu.Lock()
+	previous := c.previous
+	c.mu.Unlock()
+	res, err := conn.Login(ctx, &chat.LoginRequest{Username: name, Token: previous})
+	if err != nil {
+		return nil, false, err
+	}
+	defer func() {
+		logoutCtx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+		_, err := conn.Logout(logoutCtx, &chat.LogoutRequest{Token: res.Token})
+		c.mu.Lock()
+		c.previous = ""
+		if err != nil {
+			c.previous = res.Token
+		}
+		c.mu.Unlock()
+	}()
+
+	c.mu.Lock()
+	c.conn, c.token = conn, res.Token
+	rooms := append([]string(nil), c.rooms...)
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.conn, c.token = nil, ""
+		c.mu.Unlock()
+	}()
+
+	var joined []string
+	for _, room := range rooms {
+		if _, err := conn.JoinRoom(ctx, &chat.JoinRoomRequest{Token: res.Token, Room: room}); err == nil {
+			joined = append(joined, room)
+		}
+	}
+
+	// The stream outlives ctx so that it can be closed cleanly
+	md := metadata.New(map[string]string{tokenHeader: res.Token})
+	streamCtx, cancel := context.WithCancel(metadata.NewOutgoingContext(context.Background(), md))
+	defer cancel()
+	stream, err := conn.Stream(streamCtx)
+	if err != nil {
+		return nil, true, err
+	}
+	// The server sends the headers once the stream is registered, the events published from then on are delivered
+	if _, err := stream.Header(); err != nil {
+		return nil, true, err
+	}
+
+	c.mu.Lock()
+	c.stream = stream
+	close(c.ready)
+	c.mu.Unlock()
+	defer c.detach(stream)
+	c.emit(ctx, Connected{Addr: addr, Name: name, Rooms: joined})
+
+	// Close our side when leaving, the server ends the stream once it published what was sent
+	var restart bool
+	closing, stop := make(chan struct{}), make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-c.restart:
+			restart = true
+		case <-stop:
+			return
+		}
+		close(closing)
+		// Send waits for the next stream from now on
+		c.detach(stream)
+		c.sendMu.Lock()
+		err := stream.CloseSend()
+		c.sendMu.Unlock()
+		if err != nil {
+			cancel()
+			return
+		}
+		time.AfterFunc(closeTimeout, cancel)
+	}()
+
+	shutdown, err = c.receive(ctx, stream)
+	select {
+	case <-closing:
+		if restart {
+			return shutdown, true, errReconnect
+		}
+		return shutdown, true, nil
+	default:
+	}
+	if err == io.EOF {
+		err = nil
+	}
+	return shutdown, true, err
+}
+
+// detach stops the sends on stream, if it is still the current one
+func (c *Client) detach(stream chat.Chat_StreamClient) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stream == stream {
+		c.stream, c.ready = nil, make(chan struct{})
+	}
+}
+
+// receive delivers the events of the stream until it ends, returning the shutdown notice if the server sent one
+func (c *Client) receive(ctx context.Context, stream chat.Chat_StreamClient) (*chat.StreamResponse_Shutdown, error) {
+
+	var shutdown *chat.StreamResponse_Shutdown
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			return shutdown, err
+		}
+		if s := res.GetServerShutdown(); s != nil {
+			shutdown = s
+		}
+		if ev := decode(res); ev != nil {
+			c.emit(ctx, ev)
+		}
+	}
+}
+
+// Send posts the text to the room, the lobby when room is empty. It waits for the client to be connected.
+func (c *Client) Send(ctx context.Context, room, text string) error {
+	return c.send(ctx, &chat.StreamRequest{Message: text, Room: room})
+}
+
+// SendTo sends the text to the user alone, it comes back to the client as a Message with To set
+func (c *Client) SendTo(ctx context.Context, to, text string) error {
+	return c.send(ctx, &chat.StreamRequest{Message: text, To: to})
+}
+
+func (c *Client) send(ctx context.Context, req *chat.StreamRequest) error {
+
+	for {
+		c.mu.Lock()
+		stream, ready, name := c.stream, c.ready, c.name
+		c.mu.Unlock()
+
+		if stream != nil {
+			c.sendMu.Lock()
+			defer c.sendMu.Unlock()
+			req.Name = name
+			return stream.Send(req)
+		}
+
+		select {
+		case <-ready:
+		case <-c.done:
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if c.err != nil {
+				return c.err
+			}
+			return ErrClosed
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// Post posts the text to the room, the lobby when room is empty, with the unary Post RPC. Unlike Send it
+// returns the error of the server when the message is refused.
+func (c *Client) Post(ctx context.Context, room, text string) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Post(ctx, &chat.PostRequest{Token: token, Room: room, Message: text})
+	return err
+}
+
+// connection returns the connection and the token of the current login
+func (c *Client) connection() (chat.ChatClient, string, error) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.conn == nil {
+		return nil, "", ErrNotConnected
+	}
+	return c.conn, c.token, nil
+}
+
+// remember adds the room to the rooms joined after every login
+func (c *Client) remember(room string) {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, r := range c.rooms {
+		if r == room {
+			return
+		}
+	}
+	c.rooms = append(c.rooms, room)
+}
+
+// Join joins the room, it is joined again after every login
+func (c *Client) Join(ctx context.Context, room string) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.JoinRoom(ctx, &chat.JoinRoomRequest{Token: token, Room: room}); err != nil {
+		return err
+	}
+	c.remember(room)
+	return nil
+}
+
+// CreateRoom creates the room, the client becomes a member of it
+func (c *Client) CreateRoom(ctx context.Context, room string, visibility chat.Visibility) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.CreateRoom(ctx, &chat.CreateRoomRequest{Token: token, Name: room, Visibility: visibility}); err != nil {
+		return err
+	}
+	c.remember(room)
+	return nil
+}
+
+func (c *Client) Invite(ctx context.Context, room, username string) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Invite(ctx, &chat.InviteRequest{Token: token, Room: room, Username: username})
+	return err
+}
+
+func (c *Client) AcceptInvite(ctx context.Context, room string) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	if _, err := conn.AcceptInvite(ctx, &chat.AcceptInviteRequest{Token: token, Room: room}); err != nil {
+		return err
+	}
+	c.remember(room)
+	return nil
+}
+
+// ListRooms returns the rooms visible to the client, sorted by name
+func (c *Client) ListRooms(ctx context.Context) ([]*chat.Room, error) {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.ListRooms(ctx, &chat.ListRoomsRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	return res.Rooms, nil
+}
+
+// ListCommands returns the slash commands the bots registered, sorted by command
+func (c *Client) ListCommands(ctx context.Context) ([]*chat.Command, error) {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.ListCommands(ctx, &chat.ListCommandsRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res.Commands, func(i, j int) bool { return res.Commands[i].Command < res.Commands[j].Command })
+	return res.Commands, nil
+}
+
+// ListUsers returns the names of the users logged in, sorted
+func (c *Client) ListUsers(ctx context.Context) ([]string, error) {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.ListUsers(ctx, &chat.ListUsersRequest{Token: token})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(res.Usernames)
+	return res.Usernames, nil
+}
+
+// History returns the last messages of the room, oldest first, all the messages the server kept when limit is 0
+func (c *Client) History(ctx context.Context, room string, limit int) ([]Message, error) {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return nil, err
+	}
+	res, err := conn.History(ctx, &chat.HistoryRequest{Token: token, Room: room, Limit: int32(limit)})
+	if err != nil {
+		return nil, err
+	}
+	var messages []Message
+	for _, r := range res.Messages {
+		if msg, ok := decode(r).(Message); ok {
+			messages = append(messages, msg)
+		}
+	}
+	return messages, nil
+}
+
+// GrantRole gives the role to the user in the room, chat-wide when room is empty
+func (c *Client) GrantRole(ctx context.Context, room, username string, role chat.Role) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	_, err = conn.GrantRole(ctx, &chat.GrantRoleRequest{Token: token, Room: room, Username: username, Role: role})
+	return err
+}
+
+// RevokeRole drops the role of the user in the room, the chat-wide one goes back to the default when room is
+// empty
+func (c *Client) RevokeRole(ctx context.Context, room, username string) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	_, err = conn.RevokeRole(ctx, &chat.RevokeRoleRequest{Token: token, Room: room, Username: username})
+	return err
+}
+
+func (c *Client) Kick(ctx context.Context, room, username string) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	_, err = conn.Kick(ctx, &chat.KickRequest{Token: token, Room: room, Username: username})
+	return err
+}
+
+// EditMessage replaces the text of the message id of the room
+func (c *Client) EditMessage(ctx context.Context, room, id, text string) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	_, err = conn.EditMessage(ctx, &chat.EditMessageRequest{Token: token, Room: room, Id: id, Message: text})
+	return err
+}
+
+func (c *Client) DeleteMessage(ctx context.Context, room, id string) error {
+
+	conn, token, err := c.connection()
+	if err != nil {
+		return err
+	}
+	_, err = conn.DeleteMessage(ctx, &chat.DeleteMessageRequest{Token: token, Room: room, Id: id})
+	return err
+}
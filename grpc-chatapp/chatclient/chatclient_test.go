@@ -0,0 +1,323 @@
+package chatclient
+
+import (
+	"context"
+	"io"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+// fakeServer is an in-process chat server that fans the messages out to every stream
+type fakeServer struct {
+	chat.UnimplementedChatServer
+
+	mu      sync.Mutex
+	reject  error
+	logouts []string
+	joins   []string
+	streams map[chan *chat.StreamResponse]bool
+	kick    chan struct{}
+}
+
+func (f *fakeServer) Login(ctx context.Context, req *chat.LoginRequest) (*chat.LoginResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.reject != nil {
+		return nil, f.reject
+	}
+	return &chat.LoginResponse{Token: req.Username}, nil
+}
+
+func (f *fakeServer) Logout(ctx context.Context, req *chat.LogoutRequest) (*chat.LogoutResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.logouts = append(f.logouts, req.Token)
+	return &chat.LogoutResponse{}, nil
+}
+
+func (f *fakeServer) JoinRoom(ctx context.Context, req *chat.JoinRoomRequest) (*chat.JoinRoomResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.joins = append(f.joins, req.Token+":"+req.Room)
+	return &chat.JoinRoomResponse{}, nil
+}
+
+func (f *fakeServer) broadcast(res *chat.StreamResponse) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for ch := range f.streams {
+		ch <- res
+	}
+}
+
+func (f *fakeServer) Stream(stream chat.Chat_StreamServer) error {
+
+	ch := make(chan *chat.StreamResponse, 10)
+	f.mu.Lock()
+	f.streams[ch] = true
+	kick := f.kick
+	f.mu.Unlock()
+	defer func() {
+		f.mu.Lock()
+		delete(f.streams, ch)
+		f.mu.Unlock()
+	}()
+	if err := stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	received := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				received <- err
+				return
+			}
+			f.broadcast(&chat.StreamResponse{
+				Timestamp: ptypes.TimestampNow(),
+				Event: &chat.StreamResponse_ClientMessage{
+					ClientMessage: &chat.StreamResponse_Message{Name: req.Name, Message: req.Message, Room: req.Room},
+				},
+			})
+		}
+	}()
+
+	for {
+		select {
+		case res := <-ch:
+			if err := stream.Send(res); err != nil {
+				return err
+			}
+		case err := <-received:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-kick:
+			// Flush what was queued, the shutdown notice included
+			for len(ch) > 0 {
+				stream.Send(<-ch)
+			}
+			return nil
+		}
+	}
+}
+
+// shutdown sends the notice to every stream and ends them
+func (f *fakeServer) shutdown(notice *chat.StreamResponse_Shutdown) {
+	f.broadcast(&chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event:     &chat.StreamResponse_ServerShutdown{ServerShutdown: notice},
+	})
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	close(f.kick)
+	f.kick = make(chan struct{})
+}
+
+func (f *fakeServer) loggedOut(name string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, n := range f.logouts {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// startServer serves a fake server on an in-memory listener and returns the options to dial it
+func startServer(t *testing.T) (*fakeServer, []grpc.DialOption) {
+
+	lis := bufconn.Listen(1 << 20)
+	f := &fakeServer{streams: make(map[chan *chat.StreamResponse]bool), kick: make(chan struct{})}
+	s := grpc.NewServer()
+	chat.RegisterChatServer(s, f)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	return f, []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.Dial() }),
+	}
+}
+
+// run starts a client and returns a function that stops it and returns the error of Run
+func run(t *testing.T, cfg Config) (*Client, func() error) {
+
+	c, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	return c, func() error {
+		cancel()
+		// Drain the events so that Run is never blocked on them
+		for range c.Events() {
+		}
+		return <-done
+	}
+}
+
+// next waits for the next event of the same type as want, skipping the others
+func next(t *testing.T, c *Client, want Event) Event {
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev, ok := <-c.Events():
+			if !ok {
+				t.Fatalf("events closed while waiting for a %T", want)
+			}
+			if reflect.TypeOf(ev) == reflect.TypeOf(want) {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for a %T", want)
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	goleak.VerifyTestMain(m)
+}
+
+func TestSendAndReceive(t *testing.T) {
+
+	f, opts := startServer(t)
+
+	alice, stopAlice := run(t, Config{Servers: []string{"chat"}, Username: "alice", DialOptions: opts, Reconnect: true})
+	bob, stopBob := run(t, Config{Servers: []string{"chat"}, Username: "bob", DialOptions: opts, Reconnect: true})
+	next(t, alice, Connected{})
+	next(t, bob, Connected{})
+
+	if err := alice.Send(context.Background(), "ops", "hello"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	msg := next(t, bob, Message{}).(Message)
+	if msg.Name != "alice" || msg.Room != "ops" || msg.Text != "hello" || msg.Response() == nil {
+		t.Fatalf("bob received %+v, want the message of alice", msg)
+	}
+
+	if err := stopAlice(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if !f.loggedOut("alice") {
+		t.Fatalf("alice did not log out")
+	}
+	if err := alice.Send(context.Background(), "ops", "too late"); err != ErrClosed {
+		t.Fatalf("Send() after Run error = %v, want %v", err, ErrClosed)
+	}
+	stopBob()
+}
+
+func TestReconnectAfterRestart(t *testing.T) {
+
+	f, opts := startServer(t)
+
+	c, stop := run(t, Config{Servers: []string{"chat"}, Username: "alice", Rooms: []string{"ops"}, DialOptions: opts, Reconnect: true})
+	defer stop()
+	next(t, c, Connected{})
+
+	eta, _ := ptypes.TimestampProto(time.Now().Add(50 * time.Millisecond))
+	f.shutdown(&chat.StreamResponse_Shutdown{Reason: "upgrade", RestartEta: eta})
+
+	if shutdown := next(t, c, Shutdown{}).(Shutdown); shutdown.Reason != "upgrade" || shutdown.RestartETA.IsZero() {
+		t.Fatalf("unexpected shutdown event %+v", shutdown)
+	}
+	next(t, c, Disconnected{})
+	next(t, c, Reconnecting{})
+	if connected := next(t, c, Connected{}).(Connected); !reflect.DeepEqual(connected.Rooms, []string{"ops"}) {
+		t.Fatalf("reconnected with the rooms %v, want [ops]", connected.Rooms)
+	}
+}
+
+func TestRename(t *testing.T) {
+
+	f, opts := startServer(t)
+
+	c, stop := run(t, Config{Servers: []string{"chat"}, Username: "alice", DialOptions: opts, Reconnect: true})
+	defer stop()
+	next(t, c, Connected{})
+
+	c.Rename("alicia")
+	if connected := next(t, c, Connected{}).(Connected); connected.Name != "alicia" {
+		t.Fatalf("reconnected as %v, want alicia", connected.Name)
+	}
+	if !f.loggedOut("alice") {
+		t.Fatalf("the old name was not logged out")
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+
+	f, opts := startServer(t)
+
+	// A stream ended by the server ends a client that does not reconnect
+	c, err := New(Config{Servers: []string{"chat"}, Username: "alice", DialOptions: opts})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- c.Run(context.Background()) }()
+	next(t, c, Connected{})
+	f.shutdown(&chat.StreamResponse_Shutdown{Reason: "maintenance"})
+	for range c.Events() {
+	}
+	if err := <-done; status.Code(err) != codes.Unavailable {
+		t.Fatalf("Run() error = %v, want %v", err, codes.Unavailable)
+	}
+
+	// A rejected login ends the client even when it reconnects
+	f.mu.Lock()
+	f.reject = status.Error(codes.InvalidArgument, "bad name")
+	f.mu.Unlock()
+	c, err = New(Config{Servers: []string{"chat"}, Username: "alice", DialOptions: opts, Reconnect: true})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if err := c.Run(context.Background()); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Run() error = %v, want %v", err, codes.InvalidArgument)
+	}
+	if err := c.Send(context.Background(), "", "hello"); status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Send() error = %v, want the error of Run", err)
+	}
+}
+
+func TestFailover(t *testing.T) {
+
+	servers := []string{"a:1", "b:1", "c:1"}
+	tests := []struct {
+		name       string
+		current    int
+		alternates []string
+		want       []string
+	}{
+		{"next configured server", 0, nil, []string{"b:1", "c:1", "a:1"}},
+		{"wraps around", 2, nil, []string{"a:1", "b:1", "c:1"}},
+		{"alternates first", 1, []string{"x:1", "y:1"}, []string{"x:1", "y:1", "c:1", "a:1", "b:1"}},
+		{"no duplicates", 0, []string{"c:1", "x:1", "c:1"}, []string{"c:1", "x:1", "b:1", "a:1"}},
+	}
+
+	for _, tt := range tests {
+		shutdown := &chat.StreamResponse_Shutdown{AlternateAddresses: tt.alternates}
+		if got := failover(servers, tt.current, shutdown); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: failover() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package chatclient
+
+import (
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+)
+
+// Event is what the client delivers on Events: Connected, Disconnected and Reconnecting
+// describe the connection, Message, Edit, Delete, Kick, Refused, Login, Logout and Shutdown come from the server
+type Event interface {
+	isEvent()
+}
+
+// Connected is sent once the client logged in and opened its stream
+type Connected struct {
+	Addr, Name string
+	// Rooms are the rooms the client joined again after the login
+	Rooms []string
+}
+
+// Disconnected is sent when the stream to the server ends, Err is nil when the server closed it
+type Disconnected struct {
+	Addr string
+	Err  error
+}
+
+// Reconnecting is sent before the client waits to connect to Addr at the given time
+type Reconnecting struct {
+	Addr string
+	At   time.Time
+}
+
+// Header is common to the events sent by the server
+type Header struct {
+	Time time.Time
+	res  *chat.StreamResponse
+}
+
+// Response is the server response the event was decoded from
+func (h Header) Response() *chat.StreamResponse {
+	return h.res
+}
+
+// ID is the one EditMessage and DeleteMessage take. To is the recipient of a direct message, Room is empty
+// then.
+type Message struct {
+	Header
+	ID, Name, Room, To, Text string
+}
+
+// Edit replaces the text of the message ID, Name is the user who edited it
+type Edit struct {
+	Header
+	ID, Name, Room, Text string
+}
+
+// Delete removes the message ID, Name is the user who deleted it
+type Delete struct {
+	Header
+	ID, Name, Room string
+}
+
+// Kick tells that Name was kicked out of the room By a moderator
+type Kick struct {
+	Header
+	Name, Room, By string
+}
+
+// Refused tells that the server refused the Text sent with Send or SendTo, Code is the reason Post would have
+// failed with
+type Refused struct {
+	Header
+	Room, To, Text, Reason string
+	Code                   codes.Code
+}
+
+type Login struct {
+	Header
+	Name string
+}
+
+type Logout struct {
+	Header
+	Name string
+}
+
+// Shutdown announces that the server goes away by Deadline, RestartETA is zero when
+// it is not coming back and Alternates are the servers the client may move to
+type Shutdown struct {
+	Header
+	Reason               string
+	Deadline, RestartETA time.Time
+	Alternates           []string
+}
+
+func (Connected) isEvent()    {}
+func (Disconnected) isEvent() {}
+func (Reconnecting) isEvent() {}
+func (Message) isEvent()      {}
+func (Edit) isEvent()         {}
+func (Delete) isEvent()       {}
+func (Kick) isEvent()         {}
+func (Refused) isEvent()      {}
+func (Login) isEvent()        {}
+func (Logout) isEvent()       {}
+func (Shutdown) isEvent()     {}
+
+// decode turns a server response into its event, it returns nil for the events it does not know
+func decode(res *chat.StreamResponse) Event {
+
+	h := Header{Time: time.Now(), res: res}
+	if t, err := ptypes.Timestamp(res.Timestamp); err == nil {
+		h.Time = t.In(time.Local)
+	}
+
+	switch ev := res.Event.(type) {
+	case *chat.StreamResponse_ClientMessage:
+		msg := ev.ClientMessage
+		return Message{Header: h, ID: msg.Id, Name: msg.Name, Room: msg.Room, To: msg.To, Text: msg.Message}
+	case *chat.StreamResponse_MessageEdit:
+		edit := ev.MessageEdit
+		return Edit{Header: h, ID: edit.Id, Name: edit.Name, Room: edit.Room, Text: edit.Message}
+	case *chat.StreamResponse_MessageDelete:
+		return Delete{Header: h, ID: ev.MessageDelete.Id, Name: ev.MessageDelete.Name, Room: ev.MessageDelete.Room}
+	case *chat.StreamResponse_ClientKick:
+		return Kick{Header: h, Name: ev.ClientKick.Name, Room: ev.ClientKick.Room, By: ev.ClientKick.By}
+	case *chat.StreamResponse_MessageRefused:
+		r := ev.MessageRefused
+		return Refused{Header: h, Room: r.Room, To: r.To, Text: r.Message, Code: codes.Code(r.Code), Reason: r.Reason}
+	case *chat.StreamResponse_ClientLogin:
+		return Login{Header: h, Name: ev.ClientLogin.Name}
+	case *chat.StreamResponse_ClientLogout:
+		return Logout{Header: h, Name: ev.ClientLogout.Name}
+	case *chat.StreamResponse_ServerShutdown:
+		shutdown := Shutdown{Header: h, Reason: ev.ServerShutdown.Reason, Alternates: ev.ServerShutdown.AlternateAddresses}
+		if t, err := ptypes.Timestamp(ev.ServerShutdown.Deadline); err == nil {
+			shutdown.Deadline = t.In(time.Local)
+		}
+		if t, err := ptypes.Timestamp(ev.ServerShutdown.RestartEta); err == nil {
+			shutdown.RestartETA = t.In(time.Local)
+		}
+		return shutdown
+	}
+	return nil
+}
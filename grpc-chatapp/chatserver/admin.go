@@ -0,0 +1,96 @@
+package chatserver
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// admin serves the Admin service to the owners of the chat, and of the rooms for the webhook approvals
+type admin struct {
+	chat *server
+}
+
+func (a *admin) WebhookStatus(ctx context.Context, req *chat.WebhookStatusRequest) (*chat.WebhookStatusResponse, error) {
+
+	if _, err := a.chat.authorize(req.Token, actionAdminister); err != nil {
+		return nil, err
+	}
+	res := &chat.WebhookStatusResponse{}
+	if a.chat.webhooks == nil {
+		return res, nil
+	}
+	for _, st := range a.chat.webhooks.Status() {
+		w := &chat.WebhookStatus{
+			Id:             st.ID,
+			Room:           st.Room,
+			Events:         st.Events,
+			Url:            st.URL,
+			Pending:        int32(st.Pending),
+			Delivered:      st.Delivered,
+			FailedAttempts: st.FailedAttempts,
+			Dropped:        st.Dropped,
+			DeadLettered:   st.DeadLettered,
+			LastError:      st.LastError,
+			Approved:       st.Approved,
+		}
+		if !st.LastDelivery.IsZero() {
+			w.LastDelivery, _ = ptypes.TimestampProto(st.LastDelivery)
+		}
+		for _, dl := range st.DeadLetters {
+			at, _ := ptypes.TimestampProto(dl.At)
+			w.DeadLetters = append(w.DeadLetters, &chat.WebhookStatus_DeadLetter{
+				DeliveryId: dl.DeliveryID,
+				Event:      dl.Event,
+				Attempts:   int32(dl.Attempts),
+				Error:      dl.Error,
+				At:         at,
+				Payload:    dl.Payload,
+			})
+		}
+		res.Webhooks = append(res.Webhooks, w)
+	}
+	return res, nil
+}
+
+func (a *admin) ApproveWebhook(ctx context.Context, req *chat.ApproveWebhookRequest) (*chat.ApproveWebhookResponse, error) {
+
+	level.Info(a.chat.log(ctx)).Log("message", "new approve webhook request", "webhook", req.Id)
+	name, ok := a.chat.getClientName(req.Token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if a.chat.webhooks == nil {
+		return nil, status.Error(codes.NotFound, "webhook not found")
+	}
+	room, found := "", false
+	for _, st := range a.chat.webhooks.Status() {
+		if st.ID == req.Id {
+			room, found = st.Room, true
+		}
+	}
+	if !found {
+		return nil, status.Error(codes.NotFound, "webhook not found")
+	}
+	// The webhooks without a room only get the messages of the public rooms, there is nothing to approve
+	if room == "" {
+		return nil, status.Error(codes.FailedPrecondition, "the webhook has no room")
+	}
+	if !a.chat.canReadRoom(name, room) {
+		return nil, errRoomNotFound
+	}
+	if _, err := a.chat.authorizeIn(req.Token, room, actionAdminister); err != nil {
+		return nil, err
+	}
+	a.chat.webhooks.Approve(req.Id)
+	a.chat.share(ctx, &chat.StateChange{
+		Key:    "approval/" + req.Id,
+		Change: &chat.StateChange_ApprovedWebhook{ApprovedWebhook: req.Id},
+	})
+	level.Info(a.chat.log(ctx)).Log("message", "webhook approved", "webhook", req.Id, "room", room)
+	return &chat.ApproveWebhookResponse{}, nil
+}
@@ -0,0 +1,186 @@
+package chatserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/webhook"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestWebhookStatus(t *testing.T) {
+
+	received := make(chan string, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("X-Chat-Event")
+	}))
+	defer hook.Close()
+
+	s := chattest.NewServer(t, chatserver.Options{Webhooks: []webhook.Subscription{
+		{ID: "ci", Room: "lobby", Events: []string{webhook.EventMessage}, URL: hook.URL, Secret: "s3cr3t"},
+	}})
+	cc := s.Dial()
+	client, admin := chat.NewChatClient(cc), chat.NewAdminClient(cc)
+	ctx := context.Background()
+	// The first user owns the chat
+	owner, err := client.Login(ctx, &chat.LoginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	member, err := client.Login(ctx, &chat.LoginRequest{Username: "bob"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"owner", owner.Token, codes.OK},
+		{"member", member.Token, codes.PermissionDenied},
+		{"unknown token", "unknown", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		_, err := admin.WebhookStatus(ctx, &chat.WebhookStatusRequest{Token: tt.token})
+		if code := status.Code(err); code != tt.want {
+			t.Errorf("%v: WebhookStatus() code = %v, want %v", tt.name, code, tt.want)
+		}
+	}
+
+	if _, err := client.Post(ctx, &chat.PostRequest{Token: member.Token, Message: "build passed"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	select {
+	case ev := <-received:
+		if ev != webhook.EventMessage {
+			t.Fatalf("the webhook received a %v event, want a message", ev)
+		}
+	case <-time.After(chattest.Timeout):
+		t.Fatal("the webhook received nothing")
+	}
+
+	// The status is updated right after the delivery
+	deadline := time.Now().Add(chattest.Timeout)
+	for {
+		res, err := admin.WebhookStatus(ctx, &chat.WebhookStatusRequest{Token: owner.Token})
+		if err != nil {
+			t.Fatalf("WebhookStatus() error = %v", err)
+		}
+		if len(res.Webhooks) != 1 || res.Webhooks[0].Id != "ci" || res.Webhooks[0].Url != hook.URL {
+			t.Fatalf("WebhookStatus() = %v, want the ci webhook", res.Webhooks)
+		}
+		if res.Webhooks[0].Delivered == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("WebhookStatus() = %v, want one delivery", res.Webhooks[0])
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestApproveWebhook(t *testing.T) {
+
+	received := make(chan webhook.Payload, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var p webhook.Payload
+		json.NewDecoder(r.Body).Decode(&p)
+		received <- p
+	}))
+	defer hook.Close()
+
+	s := chattest.NewServer(t, chatserver.Options{Webhooks: []webhook.Subscription{
+		{ID: "ops", Room: "ops", Events: []string{webhook.EventMessage}, URL: hook.URL, Secret: "s3cr3t"},
+		{ID: "all", Events: []string{webhook.EventMessage}, URL: hook.URL, Secret: "s3cr3t"},
+	}})
+	cc := s.Dial()
+	client, admin := chat.NewChatClient(cc), chat.NewAdminClient(cc)
+	ctx := context.Background()
+	tokens := make(map[string]string)
+	for _, name := range []string{"alice", "bob", "carol", "dave"} {
+		res, err := client.Login(ctx, &chat.LoginRequest{Username: name})
+		if err != nil {
+			t.Fatalf("Login() error = %v", err)
+		}
+		tokens[name] = res.Token
+	}
+	// bob owns the private room, carol is a member of it
+	if _, err := client.CreateRoom(ctx, &chat.CreateRoomRequest{Token: tokens["bob"], Name: "ops", Visibility: chat.Visibility_PRIVATE}); err != nil {
+		t.Fatalf("CreateRoom() error = %v", err)
+	}
+	if _, err := client.Invite(ctx, &chat.InviteRequest{Token: tokens["bob"], Room: "ops", Username: "carol"}); err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	if _, err := client.AcceptInvite(ctx, &chat.AcceptInviteRequest{Token: tokens["carol"], Room: "ops"}); err != nil {
+		t.Fatalf("AcceptInvite() error = %v", err)
+	}
+	// Neither webhook gets the messages of the private room before the approval
+	if _, err := client.Post(ctx, &chat.PostRequest{Token: tokens["bob"], Room: "ops", Message: "before"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		id    string
+		want  codes.Code
+	}{
+		{"unknown token", "unknown", "ops", codes.Unauthenticated},
+		{"unknown webhook", tokens["bob"], "builds", codes.NotFound},
+		{"webhook without a room", tokens["bob"], "all", codes.FailedPrecondition},
+		{"owner of the chat outside the room", tokens["alice"], "ops", codes.NotFound},
+		{"outside the room", tokens["dave"], "ops", codes.NotFound},
+		{"member of the room", tokens["carol"], "ops", codes.PermissionDenied},
+		{"owner of the room", tokens["bob"], "ops", codes.OK},
+	}
+	for _, tt := range tests {
+		_, err := admin.ApproveWebhook(ctx, &chat.ApproveWebhookRequest{Token: tt.token, Id: tt.id})
+		if code := status.Code(err); code != tt.want {
+			t.Errorf("%v: ApproveWebhook(%q) code = %v, want %v", tt.name, tt.id, code, tt.want)
+		}
+	}
+
+	if _, err := client.Post(ctx, &chat.PostRequest{Token: tokens["bob"], Room: "ops", Message: "after"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if _, err := client.Post(ctx, &chat.PostRequest{Token: tokens["bob"], Message: "hello"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	got := make(map[string]string)
+	for len(got) < 2 {
+		select {
+		case p := <-received:
+			if _, ok := got[p.Message]; ok || p.Message == "before" {
+				t.Fatalf("the webhooks received %q in %v, want after in ops and hello in the lobby once", p.Message, p.Room)
+			}
+			got[p.Message] = p.Room
+		case <-time.After(chattest.Timeout):
+			t.Fatalf("the webhooks received %v, want after in ops and hello in the lobby", got)
+		}
+	}
+	if got["after"] != "ops" || got["hello"] != "lobby" {
+		t.Errorf("the webhooks received %v, want after in ops and hello in the lobby", got)
+	}
+	select {
+	case p := <-received:
+		t.Errorf("the webhooks received %q in %v as well", p.Message, p.Room)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	res, err := admin.WebhookStatus(ctx, &chat.WebhookStatusRequest{Token: tokens["alice"]})
+	if err != nil {
+		t.Fatalf("WebhookStatus() error = %v", err)
+	}
+	if len(res.Webhooks) != 2 || !res.Webhooks[0].Approved || res.Webhooks[1].Approved {
+		t.Errorf("WebhookStatus() = %v, want the ops webhook approved alone", res.Webhooks)
+	}
+}
@@ -0,0 +1,252 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// minAPIKeySize keeps the API keys, which do not expire, hard to guess
+	minAPIKeySize = 16
+	// answerWindow is how long a bot may post to the room of an invocation it cannot read otherwise
+	answerWindow = time.Minute
+)
+
+var (
+	// commandName is what the names of the slash commands look like, without the slash
+	commandName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,31}$`)
+	// reservedCommands are handled by the client, the bots cannot claim them
+	reservedCommands = map[string]bool{
+		"help": true, "quit": true, "nick": true, "who": true, "rooms": true, "join": true, "create": true,
+		"invite": true, "kick": true, "accept": true, "msg": true, "me": true,
+	}
+)
+
+// Bot is an account for the programs taking part in the chat. It does not log in, its API key is the token
+// of every call it makes, and it claims slash commands with RegisterCommand.
+type Bot struct {
+	// Name is the identity the bot posts as, nobody can log in under it
+	Name   string `json:"name"`
+	APIKey string `json:"api_key"`
+}
+
+// command is a slash command and the bot it belongs to
+type command struct {
+	bot, description string
+}
+
+// useBots checks the bots and sets their identities up
+func (s *server) useBots(bots []Bot) error {
+
+	keys := make(map[string]string)
+	names := make(map[string]bool)
+	for _, b := range bots {
+		if b.Name == "" || b.APIKey == "" {
+			return fmt.Errorf("bot %q: name and api key are required", b.Name)
+		}
+		if strings.Contains(b.Name, domainSeparator) {
+			return fmt.Errorf("bot %q: the name cannot contain %v", b.Name, domainSeparator)
+		}
+		if len(b.APIKey) < minAPIKeySize {
+			return fmt.Errorf("bot %q: the api key needs %d characters at least", b.Name, minAPIKeySize)
+		}
+		if names[b.Name] {
+			return fmt.Errorf("bot %q: the name is used twice", b.Name)
+		}
+		if _, ok := keys[b.APIKey]; ok {
+			return fmt.Errorf("bot %q: the api key is used twice", b.Name)
+		}
+		names[b.Name] = true
+		keys[b.APIKey] = b.Name
+	}
+
+	s.roleMutex.Lock()
+	defer s.roleMutex.Unlock()
+	for _, b := range bots {
+		s.reserved[b.Name] = true
+		if _, ok := s.ClientRole[roleKey{user: b.Name}]; !ok {
+			s.ClientRole[roleKey{user: b.Name}] = defaultRole
+		}
+	}
+	s.bots = keys
+	return nil
+}
+
+// isBot reports whether the name belongs to a bot
+func (s *server) isBot(name string) bool {
+
+	for _, n := range s.bots {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *server) RegisterCommand(ctx context.Context, req *chat.RegisterCommandRequest) (*chat.RegisterCommandResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new register command request", "command", req.Command)
+	name, ok := s.getClientName(req.Token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if !s.isBot(name) {
+		return nil, status.Error(codes.PermissionDenied, "only bots can register commands")
+	}
+	cmd := strings.TrimPrefix(req.Command, "/")
+	if !commandName.MatchString(cmd) {
+		return nil, status.Error(codes.InvalidArgument, "command names are made of lowercase letters, digits and dashes")
+	}
+	if reservedCommands[cmd] {
+		return nil, status.Error(codes.InvalidArgument, "command is reserved by the client")
+	}
+
+	s.commandMutex.Lock()
+	// Registering again updates the description, the bots do it whenever they connect
+	if c, ok := s.commands[cmd]; ok && c.bot != name {
+		s.commandMutex.Unlock()
+		return nil, status.Error(codes.AlreadyExists, "command is registered by another bot")
+	}
+	s.commands[cmd] = command{bot: name, description: req.Description}
+	s.commandMutex.Unlock()
+
+	s.share(ctx, &chat.StateChange{
+		Key:    "command/" + cmd,
+		Change: &chat.StateChange_Command{Command: &chat.Command{Command: cmd, Description: req.Description, Bot: name}},
+	})
+	return &chat.RegisterCommandResponse{}, nil
+}
+
+func (s *server) ListCommands(ctx context.Context, req *chat.ListCommandsRequest) (*chat.ListCommandsResponse, error) {
+
+	if _, ok := s.getClientName(req.Token); !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	s.commandMutex.RLock()
+	defer s.commandMutex.RUnlock()
+	res := &chat.ListCommandsResponse{}
+	for cmd, c := range s.commands {
+		res.Commands = append(res.Commands, &chat.Command{Command: cmd, Description: c.description, Bot: c.bot})
+	}
+	sort.Slice(res.Commands, func(i, j int) bool { return res.Commands[i].Command < res.Commands[j].Command })
+	return res, nil
+}
+
+// invocation returns the invocation of the registered command the text starts with, if any
+func (s *server) invocation(name, room, text string) (*chat.CommandInvocation, bool) {
+
+	if !strings.HasPrefix(text, "/") {
+		return nil, false
+	}
+	cmd, args := text[1:], ""
+	if i := strings.IndexFunc(cmd, unicode.IsSpace); i >= 0 {
+		cmd, args = cmd[:i], strings.TrimSpace(cmd[i:])
+	}
+
+	s.commandMutex.RLock()
+	c, ok := s.commands[cmd]
+	s.commandMutex.RUnlock()
+	if !ok {
+		return nil, false
+	}
+	return &chat.CommandInvocation{Bot: c.bot, Command: cmd, Args: args, Name: name, Room: room}, true
+}
+
+// isConnected reports whether the bot has a stream open to receive the invocations, here or on another
+// instance
+func (s *server) isConnected(ctx context.Context, bot string) bool {
+
+	s.streamMutex.RLock()
+	for key, name := range s.bots {
+		if _, ok := s.ClientStream[key]; ok && name == bot {
+			s.streamMutex.RUnlock()
+			return true
+		}
+	}
+	s.streamMutex.RUnlock()
+	return s.isRemoteUser(ctx, bot)
+}
+
+// answer lets the bot of the invocation post to its room for a while, every instance records it when the
+// invocation is delivered as the bot may answer through any of them
+func (s *server) answer(inv *chat.CommandInvocation) {
+
+	now := time.Now()
+	s.commandMutex.Lock()
+	defer s.commandMutex.Unlock()
+	for k, until := range s.answers {
+		if now.After(until) {
+			delete(s.answers, k)
+		}
+	}
+	s.answers[roleKey{room: inv.Room, user: inv.Bot}] = now.Add(answerWindow)
+}
+
+// canAnswer reports whether the bot was invoked in the room lately and may still answer there, unless
+// it was kicked out of it
+func (s *server) canAnswer(name, room string) bool {
+
+	s.commandMutex.RLock()
+	until, ok := s.answers[roleKey{room: room, user: name}]
+	s.commandMutex.RUnlock()
+	if !ok || time.Now().After(until) {
+		return false
+	}
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	r, ok := s.Rooms[room]
+	return ok && !r.kicked[name]
+}
+
+// invoke sends the invocation to the stream of its bot, which may then answer in the room for a while
+// without becoming a member. The invoker is told when the bot is not connected.
+func (s *server) invoke(ctx context.Context, inv *chat.CommandInvocation) error {
+
+	ctx, span := tracer.Start(ctx, "command", trace.WithAttributes(
+		attribute.String("chat.room", inv.Room),
+		attribute.String("chat.command", inv.Command),
+	))
+	defer span.End()
+	if !s.isConnected(ctx, inv.Bot) {
+		level.Debug(s.log(ctx)).Log("message", "the bot of the command is offline", "command", inv.Command, "bot", inv.Bot)
+		id, err := s.generateToken()
+		if err != nil {
+			return status.Error(codes.Internal, "could not generate the message id")
+		}
+		s.publish(ctx, &chat.StreamResponse{
+			Timestamp: ptypes.TimestampNow(),
+			Event: &chat.StreamResponse_ClientMessage{
+				ClientMessage: &chat.StreamResponse_Message{
+					Name:    inv.Bot,
+					To:      inv.Name,
+					Message: fmt.Sprintf("/%v is not available, %v is offline", inv.Command, inv.Bot),
+					Id:      id,
+				},
+			},
+		})
+		return nil
+	}
+
+	level.Debug(s.log(ctx)).Log("message", "invoking the command", "command", inv.Command, "bot", inv.Bot, "room", inv.Room)
+	s.publish(ctx, &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_CommandInvocation{
+			CommandInvocation: inv,
+		},
+	})
+	return nil
+}
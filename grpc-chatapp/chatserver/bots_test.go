@@ -0,0 +1,198 @@
+package chatserver_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	diceKey = "dice-0123456789abcdef"
+	echoKey = "echo-0123456789abcdef"
+)
+
+var bots = []chatserver.Bot{{Name: "dice", APIKey: diceKey}, {Name: "echo", APIKey: echoKey}}
+
+func TestRegisterCommand(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{Bots: bots})
+	client := chat.NewChatClient(s.Dial())
+	ctx := context.Background()
+	login, err := client.Login(ctx, &chat.LoginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		command string
+		want    codes.Code
+	}{
+		{"bot", diceKey, "roll", codes.OK},
+		{"with the slash", diceKey, "/flip-coin", codes.OK},
+		{"registered again", diceKey, "roll", codes.OK},
+		{"user", login.Token, "wave", codes.PermissionDenied},
+		{"unknown token", "unknown", "wave", codes.Unauthenticated},
+		{"invalid name", echoKey, "Echo!", codes.InvalidArgument},
+		{"empty name", echoKey, "", codes.InvalidArgument},
+		{"reserved by the client", echoKey, "me", codes.InvalidArgument},
+		{"reserved with the slash", echoKey, "/msg", codes.InvalidArgument},
+		{"claimed by another bot", echoKey, "roll", codes.AlreadyExists},
+	}
+	for _, tt := range tests {
+		_, err := client.RegisterCommand(ctx, &chat.RegisterCommandRequest{Token: tt.token, Command: tt.command, Description: tt.name})
+		if code := status.Code(err); code != tt.want {
+			t.Errorf("%v: RegisterCommand(%q) code = %v, want %v", tt.name, tt.command, code, tt.want)
+		}
+	}
+
+	res, err := client.ListCommands(ctx, &chat.ListCommandsRequest{Token: login.Token})
+	if err != nil {
+		t.Fatalf("ListCommands() error = %v", err)
+	}
+	want := []*chat.Command{
+		{Command: "flip-coin", Description: "with the slash", Bot: "dice"},
+		{Command: "roll", Description: "registered again", Bot: "dice"},
+	}
+	if len(res.Commands) != len(want) {
+		t.Fatalf("ListCommands() = %v, want %v", res.Commands, want)
+	}
+	for i, c := range res.Commands {
+		if c.Command != want[i].Command || c.Description != want[i].Description || c.Bot != want[i].Bot {
+			t.Errorf("ListCommands()[%d] = %v, want %v", i, c, want[i])
+		}
+	}
+
+	if _, err := client.Login(ctx, &chat.LoginRequest{Username: "dice"}); status.Code(err) != codes.AlreadyExists {
+		t.Errorf("Login() as a bot error = %v, want %v", err, codes.AlreadyExists)
+	}
+	invalid := [][]chatserver.Bot{
+		{{Name: "dice", APIKey: "short"}},
+		{{Name: "dice"}},
+		{{Name: "dice@chat.example.com", APIKey: diceKey}},
+		{{Name: "dice", APIKey: diceKey}, {Name: "dice", APIKey: echoKey}},
+		{{Name: "dice", APIKey: diceKey}, {Name: "echo", APIKey: diceKey}},
+	}
+	for _, cfg := range invalid {
+		if _, err := chatserver.NewServer(chatserver.Options{Bots: cfg}); err == nil {
+			t.Errorf("NewServer() with the bots %+v returned no error", cfg)
+		}
+	}
+}
+
+func TestCommands(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{Bots: bots})
+	client := chat.NewChatClient(s.Dial())
+	ctx, cancel := context.WithTimeout(context.Background(), chattest.Timeout)
+	defer cancel()
+
+	if _, err := client.RegisterCommand(ctx, &chat.RegisterCommandRequest{Token: diceKey, Command: "roll"}); err != nil {
+		t.Fatalf("RegisterCommand() error = %v", err)
+	}
+	stream, err := client.Subscribe(ctx, &chat.SubscribeRequest{Token: diceKey})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Header() error = %v", err)
+	}
+	// next returns the next invocation received by the bot
+	next := func() *chat.CommandInvocation {
+		t.Helper()
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv() error = %v", err)
+			}
+			if inv := res.GetCommandInvocation(); inv != nil {
+				return inv
+			}
+		}
+	}
+	alice, bob := s.Client("alice"), s.Client("bob")
+
+	// The invocation goes to the bot alone, the reply to the room. The bots cannot invoke the commands.
+	if err := alice.Send(ctx, "", "/roll  2d6 "); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if inv := next(); inv.Bot != "dice" || inv.Command != "roll" || inv.Args != "2d6" || inv.Name != "alice" || inv.Room != "lobby" {
+		t.Fatalf("the bot received %v, want alice rolling 2d6 in the lobby", inv)
+	}
+	if _, err := client.Post(ctx, &chat.PostRequest{Token: diceKey, Room: "lobby", Message: "/roll 7"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Name != "dice" || got.Room != "lobby" || got.Text != "/roll 7" {
+		t.Fatalf("bob received %q in %v from %v, want the reply of the bot", got.Text, got.Room, got.Name)
+	}
+
+	// The other messages are posted as usual
+	if err := alice.Send(ctx, "", "/dance"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Name != "alice" || got.Text != "/dance" {
+		t.Fatalf("bob received %q from %v, want the unknown command of alice", got.Text, got.Name)
+	}
+
+	// The bot answers in the private rooms it is invoked in without becoming a member
+	if err := alice.CreateRoom(ctx, "ops", chat.Visibility_PRIVATE); err != nil {
+		t.Fatalf("CreateRoom() error = %v", err)
+	}
+	if _, err := client.Post(ctx, &chat.PostRequest{Token: diceKey, Room: "ops", Message: "let me in"}); status.Code(err) != codes.NotFound {
+		t.Fatalf("Post() to a private room before being invoked there error = %v, want %v", err, codes.NotFound)
+	}
+	if err := alice.Send(ctx, "ops", "/roll"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if inv := next(); inv.Room != "ops" || inv.Args != "" {
+		t.Fatalf("the bot received %v, want alice rolling in ops", inv)
+	}
+	if _, err := client.Post(ctx, &chat.PostRequest{Token: diceKey, Room: "ops", Message: "4"}); err != nil {
+		t.Fatalf("Post() error = %v", err)
+	}
+	for {
+		got := alice.Next(chatclient.Message{}).(chatclient.Message)
+		if got.Room != "ops" {
+			continue
+		}
+		if got.Name != "dice" || got.Text != "4" {
+			t.Fatalf("alice received %q in ops from %v, want the reply of the bot", got.Text, got.Name)
+		}
+		break
+	}
+	rooms, err := client.ListRooms(ctx, &chat.ListRoomsRequest{Token: diceKey})
+	if err != nil {
+		t.Fatalf("ListRooms() error = %v", err)
+	}
+	for _, r := range rooms.Rooms {
+		if r.Name == "ops" {
+			t.Fatalf("the bot was made a member of ops")
+		}
+	}
+
+	// The invoker is told when the bot is offline
+	if _, err := client.RegisterCommand(ctx, &chat.RegisterCommandRequest{Token: echoKey, Command: "echo"}); err != nil {
+		t.Fatalf("RegisterCommand() error = %v", err)
+	}
+	if err := alice.Send(ctx, "", "/echo hi"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	for {
+		got := alice.Next(chatclient.Message{}).(chatclient.Message)
+		if got.To == "" {
+			continue
+		}
+		if got.Name != "echo" || got.To != "alice" || !strings.Contains(got.Text, "offline") {
+			t.Fatalf("alice received %q from %v to %v, want a direct message saying echo is offline", got.Text, got.Name, got.To)
+		}
+		break
+	}
+}
@@ -0,0 +1,94 @@
+package chatserver
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+)
+
+// Broker carries the events from the instance that took them in to the fan-out of every instance,
+// so that several servers behind a load balancer form one chat. The clients have to stay on the
+// instance they logged in to, tokens are only known there.
+type Broker interface {
+	// Publish hands the event over to the handler of every instance, this one included. The events holding
+	// a StateChange are kept as well, the last one of every key.
+	Publish(ctx context.Context, res *chat.StreamResponse) error
+	// Subscribe sets the handler the events are delivered to in the order they were published, it is
+	// called once before the first Publish. The state changes kept so far are delivered first, before it
+	// returns. ctx carries the trace context of the publisher.
+	Subscribe(handler func(ctx context.Context, res *chat.StreamResponse)) error
+	// SetLocalUsers records the users logged in to this instance, RemoteUsers returns the users of the others
+	SetLocalUsers(ctx context.Context, users []string) error
+	RemoteUsers(ctx context.Context) ([]string, error)
+	// Claim returns true to the first instance claiming the key within ttl, so that the work every instance
+	// is offered, such as relaying the events of a federation peer, is done once
+	Claim(ctx context.Context, key string, ttl time.Duration) (bool, error)
+	// Close stops the deliveries and withdraws the users of this instance
+	Close() error
+}
+
+// localBroker delivers the events to the instance they were published on, for a server running alone
+type localBroker struct {
+	mu      sync.RWMutex
+	handler func(ctx context.Context, res *chat.StreamResponse)
+	// claims are the keys claimed by when they expire
+	claims map[string]time.Time
+}
+
+// NewLocalBroker returns the broker of a server running alone, it is used when Options.Broker is nil
+func NewLocalBroker() Broker {
+	return &localBroker{claims: make(map[string]time.Time)}
+}
+
+func (b *localBroker) Publish(ctx context.Context, res *chat.StreamResponse) error {
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.handler != nil {
+		b.handler(ctx, res)
+	}
+	return nil
+}
+
+func (b *localBroker) Subscribe(handler func(ctx context.Context, res *chat.StreamResponse)) error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handler = handler
+	return nil
+}
+
+func (b *localBroker) SetLocalUsers(ctx context.Context, users []string) error {
+	return nil
+}
+
+func (b *localBroker) RemoteUsers(ctx context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (b *localBroker) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	for k, expiry := range b.claims {
+		if !now.Before(expiry) {
+			delete(b.claims, k)
+		}
+	}
+	if _, ok := b.claims[key]; ok {
+		return false, nil
+	}
+	b.claims[key] = now.Add(ttl)
+	return true, nil
+}
+
+func (b *localBroker) Close() error {
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.handler = nil
+	return nil
+}
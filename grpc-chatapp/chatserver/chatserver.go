@@ -0,0 +1,273 @@
+// Package chatserver is the chat service. The server binary wraps it with flags, signal handling
+// and the metrics endpoint, tests and other programs can embed it:
+//
+//	s, err := chatserver.NewServer(chatserver.Options{})
+//	...
+//	go s.Serve(lis)
+//	...
+//	s.Shutdown(ctx)
+package chatserver
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/logging"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/tracing"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/webhook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// DefaultSessionTimeout is the session timeout when Options.SessionTimeout is zero
+const DefaultSessionTimeout = 2 * time.Minute
+
+// DefaultShutdownReason is the reason announced on shutdown when Options.ShutdownReason is empty
+const DefaultShutdownReason = "server is shutting down"
+
+type Options struct {
+	// Logging gives the loggers of the main, server and broadcast components, nothing is logged when nil
+	Logging *logging.Logging
+	// TLSCert and TLSKey serve the chat over TLS when set
+	TLSCert, TLSKey string
+	// ServerOptions are passed to grpc.NewServer along with the interceptors of the server
+	ServerOptions    []grpc.ServerOption
+	EnableReflection bool
+	// Broker connects the instances of the chat, the server runs alone when nil. The instances share the
+	// events, who is logged in, and the rooms, the roles, the commands of the bots and the webhook approvals.
+	// The instances have to be given the same options.
+	Broker Broker
+	// Federation shares rooms with other deployments, it is served by ServeFederation
+	Federation *Federation
+	// IncomingWebhooks let services post to the rooms, they are served by ServeGateway
+	IncomingWebhooks []IncomingWebhook
+	// Bots are the accounts of the programs answering the slash commands
+	Bots []Bot
+	// Webhooks are called with the events of the chat, their status is served by the Admin service
+	Webhooks []webhook.Subscription
+	// SessionTimeout is how long a session lasts without a stream open nor a call made, e.g. once the client
+	// crashed, before its name is released. It is DefaultSessionTimeout when zero.
+	SessionTimeout time.Duration
+	// RateLimit bounds how fast the users, the bots and the incoming webhooks post, there is no limit when
+	// zero
+	RateLimit RateLimit
+	// GRPCWebOrigins are the origins of the pages allowed to call the chat with gRPC-Web from another
+	// origin than the gateway's, "*" allows them all
+	GRPCWebOrigins []string
+
+	// ShutdownReason, RestartETA and AlternateAddresses are announced to the clients on shutdown.
+	// RestartETA is how long until the server is back, zero when it is not restarting.
+	ShutdownReason     string
+	RestartETA         time.Duration
+	AlternateAddresses []string
+}
+
+// Server serves the chat service along with the gRPC health service
+type Server struct {
+	opts   Options
+	logger log.Logger
+	chat   *server
+	grpc   *grpc.Server
+	health *health.Server
+	// federation serves the Federation service over mutual TLS, nil when not federated
+	federation *grpc.Server
+	gateway    gateway
+
+	shutdownOnce sync.Once
+	shutdownErr  error
+}
+
+// NewServer sets up the server and starts its broadcast, Shutdown has to be called to stop it
+func NewServer(opts Options) (*Server, error) {
+
+	logger, serverLogger, broadcastLogger := log.NewNopLogger(), log.NewNopLogger(), log.NewNopLogger()
+	if opts.Logging != nil {
+		logger = opts.Logging.Component("main")
+		serverLogger = opts.Logging.Component("server")
+		broadcastLogger = opts.Logging.Component("broadcast")
+	}
+
+	serverOpts := append([]grpc.ServerOption(nil), opts.ServerOptions...)
+	if opts.TLSCert != "" {
+		creds, err := credentials.NewServerTLSFromFile(opts.TLSCert, opts.TLSKey)
+		if err != nil {
+			return nil, err
+		}
+		serverOpts = append(serverOpts, grpc.Creds(creds))
+	}
+	if opts.ShutdownReason == "" {
+		opts.ShutdownReason = DefaultShutdownReason
+	}
+
+	customServer := newServer(serverLogger)
+	customServer.broadcastLogger = broadcastLogger
+	if opts.SessionTimeout > 0 {
+		customServer.sessionTimeout = opts.SessionTimeout
+	}
+	if err := customServer.useIncomingWebhooks(opts.IncomingWebhooks); err != nil {
+		return nil, err
+	}
+	if err := customServer.useBots(opts.Bots); err != nil {
+		return nil, err
+	}
+	if opts.RateLimit.PerSecond > 0 {
+		customServer.limiter = newLimiter(opts.RateLimit)
+	}
+	s := grpc.NewServer(append(serverOpts,
+		grpc.ChainUnaryInterceptor(
+			tracing.UnaryServerInterceptor,
+			customServer.tokenInterceptor,
+			logging.UnaryServerInterceptor(customServer.logger, customServer.username),
+			customServer.metrics.unaryInterceptor,
+		),
+		grpc.ChainStreamInterceptor(
+			tracing.StreamServerInterceptor,
+			logging.StreamServerInterceptor(customServer.logger, customServer.username),
+			customServer.metrics.streamInterceptor,
+		),
+	)...)
+	chat.RegisterChatServer(s, customServer)
+	chat.RegisterAdminServer(s, &admin{chat: customServer})
+	healthServer := health.NewServer()
+	healthpb.RegisterHealthServer(s, healthServer)
+	if opts.EnableReflection {
+		reflection.Register(s)
+	}
+	level.Debug(logger).Log("message", "registered the server")
+
+	var federationServer *grpc.Server
+	if opts.Federation != nil {
+		federationLogger := log.NewNopLogger()
+		if opts.Logging != nil {
+			federationLogger = opts.Logging.Component("federation")
+		}
+		f, err := newFederation(*opts.Federation, customServer, federationLogger)
+		if err != nil {
+			return nil, err
+		}
+		customServer.federation = f
+		federationServer = grpc.NewServer(grpc.Creds(f.serverCredentials()), grpc.StreamInterceptor(tracing.StreamServerInterceptor))
+		chat.RegisterFederationServer(federationServer, f)
+	}
+	if len(opts.Webhooks) > 0 {
+		webhooksLogger := log.NewNopLogger()
+		if opts.Logging != nil {
+			webhooksLogger = opts.Logging.Component("webhooks")
+		}
+		d, err := webhook.New(webhook.Config{Subscriptions: opts.Webhooks, Logger: webhooksLogger})
+		if err != nil {
+			return nil, err
+		}
+		customServer.webhooks = d
+	}
+	// The broker replays the state shared so far, once the rooms of the options and the webhooks it applies
+	// to are set up
+	if opts.Broker != nil {
+		if err := customServer.useBroker(opts.Broker); err != nil {
+			return nil, err
+		}
+		customServer.shared = true
+	}
+	// Have a go routine that would have a map of all channels and push all the messages from the commonChannel
+	// to the individual specific client queue
+	level.Debug(logger).Log("message", "started the broadcast of messages")
+	go customServer.broadcast()
+	go customServer.expireSessions()
+	if customServer.federation != nil {
+		customServer.federation.follow()
+	}
+
+	healthServer.SetServingStatus("", healthpb.HealthCheckResponse_SERVING)
+	healthServer.SetServingStatus(chatServiceName, healthpb.HealthCheckResponse_SERVING)
+	return &Server{opts: opts, logger: logger, chat: customServer, grpc: s, health: healthServer, federation: federationServer}, nil
+}
+
+// Serve accepts the connections on lis until Shutdown is called
+func (s *Server) Serve(lis net.Listener) error {
+
+	level.Info(s.logger).Log("message", "server started listening", "address", lis.Addr())
+	return s.grpc.Serve(lis)
+}
+
+// ServeFederation accepts the connections of the peers on lis until Shutdown is called,
+// it fails when Options.Federation is not set
+func (s *Server) ServeFederation(lis net.Listener) error {
+
+	if s.federation == nil {
+		return errors.New("federation is not configured")
+	}
+	level.Info(s.logger).Log("message", "federation started listening", "address", lis.Addr())
+	return s.federation.Serve(lis)
+}
+
+// MetricsHandler serves the Prometheus metrics of the server
+func (s *Server) MetricsHandler() http.Handler {
+	return s.chat.metrics.handler()
+}
+
+// Shutdown drains the server: the health checks fail, the clients are told why the server is going away and
+// by the deadline of ctx when it has one, and their streams end once they got what was queued for them.
+// Once ctx is done the remaining connections are closed. It returns ctx's error if the streams were cut short.
+func (s *Server) Shutdown(ctx context.Context) error {
+
+	s.shutdownOnce.Do(func() {
+		// Fail the health checks first so that no new clients are routed here
+		s.health.Shutdown()
+		if err := s.chat.shutdown(ctx, s.shutdownNotice()); err != nil {
+			level.Warn(s.logger).Log("message", "forcing the remaining streams to close", "err", err)
+			s.shutdownErr = err
+		}
+		s.closeGateways(ctx)
+		if s.federation != nil {
+			s.chat.federation.close()
+			s.federation.Stop()
+		}
+		if s.chat.webhooks != nil {
+			if err := s.chat.webhooks.Close(ctx); err != nil {
+				level.Warn(s.logger).Log("message", "gave up on the webhook deliveries left", "err", err)
+			}
+		}
+		if err := s.chat.broker.Close(); err != nil {
+			level.Warn(s.logger).Log("message", "failed to close the broker", "err", err)
+		}
+
+		level.Info(s.logger).Log("message", "graceful shutdown")
+		stopped := make(chan struct{})
+		go func() {
+			s.grpc.GracefulStop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-ctx.Done():
+			s.grpc.Stop()
+			<-stopped
+		}
+	})
+	return s.shutdownErr
+}
+
+// shutdownNotice returns the Shutdown event announcing the reason, the restart ETA if the server
+// is coming back and the addresses the clients can move to
+func (s *Server) shutdownNotice() *chat.StreamResponse_Shutdown {
+
+	notice := &chat.StreamResponse_Shutdown{
+		Reason:             s.opts.ShutdownReason,
+		AlternateAddresses: s.opts.AlternateAddresses,
+	}
+	if s.opts.RestartETA > 0 {
+		notice.RestartEta, _ = ptypes.TimestampProto(time.Now().Add(s.opts.RestartETA))
+	}
+	return notice
+}
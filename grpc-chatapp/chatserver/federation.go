@@ -0,0 +1,403 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// federationBacklog is the number of events kept for the peers to catch up with after reconnecting
+	federationBacklog = 1000
+	// federationRetryDelay is how long a follower waits before subscribing to its peer again
+	federationRetryDelay = time.Second
+	// domainSeparator joins the name of a remote user to its domain
+	domainSeparator = "@"
+	// federationClaimTTL is how long the instances sharing a broker remember that one of them relayed an event
+	// of a peer, they all follow the peers
+	federationClaimTTL = 10 * time.Minute
+)
+
+// Federation shares rooms with other chat deployments. The deployments subscribe to each other over mutual
+// TLS and relay the messages, the edits, the deletes and the kicks made in the shared rooms by their own
+// users, who show up as user@domain.
+type Federation struct {
+	// Domain names the deployment, the certificate has to be issued for it
+	Domain string
+	// Rooms are the shared rooms, they are created as public rooms when missing. The peers share the
+	// rooms they both list.
+	Rooms []string
+	Peers []Peer
+	// Certificate identifies the deployment to its peers, CAs verifies theirs
+	Certificate tls.Certificate
+	CAs         *x509.CertPool
+}
+
+// Peer is a deployment to relay the messages of the shared rooms with
+type Peer struct {
+	Domain string
+	// Address is where the peer serves the federation
+	Address string
+}
+
+// federation relays the messages of the shared rooms between the chat and its peers
+type federation struct {
+	cfg    Federation
+	rooms  map[string]bool
+	peers  map[string]bool
+	logger log.Logger
+	chat   *server
+	// epoch tells the peers the events were numbered since the last start
+	epoch string
+
+	mu sync.Mutex
+	// backlog holds the last federationBacklog events sent here, oldest first
+	backlog  []*chat.FederatedEvent
+	sequence uint64
+	closed   bool
+	// changed is closed whenever an event is added or the federation is closed
+	changed chan struct{}
+
+	cancel    context.CancelFunc
+	followers sync.WaitGroup
+}
+
+func newFederation(cfg Federation, s *server, logger log.Logger) (*federation, error) {
+
+	if cfg.Domain == "" || strings.Contains(cfg.Domain, domainSeparator) {
+		return nil, fmt.Errorf("invalid federation domain %q", cfg.Domain)
+	}
+	epoch := make([]byte, 8)
+	if _, err := rand.Read(epoch); err != nil {
+		return nil, err
+	}
+	f := &federation{
+		cfg:     cfg,
+		rooms:   make(map[string]bool),
+		peers:   make(map[string]bool),
+		logger:  logger,
+		chat:    s,
+		epoch:   fmt.Sprintf("%x", epoch),
+		changed: make(chan struct{}),
+	}
+	for _, p := range cfg.Peers {
+		if p.Domain == cfg.Domain {
+			return nil, fmt.Errorf("peer %v has the domain of the deployment", p.Address)
+		}
+		f.peers[p.Domain] = true
+	}
+
+	s.roomMutex.Lock()
+	defer s.roomMutex.Unlock()
+	for _, name := range cfg.Rooms {
+		f.rooms[name] = true
+		if _, ok := s.Rooms[name]; !ok {
+			s.Rooms[name] = newRoom(name, chat.Visibility_PUBLIC)
+		}
+	}
+	return f, nil
+}
+
+// serverCredentials require the peers to present a certificate signed by one of the CAs
+func (f *federation) serverCredentials() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{f.cfg.Certificate},
+		ClientCAs:    f.cfg.CAs,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	})
+}
+
+// relayed returns the shared room of the event and the user who made it, ok is false for the events that
+// are not relayed to the peers
+func relayed(res *chat.StreamResponse) (room, user string, ok bool) {
+
+	switch ev := res.Event.(type) {
+	case *chat.StreamResponse_ClientMessage:
+		return ev.ClientMessage.Room, ev.ClientMessage.Name, true
+	case *chat.StreamResponse_MessageEdit:
+		return ev.MessageEdit.Room, ev.MessageEdit.Name, true
+	case *chat.StreamResponse_MessageDelete:
+		return ev.MessageDelete.Room, ev.MessageDelete.Name, true
+	case *chat.StreamResponse_ClientKick:
+		return ev.ClientKick.Room, ev.ClientKick.By, true
+	}
+	return "", "", false
+}
+
+// record adds the event to the backlog when a local user made it in a shared room. The events of remote
+// users are never relayed further, so that they do not loop between the peers.
+func (f *federation) record(res *chat.StreamResponse) {
+
+	room, user, ok := relayed(res)
+	if !ok || !f.rooms[room] || strings.Contains(user, domainSeparator) {
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sequence++
+	f.backlog = append(f.backlog, &chat.FederatedEvent{
+		Origin:   f.cfg.Domain,
+		Epoch:    f.epoch,
+		Sequence: f.sequence,
+		Event:    res,
+	})
+	if len(f.backlog) > federationBacklog {
+		f.backlog = f.backlog[len(f.backlog)-federationBacklog:]
+	}
+	close(f.changed)
+	f.changed = make(chan struct{})
+}
+
+// since returns the events of the backlog numbered after sequence, along with the channel closed on the
+// next change. closed is set once the federation is closed.
+func (f *federation) since(sequence uint64) (events []*chat.FederatedEvent, changed <-chan struct{}, closed bool) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, ev := range f.backlog {
+		if ev.Sequence > sequence {
+			events = append(events, ev)
+		}
+	}
+	return events, f.changed, f.closed
+}
+
+// authenticate checks that the peer presented a certificate issued for the domain it claims
+func (f *federation) authenticate(ctx context.Context, domain string) error {
+
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no peer certificate")
+	}
+	info, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(info.State.VerifiedChains) == 0 || len(info.State.VerifiedChains[0]) == 0 {
+		return status.Error(codes.Unauthenticated, "no peer certificate")
+	}
+	if !f.peers[domain] {
+		return status.Error(codes.PermissionDenied, "unknown peer")
+	}
+	if err := info.State.VerifiedChains[0][0].VerifyHostname(domain); err != nil {
+		return status.Error(codes.PermissionDenied, "the certificate was not issued for the domain")
+	}
+	return nil
+}
+
+// Subscribe streams the events of the shared rooms made here to the peer, starting with those it missed
+func (f *federation) Subscribe(req *chat.FederationSubscribeRequest, stream chat.Federation_SubscribeServer) error {
+
+	if err := f.authenticate(stream.Context(), req.Domain); err != nil {
+		level.Warn(f.logger).Log("message", "rejected a peer", "domain", req.Domain, "err", err)
+		return err
+	}
+	// The sequence of another epoch means nothing here, the peer gets the whole backlog
+	sequence := uint64(0)
+	if req.Epoch == f.epoch {
+		sequence = req.Sequence
+	}
+	level.Info(f.logger).Log("message", "peer subscribed", "domain", req.Domain, "sequence", sequence)
+
+	for {
+		events, changed, closed := f.since(sequence)
+		if len(events) > 0 && events[0].Sequence > sequence+1 {
+			level.Warn(f.logger).Log("message", "the peer missed events that are no longer in the backlog",
+				"domain", req.Domain, "missed", events[0].Sequence-sequence-1)
+		}
+		for _, ev := range events {
+			if err := stream.Send(ev); err != nil {
+				return err
+			}
+			sequence = ev.Sequence
+		}
+		if closed {
+			return status.Error(codes.Unavailable, "server is shutting down")
+		}
+		select {
+		case <-changed:
+		case <-stream.Context().Done():
+			level.Info(f.logger).Log("message", "peer unsubscribed", "domain", req.Domain)
+			return stream.Context().Err()
+		}
+	}
+}
+
+// follow subscribes to the peers until the federation is closed
+func (f *federation) follow() {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	f.cancel = cancel
+	for _, p := range f.cfg.Peers {
+		f.followers.Add(1)
+		go func(p Peer) {
+			defer f.followers.Done()
+			f.followPeer(ctx, p)
+		}(p)
+	}
+}
+
+// followPeer relays the events of the peer to the chat, subscribing again after the events it already
+// got when the stream breaks
+func (f *federation) followPeer(ctx context.Context, p Peer) {
+
+	creds := credentials.NewTLS(&tls.Config{
+		Certificates: []tls.Certificate{f.cfg.Certificate},
+		RootCAs:      f.cfg.CAs,
+		ServerName:   p.Domain,
+		MinVersion:   tls.VersionTLS12,
+	})
+	req := &chat.FederationSubscribeRequest{Domain: f.cfg.Domain}
+	for {
+		err := f.subscribe(ctx, p, creds, req)
+		if ctx.Err() != nil {
+			return
+		}
+		level.Warn(f.logger).Log("message", "lost the peer, subscribing again", "domain", p.Domain, "err", err)
+		select {
+		case <-time.After(federationRetryDelay):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// subscribe relays the events of the peer until the stream breaks, req keeps track of the last one
+func (f *federation) subscribe(ctx context.Context, p Peer, creds credentials.TransportCredentials, req *chat.FederationSubscribeRequest) error {
+
+	cc, err := grpc.DialContext(ctx, p.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		return err
+	}
+	defer cc.Close()
+	stream, err := chat.NewFederationClient(cc).Subscribe(ctx, req)
+	if err != nil {
+		return err
+	}
+	for {
+		ev, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		// Only the peer's own events are taken, so that the events do not loop around the federation
+		if ev.Origin != p.Domain {
+			level.Warn(f.logger).Log("message", "dropping an event relayed by the peer", "domain", p.Domain, "origin", ev.Origin)
+			continue
+		}
+		if ev.Epoch == req.Epoch && ev.Sequence <= req.Sequence {
+			continue
+		}
+		req.Epoch, req.Sequence = ev.Epoch, ev.Sequence
+
+		room, _, ok := relayed(ev.Event)
+		if !ok || !f.rooms[room] || !f.claim(ctx, p, ev) {
+			continue
+		}
+		f.relay(ctx, p, ev.Event)
+	}
+}
+
+// name returns the name the chat knows the user of the event of the peer under: the users of the peer are
+// namespaced with its domain, those of this deployment are back to their own name
+func (f *federation) name(p Peer, user string) string {
+
+	if local := strings.TrimSuffix(user, domainSeparator+f.cfg.Domain); local != user {
+		return local
+	}
+	if strings.Contains(user, domainSeparator) {
+		return user
+	}
+	return user + domainSeparator + p.Domain
+}
+
+// relay publishes the event of the peer to the chat, the kicks keep the user out of the room here as well
+func (f *federation) relay(ctx context.Context, p Peer, res *chat.StreamResponse) {
+
+	out := &chat.StreamResponse{Timestamp: res.Timestamp}
+	switch ev := res.Event.(type) {
+	case *chat.StreamResponse_ClientMessage:
+		msg := ev.ClientMessage
+		out.Event = &chat.StreamResponse_ClientMessage{
+			ClientMessage: &chat.StreamResponse_Message{
+				Name:    f.name(p, msg.Name),
+				Message: msg.Message,
+				Room:    msg.Room,
+				Id:      msg.Id,
+			},
+		}
+	case *chat.StreamResponse_MessageEdit:
+		edit := ev.MessageEdit
+		out.Event = &chat.StreamResponse_MessageEdit{
+			MessageEdit: &chat.StreamResponse_Edit{Id: edit.Id, Room: edit.Room, Name: f.name(p, edit.Name), Message: edit.Message},
+		}
+	case *chat.StreamResponse_MessageDelete:
+		del := ev.MessageDelete
+		out.Event = &chat.StreamResponse_MessageDelete{
+			MessageDelete: &chat.StreamResponse_Delete{Id: del.Id, Room: del.Room, Name: f.name(p, del.Name)},
+		}
+	case *chat.StreamResponse_ClientKick:
+		kick := ev.ClientKick
+		f.chat.kickOut(ctx, kick.Room, f.name(p, kick.Name), f.name(p, kick.By))
+		return
+	default:
+		return
+	}
+	f.chat.publish(ctx, out)
+}
+
+// claim reports whether this instance relays the event of the peer, the first one of the instances sharing
+// the broker to claim it does. The event is known the same way in the backlogs of all the instances of the
+// peer: a message by its id, the other events by what they are about and when they were published. It is
+// relayed when the broker cannot tell, a duplicate is better than a lost message.
+func (f *federation) claim(ctx context.Context, p Peer, ev *chat.FederatedEvent) bool {
+
+	key := fmt.Sprintf("federation:%v:%v:%d", p.Domain, ev.Epoch, ev.Sequence)
+	at := fmt.Sprintf("%d.%09d", ev.Event.GetTimestamp().GetSeconds(), ev.Event.GetTimestamp().GetNanos())
+	switch e := ev.Event.Event.(type) {
+	case *chat.StreamResponse_ClientMessage:
+		if e.ClientMessage.Id != "" {
+			key = fmt.Sprintf("federation:%v:%v", p.Domain, e.ClientMessage.Id)
+		}
+	case *chat.StreamResponse_MessageEdit:
+		key = fmt.Sprintf("federation:%v:edit:%v:%v", p.Domain, e.MessageEdit.Id, at)
+	case *chat.StreamResponse_MessageDelete:
+		key = fmt.Sprintf("federation:%v:delete:%v", p.Domain, e.MessageDelete.Id)
+	case *chat.StreamResponse_ClientKick:
+		key = fmt.Sprintf("federation:%v:kick:%v:%v:%v", p.Domain, e.ClientKick.Room, e.ClientKick.Name, at)
+	}
+	claimed, err := f.chat.broker.Claim(ctx, key, federationClaimTTL)
+	if err != nil {
+		level.Warn(f.logger).Log("message", "failed to claim the event of the peer, relaying it", "domain", p.Domain, "err", err)
+		return true
+	}
+	return claimed
+}
+
+// close ends the subscriptions of the peers and stops following them
+func (f *federation) close() {
+
+	f.mu.Lock()
+	if !f.closed {
+		f.closed = true
+		close(f.changed)
+		f.changed = make(chan struct{})
+	}
+	f.mu.Unlock()
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.followers.Wait()
+}
@@ -0,0 +1,439 @@
+package chatserver_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// authority issues the certificates of the deployments
+type authority struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	pool *x509.CertPool
+}
+
+func newAuthority(t *testing.T) *authority {
+
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "federation ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return &authority{cert: cert, key: key, pool: pool}
+}
+
+// issue returns a certificate for the domain, good for both ends of a connection
+func (a *authority) issue(t *testing.T, domain string) tls.Certificate {
+
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: domain},
+		DNSNames:     []string{domain},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, a.cert, &key.PublicKey, a.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+// listen returns a local listener closed when the test ends
+func listen(t *testing.T) net.Listener {
+
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+	return lis
+}
+
+// proxy forwards the connections to target, pause cuts them and refuses new ones until resume
+type proxy struct {
+	lis    net.Listener
+	target string
+
+	mu     sync.Mutex
+	paused bool
+	conns  []net.Conn
+}
+
+func newProxy(t *testing.T, target string) *proxy {
+
+	p := &proxy{lis: listen(t), target: target}
+	go func() {
+		for {
+			conn, err := p.lis.Accept()
+			if err != nil {
+				return
+			}
+			go p.forward(conn)
+		}
+	}()
+	return p
+}
+
+func (p *proxy) forward(conn net.Conn) {
+
+	p.mu.Lock()
+	if p.paused {
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	upstream, err := net.Dial("tcp", p.target)
+	if err != nil {
+		p.mu.Unlock()
+		conn.Close()
+		return
+	}
+	p.conns = append(p.conns, conn, upstream)
+	p.mu.Unlock()
+
+	go func() {
+		io.Copy(upstream, conn)
+		upstream.Close()
+	}()
+	io.Copy(conn, upstream)
+	conn.Close()
+}
+
+func (p *proxy) pause() {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = true
+	for _, conn := range p.conns {
+		conn.Close()
+	}
+	p.conns = nil
+}
+
+func (p *proxy) resume() {
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.paused = false
+}
+
+// federate starts a server federated with the peers, serving the federation on lis
+func federate(t *testing.T, lis net.Listener, cfg chatserver.Federation) *chattest.Server {
+
+	t.Helper()
+	s := chattest.NewServer(t, chatserver.Options{Federation: &cfg})
+	go s.ServeFederation(lis)
+	return s
+}
+
+func TestFederation(t *testing.T) {
+
+	ca := newAuthority(t)
+	lisA, lisB := listen(t), listen(t)
+	// teamB follows teamA through the proxy, so that their link can be cut
+	toA := newProxy(t, lisA.Addr().String())
+	a := federate(t, lisA, chatserver.Federation{
+		Domain:      "teamA",
+		Rooms:       []string{"shared"},
+		Peers:       []chatserver.Peer{{Domain: "teamB", Address: lisB.Addr().String()}},
+		Certificate: ca.issue(t, "teamA"),
+		CAs:         ca.pool,
+	})
+	b := federate(t, lisB, chatserver.Federation{
+		Domain:      "teamB",
+		Rooms:       []string{"shared"},
+		Peers:       []chatserver.Peer{{Domain: "teamA", Address: toA.lis.Addr().String()}},
+		Certificate: ca.issue(t, "teamB"),
+		CAs:         ca.pool,
+	})
+	alice, bob := a.Client("alice"), b.Client("bob")
+
+	ctx := context.Background()
+	send := func(c *chattest.Client, room, text string) {
+		t.Helper()
+		if err := c.Send(ctx, room, text); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	expect := func(c *chattest.Client, name, text string) {
+		t.Helper()
+		got := c.Next(chatclient.Message{}).(chatclient.Message)
+		if got.Name != name || got.Room != "shared" || got.Text != text {
+			t.Fatalf("%v received %q in %v from %v, want %q in shared from %v", c.Name(), got.Text, got.Room, got.Name, text, name)
+		}
+	}
+
+	// The messages sent before the peers subscribed are replayed from the backlog
+	send(alice, "shared", "hi")
+	expect(alice, "alice", "hi")
+	expect(bob, "alice@teamA", "hi")
+
+	send(bob, "shared", "hello")
+	expect(bob, "bob", "hello")
+	expect(alice, "bob@teamB", "hello")
+
+	// The lobby stays local and bob's message does not come back from teamA
+	send(alice, "", "local")
+	send(alice, "shared", "bye")
+	expect(bob, "alice@teamA", "bye")
+
+	// teamB catches up once the link is back, without duplicates
+	toA.pause()
+	send(alice, "shared", "missed 1")
+	send(alice, "shared", "missed 2")
+	toA.resume()
+	expect(bob, "alice@teamA", "missed 1")
+	expect(bob, "alice@teamA", "missed 2")
+	send(alice, "shared", "back")
+	expect(bob, "alice@teamA", "back")
+
+	_, err := chat.NewChatClient(a.Dial()).Login(ctx, &chat.LoginRequest{Username: "bob@teamB"})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Fatalf("Login() as a remote user code = %v, want %v", code, codes.InvalidArgument)
+	}
+}
+
+func TestFederatedChanges(t *testing.T) {
+
+	ca := newAuthority(t)
+	lisA, lisB := listen(t), listen(t)
+	toA := newProxy(t, lisA.Addr().String())
+	a := federate(t, lisA, chatserver.Federation{
+		Domain:      "teamA",
+		Rooms:       []string{"shared"},
+		Peers:       []chatserver.Peer{{Domain: "teamB", Address: lisB.Addr().String()}},
+		Certificate: ca.issue(t, "teamA"),
+		CAs:         ca.pool,
+	})
+	b := federate(t, lisB, chatserver.Federation{
+		Domain:      "teamB",
+		Rooms:       []string{"shared"},
+		Peers:       []chatserver.Peer{{Domain: "teamA", Address: toA.lis.Addr().String()}},
+		Certificate: ca.issue(t, "teamB"),
+		CAs:         ca.pool,
+	})
+	alice, bob := a.Client("alice"), b.Client("bob")
+
+	ctx := context.Background()
+	if err := alice.Send(ctx, "shared", "hi"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	msg := bob.Next(chatclient.Message{}).(chatclient.Message)
+
+	// The changes made while the link is down are caught up with, in order
+	toA.pause()
+	if err := alice.EditMessage(ctx, "shared", msg.ID, "hi all"); err != nil {
+		t.Fatalf("EditMessage() error = %v", err)
+	}
+	if err := alice.DeleteMessage(ctx, "shared", msg.ID); err != nil {
+		t.Fatalf("DeleteMessage() error = %v", err)
+	}
+	toA.resume()
+	if got := bob.Next(chatclient.Edit{}).(chatclient.Edit); got.ID != msg.ID || got.Name != "alice@teamA" || got.Text != "hi all" {
+		t.Fatalf("bob received the edit %+v, want alice@teamA changing %v to hi all", got, msg.ID)
+	}
+	if got := bob.Next(chatclient.Delete{}).(chatclient.Delete); got.ID != msg.ID || got.Name != "alice@teamA" {
+		t.Fatalf("bob received the delete %+v, want alice@teamA deleting %v", got, msg.ID)
+	}
+	history, err := bob.History(ctx, "shared", 0)
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("History() on teamB = %+v, want the deleted message gone", history)
+	}
+
+	// alice owns teamA, kicking bob there keeps bob out on teamB as well
+	if err := alice.Kick(ctx, "shared", "bob@teamB"); err != nil {
+		t.Fatalf("Kick() error = %v", err)
+	}
+	if got := bob.Next(chatclient.Kick{}).(chatclient.Kick); got.Name != "bob" || got.Room != "shared" || got.By != "alice@teamA" {
+		t.Fatalf("bob received the kick %+v, want bob kicked out of shared by alice@teamA", got)
+	}
+	if err := bob.Post(ctx, "shared", "still here?"); status.Code(err) != codes.NotFound {
+		t.Fatalf("Post() once kicked error = %v, want %v", err, codes.NotFound)
+	}
+}
+
+func TestFederationAuthentication(t *testing.T) {
+
+	ca, other := newAuthority(t), newAuthority(t)
+	lis := listen(t)
+	federate(t, lis, chatserver.Federation{
+		Domain:      "teamA",
+		Rooms:       []string{"shared"},
+		Peers:       []chatserver.Peer{{Domain: "teamB", Address: "127.0.0.1:1"}},
+		Certificate: ca.issue(t, "teamA"),
+		CAs:         ca.pool,
+	})
+
+	tests := []struct {
+		name   string
+		certs  []tls.Certificate
+		domain string
+		want   codes.Code
+	}{
+		{"peer", []tls.Certificate{ca.issue(t, "teamB")}, "teamB", codes.OK},
+		{"certificate of another domain", []tls.Certificate{ca.issue(t, "teamC")}, "teamB", codes.PermissionDenied},
+		{"unknown peer", []tls.Certificate{ca.issue(t, "teamC")}, "teamC", codes.PermissionDenied},
+		{"certificate of another authority", []tls.Certificate{other.issue(t, "teamB")}, "teamB", codes.Unavailable},
+		{"no certificate", nil, "teamB", codes.Unavailable},
+	}
+	for _, tt := range tests {
+		creds := credentials.NewTLS(&tls.Config{Certificates: tt.certs, RootCAs: ca.pool, ServerName: "teamA"})
+		cc, err := grpc.Dial(lis.Addr().String(), grpc.WithTransportCredentials(creds))
+		if err != nil {
+			t.Fatalf("%v: Dial() error = %v", tt.name, err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+		stream, err := chat.NewFederationClient(cc).Subscribe(ctx, &chat.FederationSubscribeRequest{Domain: tt.domain})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		// The peer gets nothing until a message is sent in the shared rooms
+		if tt.want == codes.OK && status.Code(err) == codes.DeadlineExceeded {
+			err = nil
+		}
+		if code := status.Code(err); code != tt.want {
+			t.Errorf("%v: Subscribe() code = %v, want %v (%v)", tt.name, code, tt.want, err)
+		}
+		cancel()
+		cc.Close()
+	}
+}
+
+// hub connects brokers in memory, like instances sharing Redis
+type hub struct {
+	mu       sync.Mutex
+	handlers []func(ctx context.Context, res *chat.StreamResponse)
+	claims   map[string]bool
+}
+
+type hubBroker struct {
+	h *hub
+}
+
+func (b hubBroker) Publish(ctx context.Context, res *chat.StreamResponse) error {
+
+	b.h.mu.Lock()
+	handlers := b.h.handlers
+	b.h.mu.Unlock()
+	for _, handler := range handlers {
+		handler(ctx, res)
+	}
+	return nil
+}
+
+func (b hubBroker) Subscribe(handler func(ctx context.Context, res *chat.StreamResponse)) error {
+
+	b.h.mu.Lock()
+	defer b.h.mu.Unlock()
+	b.h.handlers = append(b.h.handlers, handler)
+	return nil
+}
+
+func (b hubBroker) SetLocalUsers(ctx context.Context, users []string) error { return nil }
+
+func (b hubBroker) RemoteUsers(ctx context.Context) ([]string, error) { return nil, nil }
+
+func (b hubBroker) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+
+	b.h.mu.Lock()
+	defer b.h.mu.Unlock()
+	if b.h.claims[key] {
+		return false, nil
+	}
+	b.h.claims[key] = true
+	return true, nil
+}
+
+func (b hubBroker) Close() error { return nil }
+
+func TestFederationSharedBroker(t *testing.T) {
+
+	ca := newAuthority(t)
+	lisA := listen(t)
+	a := federate(t, lisA, chatserver.Federation{
+		Domain:      "teamA",
+		Rooms:       []string{"shared"},
+		Peers:       []chatserver.Peer{{Domain: "teamB", Address: "127.0.0.1:1"}},
+		Certificate: ca.issue(t, "teamA"),
+		CAs:         ca.pool,
+	})
+	// Both instances of teamB follow teamA, one of them relays each message
+	h := &hub{claims: make(map[string]bool)}
+	var instances []*chattest.Server
+	for i := 0; i < 2; i++ {
+		s := chattest.NewServer(t, chatserver.Options{
+			Broker: hubBroker{h},
+			Federation: &chatserver.Federation{
+				Domain:      "teamB",
+				Rooms:       []string{"shared"},
+				Peers:       []chatserver.Peer{{Domain: "teamA", Address: lisA.Addr().String()}},
+				Certificate: ca.issue(t, "teamB"),
+				CAs:         ca.pool,
+			},
+		})
+		instances = append(instances, s)
+	}
+	alice, bob := a.Client("alice"), instances[0].Client("bob")
+
+	ctx := context.Background()
+	for _, text := range []string{"one", "two"} {
+		if err := alice.Send(ctx, "shared", text); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		got := bob.Next(chatclient.Message{}).(chatclient.Message)
+		if got.Name != "alice@teamA" || got.Text != text {
+			t.Fatalf("bob received %q from %v, want %q from alice@teamA once", got.Text, got.Name, text)
+		}
+	}
+}
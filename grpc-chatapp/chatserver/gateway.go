@@ -0,0 +1,179 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/grpc-ecosystem/grpc-gateway/runtime"
+	"github.com/improbable-eng/grpc-web/go/grpcweb"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/ircgateway"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/wsbridge"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// gatewayBufferSize is the buffer of the in process connection between the gateway and the chat service
+const gatewayBufferSize = 1 << 20
+
+// gateway keeps what the gateways started by ServeGateway and ServeIRC hold, to release it on shutdown
+type gateway struct {
+	mu      sync.Mutex
+	servers []*http.Server
+	ircs    []*ircgateway.Server
+	conns   []*grpc.ClientConn
+	closed  bool
+}
+
+// authorizationMetadata passes the token of the Authorization header on as x-chat-token,
+// with or without the Bearer scheme
+func authorizationMetadata(ctx context.Context, r *http.Request) metadata.MD {
+
+	auth := r.Header.Get("Authorization")
+	if auth == "" {
+		return nil
+	}
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		auth = auth[len("Bearer "):]
+	}
+	return metadata.Pairs(tokenHeader, auth)
+}
+
+// tokenInterceptor fills the token of the requests that have none from the x-chat-token header,
+// the way Stream takes it, and keeps the session of the token from expiring
+func (s *server) tokenInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	if m, ok := req.(interface{ ProtoReflect() protoreflect.Message }); ok {
+		msg := m.ProtoReflect()
+		fd := msg.Descriptor().Fields().ByName("token")
+		if fd != nil && fd.Kind() == protoreflect.StringKind {
+			tkn := msg.Get(fd).String()
+			if tkn == "" {
+				tkn, _ = s.extractToken(ctx)
+				msg.Set(fd, protoreflect.ValueOfString(tkn))
+			}
+			s.touch(tkn)
+		}
+	}
+	return handler(ctx, req)
+}
+
+// allowOrigin tells whether a page of another origin may call the chat with gRPC-Web. The token is sent
+// in a header rather than a cookie, a page can only act for the users who logged in through it.
+func (s *Server) allowOrigin(origin string) bool {
+
+	for _, allowed := range s.opts.GRPCWebOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// ServeGateway serves the REST gateway of the chat on lis until Shutdown is called, over TLS when the server
+// has a certificate. The gateway calls the chat service in process and serves its OpenAPI spec on
+// /openapi.json, the incoming webhooks on /hooks/, the WebSocket bridge on /ws and the page to chat from a
+// browser on /. The chat service itself is served to the gRPC-Web clients on the same address.
+func (s *Server) ServeGateway(lis net.Listener) error {
+
+	cc, err := s.dialInProcess()
+	if err != nil {
+		return err
+	}
+
+	mux := runtime.NewServeMux(runtime.WithMetadata(authorizationMetadata))
+	if err := chat.RegisterChatHandler(context.Background(), mux, cc); err != nil {
+		return err
+	}
+	root := http.NewServeMux()
+	root.Handle("/v1/", mux)
+	root.HandleFunc("/openapi.json", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(chat.OpenAPI)
+	})
+	root.HandleFunc(incomingWebhookPath, s.chat.serveIncoming)
+	root.Handle("/ws", wsbridge.New(cc, s.logger))
+	root.Handle("/", wsbridge.Page())
+	web := grpcweb.WrapServer(s.grpc, grpcweb.WithOriginFunc(s.allowOrigin))
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if web.IsGrpcWebRequest(r) || web.IsAcceptableGrpcCorsRequest(r) {
+			web.ServeHTTP(w, r)
+			return
+		}
+		root.ServeHTTP(w, r)
+	})}
+
+	s.gateway.mu.Lock()
+	if s.gateway.closed {
+		s.gateway.mu.Unlock()
+		return grpc.ErrServerStopped
+	}
+	s.gateway.servers = append(s.gateway.servers, srv)
+	s.gateway.mu.Unlock()
+
+	level.Info(s.logger).Log("message", "gateway started listening", "address", lis.Addr())
+	if s.opts.TLSCert != "" {
+		err = srv.ServeTLS(lis, s.opts.TLSCert, s.opts.TLSKey)
+	} else {
+		err = srv.Serve(lis)
+	}
+	if err == http.ErrServerClosed {
+		return nil
+	}
+	return err
+}
+
+// dialInProcess connects to the chat service without leaving the process, the connection is closed on
+// shutdown along with the gateways
+func (s *Server) dialInProcess() (*grpc.ClientConn, error) {
+
+	// The connection never leaves the process, the certificate of the server is not checked
+	inproc := bufconn.Listen(gatewayBufferSize)
+	creds := grpc.WithInsecure()
+	if s.opts.TLSCert != "" {
+		creds = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{InsecureSkipVerify: true}))
+	}
+	cc, err := grpc.Dial("bufconn", creds, grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+		return inproc.Dial()
+	}))
+	if err != nil {
+		return nil, err
+	}
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+	if s.gateway.closed {
+		cc.Close()
+		return nil, grpc.ErrServerStopped
+	}
+	s.gateway.conns = append(s.gateway.conns, cc)
+	go s.grpc.Serve(inproc)
+	return cc, nil
+}
+
+// closeGateways stops the gateways, waiting for the requests in flight until ctx is done
+func (s *Server) closeGateways(ctx context.Context) {
+
+	s.gateway.mu.Lock()
+	defer s.gateway.mu.Unlock()
+	s.gateway.closed = true
+	for _, srv := range s.gateway.servers {
+		if err := srv.Shutdown(ctx); err != nil {
+			srv.Close()
+		}
+	}
+	for _, irc := range s.gateway.ircs {
+		irc.Close()
+	}
+	for _, cc := range s.gateway.conns {
+		cc.Close()
+	}
+}
@@ -0,0 +1,106 @@
+package chatserver_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+)
+
+func TestGateway(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	lis := listen(t)
+	go s.ServeGateway(lis)
+	bob := s.Client("bob")
+	base := "http://" + lis.Addr().String()
+
+	var token string
+	do := func(method, path, body string, out interface{}) int {
+		t.Helper()
+		req, err := http.NewRequest(method, base+path, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%v %v error = %v", method, path, err)
+		}
+		defer res.Body.Close()
+		data, _ := ioutil.ReadAll(res.Body)
+		if out != nil && res.StatusCode == http.StatusOK {
+			if err := json.Unmarshal(data, out); err != nil {
+				t.Fatalf("%v %v returned %s: %v", method, path, data, err)
+			}
+		}
+		return res.StatusCode
+	}
+
+	if code := do("GET", "/v1/users", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("GET /v1/users without a token = %v, want %v", code, http.StatusUnauthorized)
+	}
+
+	var login struct{ Token string }
+	if code := do("POST", "/v1/login", `{"username": "alice"}`, &login); code != http.StatusOK || login.Token == "" {
+		t.Fatalf("POST /v1/login = %v with token %q", code, login.Token)
+	}
+	token = login.Token
+	if login := bob.Next(chatclient.Login{}).(chatclient.Login); login.Name != "alice" {
+		t.Fatalf("bob saw %v log in, want alice", login.Name)
+	}
+
+	if code := do("POST", "/v1/rooms/lobby/messages", `{"message": "hello from curl"}`, nil); code != http.StatusOK {
+		t.Fatalf("POST /v1/rooms/lobby/messages = %v", code)
+	}
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Name != "alice" || got.Text != "hello from curl" {
+		t.Fatalf("bob received %q from %v, want the posted message", got.Text, got.Name)
+	}
+	if code := do("POST", "/v1/rooms/missing/messages", `{"message": "hello"}`, nil); code != http.StatusNotFound {
+		t.Fatalf("POST /v1/rooms/missing/messages = %v, want %v", code, http.StatusNotFound)
+	}
+
+	var users struct{ Usernames []string }
+	if code := do("GET", "/v1/users", "", &users); code != http.StatusOK || !reflect.DeepEqual(users.Usernames, []string{"alice", "bob"}) {
+		t.Fatalf("GET /v1/users = %v, %v, want alice and bob", code, users.Usernames)
+	}
+	var rooms struct{ Rooms []struct{ Name string } }
+	if code := do("GET", "/v1/rooms", "", &rooms); code != http.StatusOK || len(rooms.Rooms) != 1 || rooms.Rooms[0].Name != "lobby" {
+		t.Fatalf("GET /v1/rooms = %v, %+v, want the lobby", code, rooms.Rooms)
+	}
+	var history struct {
+		Messages []struct {
+			ClientMessage struct{ Name, Message string } `json:"client_message"`
+		}
+	}
+	if code := do("GET", "/v1/rooms/lobby/messages?limit=10", "", &history); code != http.StatusOK ||
+		len(history.Messages) != 1 || history.Messages[0].ClientMessage.Message != "hello from curl" {
+		t.Fatalf("GET /v1/rooms/lobby/messages = %v, %+v, want the posted message", code, history.Messages)
+	}
+
+	if code := do("POST", "/v1/logout", "", nil); code != http.StatusOK {
+		t.Fatalf("POST /v1/logout = %v", code)
+	}
+	if logout := bob.Next(chatclient.Logout{}).(chatclient.Logout); logout.Name != "alice" {
+		t.Fatalf("bob saw %v log out, want alice", logout.Name)
+	}
+	if code := do("GET", "/v1/users", "", nil); code != http.StatusUnauthorized {
+		t.Fatalf("GET /v1/users after logging out = %v, want %v", code, http.StatusUnauthorized)
+	}
+
+	var spec struct {
+		Swagger string
+		Paths   map[string]interface{}
+	}
+	if code := do("GET", "/openapi.json", "", &spec); code != http.StatusOK || spec.Paths["/v1/rooms/{room}/messages"] == nil {
+		t.Fatalf("GET /openapi.json = %v, %v, want the paths of the gateway", code, spec.Paths)
+	}
+}
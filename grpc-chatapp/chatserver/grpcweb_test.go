@@ -0,0 +1,188 @@
+package chatserver_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSubscribe(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	client := chat.NewChatClient(s.Dial())
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	login, err := client.Login(ctx, &chat.LoginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  codes.Code
+	}{
+		{"missing token", "", codes.Unauthenticated},
+		{"unknown token", "unknown", codes.InvalidArgument},
+	}
+	for _, tt := range tests {
+		stream, err := client.Subscribe(ctx, &chat.SubscribeRequest{Token: tt.token})
+		if err == nil {
+			_, err = stream.Recv()
+		}
+		if code := status.Code(err); code != tt.want {
+			t.Errorf("%v: Subscribe() code = %v, want %v", tt.name, code, tt.want)
+		}
+	}
+
+	stream, err := client.Subscribe(ctx, &chat.SubscribeRequest{Token: login.Token})
+	if err != nil {
+		t.Fatalf("Subscribe() error = %v", err)
+	}
+	// The headers come once the stream is registered
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Header() error = %v", err)
+	}
+	bob := s.Client("bob")
+	if err := bob.Send(ctx, "", "hi"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		if msg := res.GetClientMessage(); msg != nil {
+			if msg.Name != "bob" || msg.Message != "hi" {
+				t.Fatalf("alice received %q from %v, want hi from bob", msg.Message, msg.Name)
+			}
+			break
+		}
+	}
+}
+
+// webCall calls the method of the chat with gRPC-Web, the response body holds the frames of the answer
+func webCall(t *testing.T, base, method, token string, req proto.Message) *http.Response {
+
+	t.Helper()
+	data, err := proto.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, 5, 5+len(data))
+	binary.BigEndian.PutUint32(body[1:], uint32(len(data)))
+	body = append(body, data...)
+
+	r, err := http.NewRequest("POST", base+"/chat.Chat/"+method, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Header.Set("Content-Type", "application/grpc-web+proto")
+	r.Header.Set("X-Grpc-Web", "1")
+	if token != "" {
+		r.Header.Set("X-Chat-Token", token)
+	}
+	res, err := http.DefaultClient.Do(r)
+	if err != nil {
+		t.Fatalf("%v error = %v", method, err)
+	}
+	t.Cleanup(func() { res.Body.Close() })
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("%v status = %v", method, res.Status)
+	}
+	return res
+}
+
+// readFrame decodes the next data frame into msg, failing on the trailers
+func readFrame(t *testing.T, r *bufio.Reader, msg proto.Message) {
+
+	t.Helper()
+	header := make([]byte, 5)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatalf("failed to read a frame: %v", err)
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[1:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		t.Fatalf("failed to read a frame: %v", err)
+	}
+	if header[0]&0x80 != 0 {
+		t.Fatalf("got the trailers %q, want a message", data)
+	}
+	if err := proto.Unmarshal(data, msg); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGRPCWeb(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{GRPCWebOrigins: []string{"https://chat.example"}})
+	lis := listen(t)
+	go s.ServeGateway(lis)
+	base := "http://" + lis.Addr().String()
+	bob := s.Client("bob")
+
+	login := &chat.LoginResponse{}
+	readFrame(t, bufio.NewReader(webCall(t, base, "Login", "", &chat.LoginRequest{Username: "alice"}).Body), login)
+	if login.Token == "" {
+		t.Fatal("Login returned no token")
+	}
+	if got := bob.Next(chatclient.Login{}).(chatclient.Login); got.Name != "alice" {
+		t.Fatalf("bob saw %v log in, want alice", got.Name)
+	}
+
+	// The token is taken from the header, the way the generated clients pass it along
+	events := bufio.NewReader(webCall(t, base, "Subscribe", login.Token, &chat.SubscribeRequest{}).Body)
+	readFrame(t, bufio.NewReader(webCall(t, base, "Post", login.Token, &chat.PostRequest{Message: "hello from the browser"}).Body), &chat.PostResponse{})
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Name != "alice" || got.Text != "hello from the browser" {
+		t.Fatalf("bob received %q from %v, want the posted message", got.Text, got.Name)
+	}
+	if err := bob.Send(context.Background(), "", "hi"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	for _, want := range []string{"alice", "bob"} {
+		res := &chat.StreamResponse{}
+		readFrame(t, events, res)
+		if msg := res.GetClientMessage(); msg == nil || msg.Name != want {
+			t.Fatalf("alice received %v, want the message of %v", res, want)
+		}
+	}
+
+	tests := []struct {
+		origin string
+		allow  bool
+	}{
+		{"https://chat.example", true},
+		{"https://evil.example", false},
+	}
+	for _, tt := range tests {
+		r, err := http.NewRequest("OPTIONS", base+"/chat.Chat/Subscribe", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Origin", tt.origin)
+		r.Header.Set("Access-Control-Request-Method", "POST")
+		r.Header.Set("Access-Control-Request-Headers", "content-type,x-grpc-web,x-chat-token")
+		res, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatalf("preflight from %v error = %v", tt.origin, err)
+		}
+		res.Body.Close()
+		if got := res.Header.Get("Access-Control-Allow-Origin") == tt.origin; got != tt.allow {
+			t.Errorf("preflight from %v allowed = %v, want %v", tt.origin, got, tt.allow)
+		}
+	}
+}
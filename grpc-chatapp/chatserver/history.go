@@ -0,0 +1,182 @@
+package chatserver
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// historySize is the number of messages kept per room
+const historySize = 100
+
+// remember adds the client message to the history of its room, and applies the edits and the deletions
+// to it
+func (s *server) remember(res *chat.StreamResponse) {
+
+	switch ev := res.Event.(type) {
+	case *chat.StreamResponse_ClientMessage:
+	case *chat.StreamResponse_MessageEdit:
+		s.changeHistory(ev.MessageEdit.Room, ev.MessageEdit.Id, func(msg *chat.StreamResponse_Message) {
+			msg.Message = ev.MessageEdit.Message
+		})
+		return
+	case *chat.StreamResponse_MessageDelete:
+		s.changeHistory(ev.MessageDelete.Room, ev.MessageDelete.Id, nil)
+		return
+	default:
+		return
+	}
+	msg := res.GetClientMessage()
+
+	s.roomMutex.Lock()
+	defer s.roomMutex.Unlock()
+	r, ok := s.Rooms[msg.Room]
+	if !ok {
+		return
+	}
+	r.history = append(r.history, res)
+	if len(r.history) > historySize {
+		r.history = r.history[len(r.history)-historySize:]
+	}
+}
+
+// changeHistory replaces the message of the room with a copy changed by edit, or drops it when edit is nil.
+// The message is copied as the one in the history may be in the middle of being sent.
+func (s *server) changeHistory(room, id string, edit func(msg *chat.StreamResponse_Message)) {
+
+	s.roomMutex.Lock()
+	defer s.roomMutex.Unlock()
+	r, ok := s.Rooms[room]
+	if !ok {
+		return
+	}
+	for i, res := range r.history {
+		if res.GetClientMessage().GetId() != id {
+			continue
+		}
+		if edit == nil {
+			r.history = append(r.history[:i:i], r.history[i+1:]...)
+			return
+		}
+		res = proto.Clone(res).(*chat.StreamResponse)
+		edit(res.GetClientMessage())
+		r.history[i] = res
+		return
+	}
+}
+
+// findMessage returns the message of the room history the user may change
+func (s *server) findMessage(username, room, id string) (*chat.StreamResponse_Message, error) {
+
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	r, ok := s.Rooms[room]
+	if !ok || !r.canRead(username) {
+		return nil, errRoomNotFound
+	}
+	for _, res := range r.history {
+		if msg := res.GetClientMessage(); msg.GetId() == id && id != "" {
+			return msg, nil
+		}
+	}
+	return nil, status.Error(codes.NotFound, "message not found")
+}
+
+// authorizeChange resolves the token to the user changing the message, the authors may change theirs while
+// the other messages take act in the room
+func (s *server) authorizeChange(tkn, room, id string, act action) (string, string, error) {
+
+	name, ok := s.getClientName(tkn)
+	if !ok {
+		return "", "", status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if room == "" {
+		room = lobbyRoom
+	}
+	msg, err := s.findMessage(name, room, id)
+	if err != nil {
+		return "", "", err
+	}
+	if msg.Name == name {
+		act = actionPost
+	}
+	if !allowed(s.getRole(room, name), act) {
+		return "", "", status.Error(codes.PermissionDenied, "operation not permitted for the role")
+	}
+	return name, room, nil
+}
+
+func (s *server) EditMessage(ctx context.Context, req *chat.EditMessageRequest) (*chat.EditMessageResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new edit message request", "room", req.Room, "id", req.Id)
+	if req.Message == "" {
+		return nil, status.Error(codes.InvalidArgument, "message is required, delete it instead")
+	}
+	name, room, err := s.authorizeChange(req.Token, req.Room, req.Id, actionEdit)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish(ctx, &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_MessageEdit{
+			MessageEdit: &chat.StreamResponse_Edit{Id: req.Id, Room: room, Name: name, Message: req.Message},
+		},
+	})
+	return &chat.EditMessageResponse{}, nil
+}
+
+func (s *server) DeleteMessage(ctx context.Context, req *chat.DeleteMessageRequest) (*chat.DeleteMessageResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new delete message request", "room", req.Room, "id", req.Id)
+	name, room, err := s.authorizeChange(req.Token, req.Room, req.Id, actionDelete)
+	if err != nil {
+		return nil, err
+	}
+
+	s.publish(ctx, &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_MessageDelete{
+			MessageDelete: &chat.StreamResponse_Delete{Id: req.Id, Room: room, Name: name},
+		},
+	})
+	return &chat.DeleteMessageResponse{}, nil
+}
+
+func (s *server) History(ctx context.Context, req *chat.HistoryRequest) (*chat.HistoryResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new history request", "room", req.Room, "limit", req.Limit)
+	name, ok := s.getClientName(req.Token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if req.Limit < 0 {
+		return nil, status.Error(codes.InvalidArgument, "limit must not be negative")
+	}
+	roomName := req.Room
+	if roomName == "" {
+		roomName = lobbyRoom
+	}
+
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	r, ok := s.Rooms[roomName]
+	if !ok || !r.canList(name) {
+		return nil, errRoomNotFound
+	}
+	if !r.canRead(name) {
+		return nil, status.Error(codes.PermissionDenied, "room requires an invite")
+	}
+
+	messages := r.history
+	if req.Limit > 0 && int(req.Limit) < len(messages) {
+		messages = messages[len(messages)-int(req.Limit):]
+	}
+	// The history keeps changing once the lock is released
+	return &chat.HistoryResponse{Messages: append([]*chat.StreamResponse(nil), messages...)}, nil
+}
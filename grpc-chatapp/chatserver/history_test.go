@@ -0,0 +1,121 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func message(room, text string) *chat.StreamResponse {
+	return &chat.StreamResponse{
+		Event: &chat.StreamResponse_ClientMessage{
+			ClientMessage: &chat.StreamResponse_Message{Name: "alice", Message: text, Room: room},
+		},
+	}
+}
+
+func TestHistory(t *testing.T) {
+
+	s := newRoomsServer(t)
+	for i := 0; i < historySize+5; i++ {
+		s.remember(message("public", fmt.Sprint(i)))
+	}
+	s.remember(message("private", "secret"))
+
+	res, err := s.History(context.Background(), &chat.HistoryRequest{Token: "tkn-bob", Room: "public"})
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	if len(res.Messages) != historySize || res.Messages[0].GetClientMessage().Message != "5" {
+		t.Fatalf("History() returned %v messages starting at %v, want the last %v", len(res.Messages), res.Messages[0], historySize)
+	}
+
+	res, err = s.History(context.Background(), &chat.HistoryRequest{Token: "tkn-bob", Room: "public", Limit: 2})
+	if err != nil {
+		t.Fatalf("History(limit 2) error = %v", err)
+	}
+	if len(res.Messages) != 2 || res.Messages[1].GetClientMessage().Message != fmt.Sprint(historySize+4) {
+		t.Fatalf("History(limit 2) = %v, want the last 2 messages", res.Messages)
+	}
+
+	tests := []struct {
+		tkn  string
+		room string
+		want codes.Code
+	}{
+		{"tkn-alice", "private", codes.OK},
+		{"tkn-bob", "private", codes.NotFound},
+		{"tkn-bob", "invite", codes.PermissionDenied},
+		{"tkn-bob", "missing", codes.NotFound},
+		{"tkn-carol", "public", codes.Unauthenticated},
+	}
+	for _, tt := range tests {
+		_, err := s.History(context.Background(), &chat.HistoryRequest{Token: tt.tkn, Room: tt.room})
+		if code := status.Code(err); code != tt.want {
+			t.Errorf("History(%v, %v) code = %v, want %v", tt.tkn, tt.room, code, tt.want)
+		}
+	}
+}
+
+func TestEditAndDeleteMessage(t *testing.T) {
+
+	ctx := context.Background()
+	s := newRoomsServer(t)
+	s.addClientName("carol", "tkn-carol")
+	s.setRole("", "carol", chat.Role_MEMBER)
+	s.setRole("public", "carol", chat.Role_MODERATOR)
+	if err := s.post(ctx, "alice", "public", "", "hello"); err != nil {
+		t.Fatalf("post() error = %v", err)
+	}
+	if err := s.post(ctx, "bob", "public", "", "helo"); err != nil {
+		t.Fatalf("post() error = %v", err)
+	}
+	res, err := s.History(ctx, &chat.HistoryRequest{Token: "tkn-bob", Room: "public"})
+	if err != nil {
+		t.Fatalf("History() error = %v", err)
+	}
+	alice, bob := res.Messages[0].GetClientMessage().Id, res.Messages[1].GetClientMessage().Id
+
+	edits := []struct {
+		name  string
+		token string
+		id    string
+		text  string
+		code  codes.Code
+	}{
+		{"author", "tkn-bob", bob, "hello", codes.OK},
+		{"member", "tkn-bob", alice, "bye", codes.PermissionDenied},
+		{"moderator of the room", "tkn-carol", alice, "hello all", codes.OK},
+		{"empty", "tkn-bob", bob, "", codes.InvalidArgument},
+		{"unknown message", "tkn-bob", "unknown", "hi", codes.NotFound},
+		{"unknown token", "tkn-dave", bob, "hi", codes.Unauthenticated},
+	}
+	for _, tt := range edits {
+		_, err := s.EditMessage(ctx, &chat.EditMessageRequest{Token: tt.token, Room: "public", Id: tt.id, Message: tt.text})
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("%v: EditMessage() code = %v, want %v", tt.name, code, tt.code)
+		}
+	}
+	res, _ = s.History(ctx, &chat.HistoryRequest{Token: "tkn-bob", Room: "public"})
+	if got := res.Messages[0].GetClientMessage().Message + ", " + res.Messages[1].GetClientMessage().Message; got != "hello all, hello" {
+		t.Fatalf("history after the edits = %q, want the edited messages", got)
+	}
+
+	if _, err := s.DeleteMessage(ctx, &chat.DeleteMessageRequest{Token: "tkn-bob", Room: "public", Id: alice}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("DeleteMessage() of another user by a member error = %v, want %v", err, codes.PermissionDenied)
+	}
+	if _, err := s.DeleteMessage(ctx, &chat.DeleteMessageRequest{Token: "tkn-carol", Room: "public", Id: alice}); err != nil {
+		t.Fatalf("DeleteMessage() by a moderator error = %v", err)
+	}
+	if _, err := s.DeleteMessage(ctx, &chat.DeleteMessageRequest{Token: "tkn-bob", Room: "public", Id: bob}); err != nil {
+		t.Fatalf("DeleteMessage() by the author error = %v", err)
+	}
+	res, _ = s.History(ctx, &chat.HistoryRequest{Token: "tkn-bob", Room: "public"})
+	if len(res.Messages) != 0 {
+		t.Fatalf("history after the deletions = %v, want none", res.Messages)
+	}
+}
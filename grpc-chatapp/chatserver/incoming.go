@@ -0,0 +1,122 @@
+package chatserver
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// incomingWebhookPath is where the gateway serves the incoming webhooks, followed by their token
+	incomingWebhookPath = "/hooks/"
+	// maxIncomingPayload bounds the payloads of the incoming webhooks
+	maxIncomingPayload = 64 << 10
+)
+
+// IncomingWebhook lets a service post to a room over HTTP without logging in. The gateway serves it on
+// /hooks/<token>, the messages are sent by Name and checked like those of the users.
+type IncomingWebhook struct {
+	Room string `json:"room"`
+	// Name is the identity the messages are sent by, nobody can log in under it
+	Name string `json:"name"`
+	// Token is the secret part of the URL of the webhook
+	Token string `json:"token"`
+}
+
+// incomingPayload is the body posted to an incoming webhook
+type incomingPayload struct {
+	Text string `json:"text"`
+}
+
+// useIncomingWebhooks checks the webhooks and sets their identities up. The rooms that exist so far are
+// public, the webhooks become members of the others when they are created.
+func (s *server) useIncomingWebhooks(hooks []IncomingWebhook) error {
+
+	tokens := make(map[string]bool)
+	for _, h := range hooks {
+		if h.Room == "" || h.Name == "" || h.Token == "" {
+			return fmt.Errorf("incoming webhook of %q in %q: room, name and token are required", h.Name, h.Room)
+		}
+		if strings.Contains(h.Name, domainSeparator) {
+			return fmt.Errorf("incoming webhook %q: the name cannot contain %v", h.Name, domainSeparator)
+		}
+		if tokens[h.Token] {
+			return fmt.Errorf("incoming webhook %q: the token is used twice", h.Name)
+		}
+		tokens[h.Token] = true
+	}
+
+	s.roleMutex.Lock()
+	defer s.roleMutex.Unlock()
+	for _, h := range hooks {
+		s.reserved[h.Name] = true
+		if _, ok := s.ClientRole[roleKey{user: h.Name}]; !ok {
+			s.ClientRole[roleKey{user: h.Name}] = defaultRole
+		}
+	}
+	s.incoming = hooks
+	return nil
+}
+
+// incomingWebhook returns the webhook of the token
+func (s *server) incomingWebhook(tkn string) (IncomingWebhook, bool) {
+
+	for _, h := range s.incoming {
+		if subtle.ConstantTimeCompare([]byte(h.Token), []byte(tkn)) == 1 {
+			return h, true
+		}
+	}
+	return IncomingWebhook{}, false
+}
+
+// serveIncoming posts the payloads of the incoming webhooks to their room
+func (s *server) serveIncoming(w http.ResponseWriter, r *http.Request) {
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	h, ok := s.incomingWebhook(strings.TrimPrefix(r.URL.Path, incomingWebhookPath))
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	if s.isDraining() {
+		http.Error(w, status.Convert(errShuttingDown).Message(), http.StatusServiceUnavailable)
+		return
+	}
+
+	var p incomingPayload
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxIncomingPayload)).Decode(&p); err != nil {
+		http.Error(w, "invalid payload: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	if strings.TrimSpace(p.Text) == "" {
+		http.Error(w, "text is required", http.StatusBadRequest)
+		return
+	}
+
+	if err := s.post(r.Context(), h.Name, h.Room, "", p.Text); err != nil {
+		level.Warn(s.logger).Log("message", "rejected the payload of an incoming webhook", "name", h.Name, "room", h.Room, "err", err)
+		code := http.StatusInternalServerError
+		switch status.Code(err) {
+		case codes.NotFound:
+			code = http.StatusNotFound
+		case codes.PermissionDenied:
+			code = http.StatusForbidden
+		case codes.ResourceExhausted:
+			code = http.StatusTooManyRequests
+		}
+		http.Error(w, status.Convert(err).Message(), code)
+		return
+	}
+	level.Info(s.logger).Log("message", "posted the payload of an incoming webhook", "name", h.Name, "room", h.Room)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,116 @@
+package chatserver_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestIncomingWebhooks(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{IncomingWebhooks: []chatserver.IncomingWebhook{
+		{Room: "lobby", Name: "ci", Token: "lobby-token"},
+		{Room: "ops", Name: "deploy", Token: "ops-token"},
+	}})
+	lis := listen(t)
+	go s.ServeGateway(lis)
+	bob := s.Client("bob")
+	base := "http://" + lis.Addr().String()
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"valid", "POST", "/hooks/lobby-token", `{"text": "build passed"}`, http.StatusNoContent},
+		{"unknown token", "POST", "/hooks/other-token", `{"text": "build passed"}`, http.StatusNotFound},
+		{"no token", "POST", "/hooks/", `{"text": "build passed"}`, http.StatusNotFound},
+		{"not a post", "GET", "/hooks/lobby-token", "", http.StatusMethodNotAllowed},
+		{"malformed payload", "POST", "/hooks/lobby-token", `{"text":`, http.StatusBadRequest},
+		{"empty text", "POST", "/hooks/lobby-token", `{"text": " "}`, http.StatusBadRequest},
+		{"missing room", "POST", "/hooks/ops-token", `{"text": "deployed"}`, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		req, err := http.NewRequest(tt.method, base+tt.path, strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("%v: %v %v error = %v", tt.name, tt.method, tt.path, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != tt.want {
+			t.Errorf("%v: %v %v = %v, want %v", tt.name, tt.method, tt.path, res.StatusCode, tt.want)
+		}
+	}
+
+	// Only the valid payload made it to the room
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Name != "ci" || got.Room != "lobby" || got.Text != "build passed" {
+		t.Fatalf("bob received %q in %v from %v, want the payload of the ci webhook", got.Text, got.Room, got.Name)
+	}
+
+	// The webhook is a member of its private room once created, and stays out once kicked
+	ctx := context.Background()
+	if err := bob.CreateRoom(ctx, "ops", chat.Visibility_PRIVATE); err != nil {
+		t.Fatalf("CreateRoom() error = %v", err)
+	}
+	if code := post(t, base+"/hooks/ops-token", `{"text": "deployed"}`); code != http.StatusNoContent {
+		t.Fatalf("POST to the private room of the webhook = %v, want %v", code, http.StatusNoContent)
+	}
+	if err := bob.Kick(ctx, "ops", "deploy"); err != nil {
+		t.Fatalf("Kick() error = %v", err)
+	}
+	if code := post(t, base+"/hooks/ops-token", `{"text": "deployed"}`); code != http.StatusNotFound {
+		t.Fatalf("POST once kicked out of the room = %v, want %v", code, http.StatusNotFound)
+	}
+
+	_, err := chat.NewChatClient(s.Dial()).Login(ctx, &chat.LoginRequest{Username: "ci"})
+	if code := status.Code(err); code != codes.AlreadyExists {
+		t.Fatalf("Login() as the identity of a webhook code = %v, want %v", code, codes.AlreadyExists)
+	}
+
+	_, err = chatserver.NewServer(chatserver.Options{IncomingWebhooks: []chatserver.IncomingWebhook{{Room: "lobby", Name: "ci"}}})
+	if err == nil {
+		t.Fatal("NewServer() with an incoming webhook without a token returned no error")
+	}
+}
+
+// post sends the payload to the incoming webhook and returns the status code of the response
+func post(t *testing.T, url, body string) int {
+
+	t.Helper()
+	res, err := http.Post(url, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("POST %v error = %v", url, err)
+	}
+	res.Body.Close()
+	return res.StatusCode
+}
+
+func TestIncomingWebhookRateLimit(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{
+		IncomingWebhooks: []chatserver.IncomingWebhook{{Room: "lobby", Name: "ci", Token: "lobby-token"}},
+		RateLimit:        chatserver.RateLimit{PerSecond: 0.01, Burst: 2},
+	})
+	lis := listen(t)
+	go s.ServeGateway(lis)
+	url := "http://" + lis.Addr().String() + "/hooks/lobby-token"
+
+	for _, want := range []int{http.StatusNoContent, http.StatusNoContent, http.StatusTooManyRequests} {
+		if code := post(t, url, `{"text": "build passed"}`); code != want {
+			t.Fatalf("POST = %v, want %v", code, want)
+		}
+	}
+}
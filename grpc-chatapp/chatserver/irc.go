@@ -0,0 +1,40 @@
+package chatserver
+
+import (
+	"crypto/tls"
+	"net"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/ircgateway"
+	"google.golang.org/grpc"
+)
+
+// ServeIRC serves the chat to IRC clients on lis until Shutdown is called, over TLS when the server has a
+// certificate. The IRC gateway calls the chat service in process, like the REST gateway.
+func (s *Server) ServeIRC(lis net.Listener) error {
+
+	if s.opts.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(s.opts.TLSCert, s.opts.TLSKey)
+		if err != nil {
+			return err
+		}
+		lis = tls.NewListener(lis, &tls.Config{Certificates: []tls.Certificate{cert}, MinVersion: tls.VersionTLS12})
+	}
+	cc, err := s.dialInProcess()
+	if err != nil {
+		return err
+	}
+	logger := s.logger
+	if s.opts.Logging != nil {
+		logger = s.opts.Logging.Component("irc")
+	}
+	irc := ircgateway.New(cc, logger)
+
+	s.gateway.mu.Lock()
+	if s.gateway.closed {
+		s.gateway.mu.Unlock()
+		return grpc.ErrServerStopped
+	}
+	s.gateway.ircs = append(s.gateway.ircs, irc)
+	s.gateway.mu.Unlock()
+	return irc.Serve(lis)
+}
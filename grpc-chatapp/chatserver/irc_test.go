@@ -0,0 +1,56 @@
+package chatserver_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+)
+
+func TestServeIRC(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	lis := listen(t)
+	go s.ServeIRC(lis)
+	bob := s.Client("bob")
+
+	conn, err := net.Dial("tcp", lis.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(chattest.Timeout))
+	conn.Write([]byte("NICK alice\r\nUSER alice 0 * :Alice\r\nJOIN #lobby\r\n"))
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read the JOIN: %v", err)
+		}
+		if strings.HasPrefix(line, ":alice!alice@chat JOIN") {
+			break
+		}
+	}
+	if err := bob.Send(context.Background(), "lobby", "hi"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("failed to read the message: %v", err)
+		}
+		if line == ":bob!bob@chat PRIVMSG #lobby :hi\r\n" {
+			break
+		}
+	}
+	if login := bob.Next(chatclient.Login{}).(chatclient.Login); login.Name != "alice" {
+		t.Fatalf("bob saw %v log in, want alice", login.Name)
+	}
+}
@@ -0,0 +1,150 @@
+package chatserver
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/status"
+)
+
+const metricsNamespace = "chat"
+
+// The reasons the events are dropped for
+const (
+	dropQueueFull     = "queue_full"
+	dropPublishFailed = "publish_failed"
+	dropClosed        = "closed"
+)
+
+type metrics struct {
+	registry         *prometheus.Registry
+	connectedStreams prometheus.Gauge
+	messages         prometheus.Counter
+	droppedEvents    *prometheus.CounterVec
+	loginFailures    prometheus.Counter
+	rateLimited      prometheus.Counter
+	rpcDuration      *prometheus.HistogramVec
+}
+
+// channelCollector reports the occupancy of the common and per-client channels at scrape time. The client
+// queues are summed up by the longest one, a series per client would grow with every name used.
+type channelCollector struct {
+	s             *server
+	commonChannel *prometheus.Desc
+	clientChannel *prometheus.Desc
+	sessions      *prometheus.Desc
+}
+
+func (c *channelCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.commonChannel
+	ch <- c.clientChannel
+	ch <- c.sessions
+}
+
+func (c *channelCollector) Collect(ch chan<- prometheus.Metric) {
+
+	ch <- prometheus.MustNewConstMetric(c.commonChannel, prometheus.GaugeValue, float64(len(c.s.CommonChannel)))
+
+	c.s.nameMutex.RLock()
+	ch <- prometheus.MustNewConstMetric(c.sessions, prometheus.GaugeValue, float64(len(c.s.ClientName)))
+	c.s.nameMutex.RUnlock()
+
+	c.s.streamMutex.RLock()
+	longest := 0
+	for _, stream := range c.s.ClientStream {
+		if n := stream.Len(); n > longest {
+			longest = n
+		}
+	}
+	c.s.streamMutex.RUnlock()
+	ch <- prometheus.MustNewConstMetric(c.clientChannel, prometheus.GaugeValue, float64(longest))
+}
+
+func newMetrics(s *server) *metrics {
+
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		connectedStreams: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "connected_streams",
+			Help:      "Number of open client streams.",
+		}),
+		messages: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "messages_total",
+			Help:      "Number of client messages accepted by the server.",
+		}),
+		droppedEvents: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "dropped_events_total",
+			Help:      "Number of events dropped, by reason: a full client queue, a failed publish or a closed server.",
+		}, []string{"reason"}),
+		loginFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "login_failures_total",
+			Help:      "Number of failed login requests.",
+		}),
+		rateLimited: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rate_limited_total",
+			Help:      "Number of messages refused because they were sent too fast.",
+		}),
+		rpcDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "rpc_duration_seconds",
+			Help:      "Latency of the gRPC calls handled by the server.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "code"}),
+	}
+
+	// The reasons are reported before anything is dropped
+	for _, reason := range []string{dropQueueFull, dropPublishFailed, dropClosed} {
+		m.droppedEvents.WithLabelValues(reason)
+	}
+	m.registry.MustRegister(
+		m.connectedStreams,
+		m.messages,
+		m.droppedEvents,
+		m.loginFailures,
+		m.rateLimited,
+		m.rpcDuration,
+		&channelCollector{
+			s: s,
+			commonChannel: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "common_channel_length"),
+				"Number of events waiting in the common channel.", nil, nil),
+			clientChannel: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "client_channel_length_max"),
+				"Number of events waiting in the longest client queue.", nil, nil),
+			sessions: prometheus.NewDesc(prometheus.BuildFQName(metricsNamespace, "", "sessions"),
+				"Number of logged in sessions.", nil, nil),
+		},
+	)
+	return m
+}
+
+func (m *metrics) handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+func (m *metrics) observe(method string, start time.Time, err error) {
+	m.rpcDuration.WithLabelValues(method, status.Code(err).String()).Observe(time.Since(start).Seconds())
+}
+
+func (m *metrics) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	start := time.Now()
+	res, err := handler(ctx, req)
+	m.observe(info.FullMethod, start, err)
+	return res, err
+}
+
+func (m *metrics) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	start := time.Now()
+	err := handler(srv, ss)
+	m.observe(info.FullMethod, start, err)
+	return err
+}
@@ -0,0 +1,68 @@
+package chatserver
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc"
+)
+
+func TestMetricsEndpoint(t *testing.T) {
+
+	s := newServer(log.NewNopLogger())
+	info := &grpc.UnaryServerInfo{FullMethod: "/chat.Chat/Login"}
+	login := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return s.Login(ctx, req.(*chat.LoginRequest))
+	}
+	res, err := s.metrics.unaryInterceptor(context.Background(), &chat.LoginRequest{Username: "alice"}, info, login)
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	if _, err := s.metrics.unaryInterceptor(context.Background(), &chat.LoginRequest{}, info, login); err == nil {
+		t.Fatalf("Login() without username succeeded")
+	}
+	s.OpenStream(res.(*chat.LoginResponse).Token)
+	// The longest queue is reported whatever the number of streams, a user with two of them included
+	bob, _ := s.Login(context.Background(), &chat.LoginRequest{Username: "bob"})
+	for _, tkn := range []string{bob.Token, "tkn-bob-2"} {
+		s.addClientName("bob", tkn)
+		stream, _ := s.OpenStream(tkn)
+		stream.TryPush(event{ctx: context.Background(), res: &chat.StreamResponse{}})
+	}
+
+	srv := httptest.NewServer(s.metrics.handler())
+	defer srv.Close()
+	scrape, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("scraping the metrics failed: %v", err)
+	}
+	defer scrape.Body.Close()
+	body, err := ioutil.ReadAll(scrape.Body)
+	if err != nil {
+		t.Fatalf("reading the metrics failed: %v", err)
+	}
+
+	for _, want := range []string{
+		"chat_connected_streams 0",
+		"chat_sessions 3",
+		"chat_common_channel_length 2",
+		"chat_client_channel_length_max 1\n",
+		"chat_login_failures_total 1",
+		"chat_messages_total 0",
+		`chat_dropped_events_total{reason="queue_full"} 0`,
+		`chat_dropped_events_total{reason="publish_failed"} 0`,
+		`chat_dropped_events_total{reason="closed"} 0`,
+		`chat_rpc_duration_seconds_count{code="OK",method="/chat.Chat/Login"} 1`,
+		`chat_rpc_duration_seconds_count{code="InvalidArgument",method="/chat.Chat/Login"} 1`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Errorf("metrics do not contain %q", want)
+		}
+	}
+}
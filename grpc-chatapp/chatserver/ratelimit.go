@@ -0,0 +1,99 @@
+package chatserver
+
+import (
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// minLimiterPrune is how many identities the limiter tracks before it first forgets the idle ones
+const minLimiterPrune = 1024
+
+// errRateLimited is returned for the messages sent faster than the limit
+var errRateLimited = status.Error(codes.ResourceExhausted, "too many messages, slow down")
+
+// RateLimit bounds how fast every identity posts, the users and the bots by name and the incoming webhooks
+// by the name they post as
+type RateLimit struct {
+	// PerSecond is how many messages an identity sends per second in the long run, there is no limit when zero
+	PerSecond float64
+	// Burst is how many messages an identity sends in a row, 1 when lower
+	Burst int
+}
+
+// bucket holds the tokens of an identity as of last
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// limiter is a token bucket per identity
+type limiter struct {
+	cfg RateLimit
+	now func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	// pruneAt is the number of buckets past which the full ones are forgotten
+	pruneAt int
+}
+
+func newLimiter(cfg RateLimit) *limiter {
+
+	if cfg.Burst < 1 {
+		cfg.Burst = 1
+	}
+	return &limiter{cfg: cfg, now: time.Now, buckets: make(map[string]*bucket), pruneAt: minLimiterPrune}
+}
+
+// allow takes a token from the bucket of the identity, it reports false when there is none left
+func (l *limiter) allow(name string) bool {
+
+	if l == nil || l.cfg.PerSecond <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	b, ok := l.buckets[name]
+	if !ok {
+		l.prune(now)
+		b = &bucket{tokens: float64(l.cfg.Burst), last: now}
+		l.buckets[name] = b
+	}
+	b.refill(now, l.cfg)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+func (b *bucket) refill(now time.Time, cfg RateLimit) {
+
+	b.tokens += now.Sub(b.last).Seconds() * cfg.PerSecond
+	if max := float64(cfg.Burst); b.tokens > max {
+		b.tokens = max
+	}
+	b.last = now
+}
+
+// prune forgets the identities whose bucket filled up again, they start over with a full one anyway.
+// It runs once the buckets outgrow pruneAt, which then follows their number so that the cost stays spread.
+func (l *limiter) prune(now time.Time) {
+
+	if len(l.buckets) < l.pruneAt {
+		return
+	}
+	for name, b := range l.buckets {
+		b.refill(now, l.cfg)
+		if b.tokens >= float64(l.cfg.Burst) {
+			delete(l.buckets, name)
+		}
+	}
+	if l.pruneAt = 2 * len(l.buckets); l.pruneAt < minLimiterPrune {
+		l.pruneAt = minLimiterPrune
+	}
+}
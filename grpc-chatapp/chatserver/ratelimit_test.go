@@ -0,0 +1,91 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// clock is a time that only moves when told to
+type clock struct{ t time.Time }
+
+func (c *clock) now() time.Time { return c.t }
+
+func TestLimiter(t *testing.T) {
+
+	tests := []struct {
+		name  string
+		cfg   RateLimit
+		after time.Duration
+		want  []bool
+	}{
+		{"burst", RateLimit{PerSecond: 1, Burst: 3}, 0, []bool{true, true, true, false}},
+		{"burst of one when lower", RateLimit{PerSecond: 1}, 0, []bool{true, false}},
+		{"refilled", RateLimit{PerSecond: 2, Burst: 1}, 500 * time.Millisecond, []bool{true, true, true}},
+		{"not refilled yet", RateLimit{PerSecond: 2, Burst: 1}, 100 * time.Millisecond, []bool{true, false, false}},
+		{"capped at the burst", RateLimit{PerSecond: 10, Burst: 2}, time.Hour, []bool{true, true, true, true}},
+		{"no limit", RateLimit{}, 0, []bool{true, true, true, true}},
+	}
+	for _, tt := range tests {
+		c := &clock{t: time.Now()}
+		l := newLimiter(tt.cfg)
+		l.now = c.now
+		for i, want := range tt.want {
+			if got := l.allow("alice"); got != want {
+				t.Errorf("%v: allow() #%d = %v, want %v", tt.name, i, got, want)
+			}
+			c.t = c.t.Add(tt.after)
+		}
+	}
+}
+
+func TestLimiterForgetsIdleIdentities(t *testing.T) {
+
+	c := &clock{t: time.Now()}
+	l := newLimiter(RateLimit{PerSecond: 1, Burst: 1})
+	l.now = c.now
+	for i := 0; i < minLimiterPrune; i++ {
+		l.allow(fmt.Sprint("user", i))
+	}
+	// The buckets filled up again, they are forgotten when the next identity comes along
+	c.t = c.t.Add(time.Second)
+	if !l.allow("alice") || l.allow("alice") {
+		t.Fatalf("allow() did not limit alice")
+	}
+	if n := len(l.buckets); n != 1 {
+		t.Fatalf("the limiter tracks %d identities, want alice alone", n)
+	}
+}
+
+func TestPostRateLimit(t *testing.T) {
+
+	s := newRoomsServer(t)
+	c := &clock{t: time.Now()}
+	s.limiter = newLimiter(RateLimit{PerSecond: 1, Burst: 2})
+	s.limiter.now = c.now
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if err := s.post(ctx, "bob", "public", "", "hi"); err != nil {
+			t.Fatalf("post() #%d error = %v", i, err)
+		}
+	}
+	if err := s.post(ctx, "bob", "public", "", "hi"); status.Code(err) != codes.ResourceExhausted {
+		t.Fatalf("post() past the burst error = %v, want %v", err, codes.ResourceExhausted)
+	}
+	// The limit is per identity, and the refused messages are not published
+	if err := s.post(ctx, "alice", "public", "", "hi"); err != nil {
+		t.Fatalf("post() as alice error = %v", err)
+	}
+	if n := len(s.CommonChannel); n != 3 {
+		t.Fatalf("%d messages were published, want 3", n)
+	}
+	c.t = c.t.Add(time.Second)
+	if err := s.post(ctx, "bob", "public", "", "hi"); err != nil {
+		t.Fatalf("post() a second later error = %v", err)
+	}
+}
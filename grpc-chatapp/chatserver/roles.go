@@ -0,0 +1,246 @@
+package chatserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// defaultRole is given to every user that logs in after the first one,
+// the first user becomes the owner of the chat.
+const defaultRole = chat.Role_MEMBER
+
+const (
+	// ownerClaim is the claim on the owner of the chat
+	ownerClaim = "owner"
+	// ownerClaimTTL is how long the instances sharing a broker are given to learn about the owner
+	ownerClaimTTL = 10 * time.Second
+)
+
+// action is an operation a user can perform in the chat
+type action int
+
+const (
+	actionPost action = iota
+	actionEdit
+	actionDelete
+	actionInvite
+	actionKick
+	actionCreateRoom
+	actionManageRoles
+	// actionAdminister covers the Admin service
+	actionAdminister
+)
+
+// permissions is the matrix of actions every role is allowed to perform
+var permissions = map[chat.Role]map[action]bool{
+	chat.Role_GUEST: {},
+	chat.Role_MEMBER: {
+		actionPost:       true,
+		actionInvite:     true,
+		actionCreateRoom: true,
+	},
+	chat.Role_MODERATOR: {
+		actionPost:       true,
+		actionInvite:     true,
+		actionCreateRoom: true,
+		actionEdit:       true,
+		actionDelete:     true,
+		actionKick:       true,
+	},
+	chat.Role_OWNER: {
+		actionPost:        true,
+		actionInvite:      true,
+		actionCreateRoom:  true,
+		actionEdit:        true,
+		actionDelete:      true,
+		actionKick:        true,
+		actionManageRoles: true,
+		actionAdminister:  true,
+	},
+}
+
+func allowed(role chat.Role, act action) bool {
+	return permissions[role][act]
+}
+
+// roleKey is a user in a room, the room is empty for the chat-wide role
+type roleKey struct {
+	room, user string
+}
+
+// assignRole gives the user the default role unless it has one, the first user becomes the owner of the chat
+func (s *server) assignRole(ctx context.Context, username string) chat.Role {
+
+	s.roleMutex.Lock()
+	if role, ok := s.ClientRole[roleKey{user: username}]; ok {
+		s.roleMutex.Unlock()
+		return role
+	}
+	// The reserved identities are given their role before anyone logs in, they do not count
+	role := chat.Role_OWNER
+	for k := range s.ClientRole {
+		if k.room == "" && !s.reserved[k.user] {
+			role = defaultRole
+			break
+		}
+	}
+	s.roleMutex.Unlock()
+	// The instances sharing a broker could each see their first user before the role of the other one
+	// reached them, the one claiming the chat first makes its user the owner
+	if role == chat.Role_OWNER && s.shared {
+		if ok, err := s.broker.Claim(ctx, ownerClaim, ownerClaimTTL); err != nil || !ok {
+			role = defaultRole
+		}
+	}
+
+	s.roleMutex.Lock()
+	if existing, ok := s.ClientRole[roleKey{user: username}]; ok {
+		s.roleMutex.Unlock()
+		return existing
+	}
+	level.Debug(s.logger).Log("message", "assigning the client role", "client", username, "role", role)
+	s.ClientRole[roleKey{user: username}] = role
+	s.roleMutex.Unlock()
+	s.share(ctx, s.roleChange("", username))
+	return role
+}
+
+// getRole returns the role of the user in the room, the chat-wide one when it was given none there or
+// when room is empty
+func (s *server) getRole(room, username string) chat.Role {
+
+	s.roleMutex.RLock()
+	defer s.roleMutex.RUnlock()
+	if role, ok := s.ClientRole[roleKey{room, username}]; ok {
+		return role
+	}
+	role, ok := s.ClientRole[roleKey{user: username}]
+	if !ok {
+		return chat.Role_GUEST
+	}
+	return role
+}
+
+func (s *server) setRole(room, username string, role chat.Role) {
+
+	s.roleMutex.Lock()
+	defer s.roleMutex.Unlock()
+	level.Debug(s.logger).Log("message", "setting the client role", "client", username, "room", room, "role", role)
+	s.ClientRole[roleKey{room, username}] = role
+}
+
+// revokeRole drops the role of the user in the room, the chat-wide role goes back to the default one
+func (s *server) revokeRole(room, username string) {
+
+	s.roleMutex.Lock()
+	defer s.roleMutex.Unlock()
+	level.Debug(s.logger).Log("message", "revoking the client role", "client", username, "room", room)
+	if room != "" {
+		delete(s.ClientRole, roleKey{room, username})
+		return
+	}
+	s.ClientRole[roleKey{user: username}] = defaultRole
+}
+
+// isReserved reports whether the name belongs to an identity nobody can log in under
+func (s *server) isReserved(username string) bool {
+
+	s.roleMutex.RLock()
+	defer s.roleMutex.RUnlock()
+	return s.reserved[username]
+}
+
+// authorize resolves the token to a username and checks that its chat-wide role allows the action
+func (s *server) authorize(tkn string, act action) (string, error) {
+	return s.authorizeIn(tkn, "", act)
+}
+
+// authorizeIn resolves the token to a username and checks that its role in the room allows the action
+func (s *server) authorizeIn(tkn, room string, act action) (string, error) {
+
+	name, ok := s.getClientName(tkn)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if !allowed(s.getRole(room, name), act) {
+		return name, status.Error(codes.PermissionDenied, "operation not permitted for the role")
+	}
+	return name, nil
+}
+
+// checkRoleRoom makes sure the room of a role change exists for the user, the chat-wide roles have none
+func (s *server) checkRoleRoom(username, room string) error {
+
+	if room == "" {
+		return nil
+	}
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	if r, ok := s.Rooms[room]; !ok || !r.canList(username) {
+		return errRoomNotFound
+	}
+	return nil
+}
+
+// outranks checks that the role of the user in the room is not below the one of the target, the chat-wide
+// roles break the ties so that the owners of a room cannot act on the owners of the chat
+func (s *server) outranks(room, username, target string) error {
+
+	mine, theirs := s.getRole(room, username), s.getRole(room, target)
+	if theirs > mine || (theirs == mine && s.getRole("", target) > s.getRole("", username)) {
+		return status.Error(codes.PermissionDenied, "the user has a higher role")
+	}
+	return nil
+}
+
+func (s *server) GrantRole(ctx context.Context, req *chat.GrantRoleRequest) (*chat.GrantRoleResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new grant role request", "target", req.Username, "room", req.Room, "role", req.Role)
+	name, err := s.authorizeIn(req.Token, req.Room, actionManageRoles)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRoleRoom(name, req.Room); err != nil {
+		return nil, err
+	}
+	if name == req.Username {
+		return nil, status.Error(codes.FailedPrecondition, "cannot change your own role")
+	}
+	if err := s.outranks(req.Room, name, req.Username); err != nil {
+		return nil, err
+	}
+	if _, ok := chat.Role_name[int32(req.Role)]; !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown role")
+	}
+
+	s.setRole(req.Room, req.Username, req.Role)
+	s.share(ctx, s.roleChange(req.Room, req.Username))
+	return &chat.GrantRoleResponse{}, nil
+}
+
+func (s *server) RevokeRole(ctx context.Context, req *chat.RevokeRoleRequest) (*chat.RevokeRoleResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new revoke role request", "target", req.Username, "room", req.Room)
+	name, err := s.authorizeIn(req.Token, req.Room, actionManageRoles)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkRoleRoom(name, req.Room); err != nil {
+		return nil, err
+	}
+	if name == req.Username {
+		return nil, status.Error(codes.FailedPrecondition, "cannot change your own role")
+	}
+	if err := s.outranks(req.Room, name, req.Username); err != nil {
+		return nil, err
+	}
+
+	s.revokeRole(req.Room, req.Username)
+	s.share(ctx, s.roleChange(req.Room, req.Username))
+	return &chat.RevokeRoleResponse{}, nil
+}
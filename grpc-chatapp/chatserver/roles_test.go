@@ -0,0 +1,127 @@
+package chatserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestAllowed(t *testing.T) {
+
+	tests := []struct {
+		role    chat.Role
+		allowed []action
+	}{
+		{chat.Role_GUEST, nil},
+		{chat.Role_MEMBER, []action{actionPost, actionInvite, actionCreateRoom}},
+		{chat.Role_MODERATOR, []action{actionPost, actionInvite, actionCreateRoom, actionEdit, actionDelete, actionKick}},
+		{chat.Role_OWNER, []action{actionPost, actionInvite, actionCreateRoom, actionEdit, actionDelete, actionKick, actionManageRoles, actionAdminister}},
+	}
+	actions := []action{actionPost, actionEdit, actionDelete, actionInvite, actionKick, actionCreateRoom, actionManageRoles, actionAdminister}
+
+	for _, tt := range tests {
+		want := make(map[action]bool)
+		for _, act := range tt.allowed {
+			want[act] = true
+		}
+		for _, act := range actions {
+			if got := allowed(tt.role, act); got != want[act] {
+				t.Errorf("allowed(%v, %v) = %v, want %v", tt.role, act, got, want[act])
+			}
+		}
+	}
+}
+
+func TestAssignRole(t *testing.T) {
+
+	s := newServer(log.NewNopLogger())
+	if err := s.useIncomingWebhooks([]IncomingWebhook{{Room: lobbyRoom, Name: "ci", Token: "secret"}}); err != nil {
+		t.Fatal(err)
+	}
+	if role := s.assignRole(context.Background(), "alice"); role != chat.Role_OWNER {
+		t.Fatalf("first user role = %v, want %v", role, chat.Role_OWNER)
+	}
+	if role := s.assignRole(context.Background(), "bob"); role != defaultRole {
+		t.Fatalf("second user role = %v, want %v", role, defaultRole)
+	}
+	if role := s.assignRole(context.Background(), "alice"); role != chat.Role_OWNER {
+		t.Fatalf("returning user role = %v, want %v", role, chat.Role_OWNER)
+	}
+}
+
+func TestGrantAndRevokeRole(t *testing.T) {
+
+	tests := []struct {
+		name     string
+		granter  chat.Role
+		token    string
+		room     string
+		username string
+		role     chat.Role
+		code     codes.Code
+	}{
+		{"owner grants moderator", chat.Role_OWNER, "tkn-granter", "", "bob", chat.Role_MODERATOR, codes.OK},
+		{"owner grants owner", chat.Role_OWNER, "tkn-granter", "", "bob", chat.Role_OWNER, codes.OK},
+		{"moderator cannot grant", chat.Role_MODERATOR, "tkn-granter", "", "bob", chat.Role_MODERATOR, codes.PermissionDenied},
+		{"member cannot grant", chat.Role_MEMBER, "tkn-granter", "", "bob", chat.Role_MODERATOR, codes.PermissionDenied},
+		{"guest cannot grant", chat.Role_GUEST, "tkn-granter", "", "bob", chat.Role_MEMBER, codes.PermissionDenied},
+		{"unknown token", chat.Role_OWNER, "tkn-unknown", "", "bob", chat.Role_MODERATOR, codes.Unauthenticated},
+		{"cannot change own role", chat.Role_OWNER, "tkn-granter", "", "alice", chat.Role_GUEST, codes.FailedPrecondition},
+		{"unknown role", chat.Role_OWNER, "tkn-granter", "", "bob", chat.Role(42), codes.InvalidArgument},
+		{"room owner grants moderator in the room", chat.Role_MEMBER, "tkn-granter", "ops", "bob", chat.Role_MODERATOR, codes.OK},
+		{"room owner cannot grant in another room", chat.Role_MEMBER, "tkn-granter", "lobby", "bob", chat.Role_MODERATOR, codes.PermissionDenied},
+		{"room owner cannot demote the chat owner", chat.Role_MEMBER, "tkn-granter", "ops", "carol", chat.Role_GUEST, codes.PermissionDenied},
+		{"unknown room", chat.Role_OWNER, "tkn-granter", "unknown", "bob", chat.Role_MODERATOR, codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newServer(log.NewNopLogger())
+			s.addClientName("alice", "tkn-granter")
+			s.setRole("", "carol", chat.Role_OWNER)
+			s.setRole("", "alice", chat.Role_OWNER)
+			if _, err := s.CreateRoom(context.Background(), &chat.CreateRoomRequest{Token: "tkn-granter", Name: "ops"}); err != nil {
+				t.Fatalf("CreateRoom() error = %v", err)
+			}
+			s.setRole("", "alice", tt.granter)
+			s.setRole("", "bob", defaultRole)
+
+			_, err := s.GrantRole(context.Background(), &chat.GrantRoleRequest{
+				Token:    tt.token,
+				Room:     tt.room,
+				Username: tt.username,
+				Role:     tt.role,
+			})
+			if code := status.Code(err); code != tt.code {
+				t.Fatalf("GrantRole() code = %v, want %v", code, tt.code)
+			}
+			if tt.code != codes.OK {
+				return
+			}
+			if role := s.getRole(tt.room, tt.username); role != tt.role {
+				t.Fatalf("role after grant = %v, want %v", role, tt.role)
+			}
+			if tt.room != "" {
+				if role := s.getRole("", tt.username); role != defaultRole {
+					t.Fatalf("chat-wide role after a grant in %v = %v, want %v", tt.room, role, defaultRole)
+				}
+			}
+
+			_, err = s.RevokeRole(context.Background(), &chat.RevokeRoleRequest{
+				Token:    tt.token,
+				Room:     tt.room,
+				Username: tt.username,
+			})
+			if err != nil {
+				t.Fatalf("RevokeRole() error = %v", err)
+			}
+			if role := s.getRole(tt.room, tt.username); role != defaultRole {
+				t.Fatalf("role after revoke = %v, want %v", role, defaultRole)
+			}
+		})
+	}
+}
@@ -0,0 +1,301 @@
+package chatserver
+
+import (
+	"context"
+	"sort"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lobbyRoom is the public room that receives every message sent without a room
+const lobbyRoom = "lobby"
+
+type room struct {
+	name       string
+	visibility chat.Visibility
+	members    map[string]bool
+	invited    map[string]bool
+	// kicked are the users kicked out of the room, until they are invited back
+	kicked map[string]bool
+	// history holds the last historySize messages of the room, oldest first
+	history []*chat.StreamResponse
+}
+
+func newRoom(name string, visibility chat.Visibility) *room {
+	return &room{
+		name:       name,
+		visibility: visibility,
+		members:    make(map[string]bool),
+		invited:    make(map[string]bool),
+		kicked:     make(map[string]bool),
+	}
+}
+
+// canRead reports whether the user may see the room and its events,
+// public rooms are open to everyone but the kicked users while the others are members only
+func (r *room) canRead(username string) bool {
+	return (r.visibility == chat.Visibility_PUBLIC && !r.kicked[username]) || r.members[username]
+}
+
+// canList reports whether the room shows up for the user in ListRooms
+func (r *room) canList(username string) bool {
+	return r.visibility != chat.Visibility_PRIVATE || r.members[username]
+}
+
+// errRoomNotFound is returned for missing rooms as well as private rooms the
+// user is not a member of, so that their existence is not leaked
+var errRoomNotFound = status.Error(codes.NotFound, "room not found")
+
+func (s *server) canReadRoom(username, name string) bool {
+
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	r, ok := s.Rooms[name]
+	return ok && r.canRead(username)
+}
+
+// isPublicRoom reports whether the room is open to everyone
+func (s *server) isPublicRoom(name string) bool {
+
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	r, ok := s.Rooms[name]
+	return ok && r.visibility == chat.Visibility_PUBLIC
+}
+
+// canReceive reports whether the event may be fanned out to the stream of the given token
+func (s *server) canReceive(tkn string, res *chat.StreamResponse) bool {
+
+	// Invocations go to their bot only
+	if inv := res.GetCommandInvocation(); inv != nil {
+		name, _ := s.getClientName(tkn)
+		return name == inv.Bot
+	}
+	switch ev := res.Event.(type) {
+	case *chat.StreamResponse_StateChange:
+		return false
+	case *chat.StreamResponse_MessageEdit:
+		name, _ := s.getClientName(tkn)
+		return s.canReadRoom(name, ev.MessageEdit.Room)
+	case *chat.StreamResponse_MessageDelete:
+		name, _ := s.getClientName(tkn)
+		return s.canReadRoom(name, ev.MessageDelete.Room)
+	case *chat.StreamResponse_ClientKick:
+		// The kicked user is told as well
+		name, _ := s.getClientName(tkn)
+		return name == ev.ClientKick.Name || s.canReadRoom(name, ev.ClientKick.Room)
+	}
+	msg := res.GetClientMessage()
+	if msg == nil || (msg.Room == "" && msg.To == "") {
+		return true
+	}
+	name, ok := s.getClientName(tkn)
+	if !ok {
+		return false
+	}
+	// Direct messages go to their recipient and back to the other streams of the sender
+	if msg.To != "" {
+		return name == msg.To || name == msg.Name
+	}
+	return s.canReadRoom(name, msg.Room)
+}
+
+func (s *server) CreateRoom(ctx context.Context, req *chat.CreateRoomRequest) (*chat.CreateRoomResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new create room request", "room", req.Name, "visibility", req.Visibility)
+	name, err := s.authorize(req.Token, actionCreateRoom)
+	if err != nil {
+		return nil, err
+	}
+	if req.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "room name is required")
+	}
+	if _, ok := chat.Visibility_name[int32(req.Visibility)]; !ok {
+		return nil, status.Error(codes.InvalidArgument, "unknown visibility")
+	}
+
+	s.roomMutex.Lock()
+	if _, ok := s.Rooms[req.Name]; ok {
+		s.roomMutex.Unlock()
+		return nil, status.Error(codes.AlreadyExists, "room already exists")
+	}
+	r := newRoom(req.Name, req.Visibility)
+	members := []string{name}
+	// The operator set the incoming webhooks of the room up, they are members from the start and stay out
+	// once kicked
+	for _, h := range s.incoming {
+		if h.Room == req.Name {
+			members = append(members, h.Name)
+		}
+	}
+	for _, member := range members {
+		r.members[member] = true
+	}
+	s.Rooms[req.Name] = r
+	s.roomMutex.Unlock()
+
+	s.setRole(req.Name, name, chat.Role_OWNER)
+	changes := []*chat.StateChange{s.roomChange(req.Name)}
+	for _, member := range members {
+		changes = append(changes, s.membershipChange(req.Name, member))
+	}
+	s.share(ctx, append(changes, s.roleChange(req.Name, name))...)
+	return &chat.CreateRoomResponse{}, nil
+}
+
+func (s *server) JoinRoom(ctx context.Context, req *chat.JoinRoomRequest) (*chat.JoinRoomResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new join room request", "room", req.Room)
+	name, ok := s.getClientName(req.Token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	s.roomMutex.Lock()
+	r, ok := s.Rooms[req.Room]
+	if !ok || !r.canList(name) {
+		s.roomMutex.Unlock()
+		return nil, errRoomNotFound
+	}
+	if r.kicked[name] {
+		s.roomMutex.Unlock()
+		return nil, status.Error(codes.PermissionDenied, "kicked out of the room, an invite is required")
+	}
+	if r.visibility != chat.Visibility_PUBLIC && !r.members[name] {
+		s.roomMutex.Unlock()
+		return nil, status.Error(codes.PermissionDenied, "room requires an invite")
+	}
+	r.members[name] = true
+	s.roomMutex.Unlock()
+
+	s.share(ctx, s.membershipChange(req.Room, name))
+	return &chat.JoinRoomResponse{}, nil
+}
+
+func (s *server) ListRooms(ctx context.Context, req *chat.ListRoomsRequest) (*chat.ListRoomsResponse, error) {
+
+	name, ok := s.getClientName(req.Token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	res := &chat.ListRoomsResponse{}
+	for _, r := range s.Rooms {
+		if r.canList(name) {
+			res.Rooms = append(res.Rooms, &chat.Room{Name: r.name, Visibility: r.visibility})
+		}
+	}
+	sort.Slice(res.Rooms, func(i, j int) bool { return res.Rooms[i].Name < res.Rooms[j].Name })
+	return res, nil
+}
+
+func (s *server) Invite(ctx context.Context, req *chat.InviteRequest) (*chat.InviteResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new invite request", "room", req.Room, "target", req.Username)
+	name, err := s.authorizeIn(req.Token, req.Room, actionInvite)
+	if err != nil {
+		return nil, err
+	}
+	// Inviting back a kicked user takes the role that kicked them
+	canKick := allowed(s.getRole(req.Room, name), actionKick)
+
+	s.roomMutex.Lock()
+	r, ok := s.Rooms[req.Room]
+	if !ok || !r.canList(name) {
+		s.roomMutex.Unlock()
+		return nil, errRoomNotFound
+	}
+	if !r.members[name] {
+		s.roomMutex.Unlock()
+		return nil, status.Error(codes.PermissionDenied, "only members can invite to the room")
+	}
+	if r.kicked[req.Username] {
+		if !canKick {
+			s.roomMutex.Unlock()
+			return nil, status.Error(codes.PermissionDenied, "the user was kicked out of the room")
+		}
+		delete(r.kicked, req.Username)
+	}
+	if !r.members[req.Username] {
+		r.invited[req.Username] = true
+	}
+	s.roomMutex.Unlock()
+
+	s.share(ctx, s.membershipChange(req.Room, req.Username))
+	return &chat.InviteResponse{}, nil
+}
+
+func (s *server) AcceptInvite(ctx context.Context, req *chat.AcceptInviteRequest) (*chat.AcceptInviteResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new accept invite request", "room", req.Room)
+	name, ok := s.getClientName(req.Token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	s.roomMutex.Lock()
+	r, ok := s.Rooms[req.Room]
+	if !ok || !r.invited[name] {
+		s.roomMutex.Unlock()
+		return nil, status.Error(codes.NotFound, "no pending invite for the room")
+	}
+	delete(r.invited, name)
+	r.members[name] = true
+	s.roomMutex.Unlock()
+
+	s.share(ctx, s.membershipChange(req.Room, name))
+	return &chat.AcceptInviteResponse{}, nil
+}
+
+func (s *server) Kick(ctx context.Context, req *chat.KickRequest) (*chat.KickResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new kick request", "room", req.Room, "target", req.Username)
+	name, err := s.authorizeIn(req.Token, req.Room, actionKick)
+	if err != nil {
+		return nil, err
+	}
+	if name == req.Username {
+		return nil, status.Error(codes.FailedPrecondition, "cannot kick yourself")
+	}
+	if err := s.outranks(req.Room, name, req.Username); err != nil {
+		return nil, err
+	}
+
+	if !s.canReadRoom(name, req.Room) {
+		return nil, errRoomNotFound
+	}
+	if req.Room == lobbyRoom {
+		return nil, status.Error(codes.FailedPrecondition, "nobody can be kicked out of the lobby")
+	}
+	s.kickOut(ctx, req.Room, req.Username, name)
+	return &chat.KickResponse{}, nil
+}
+
+// kickOut keeps the user out of the room until invited back and tells the room, by is who kicked them
+func (s *server) kickOut(ctx context.Context, room, username, by string) {
+
+	s.roomMutex.Lock()
+	r, ok := s.Rooms[room]
+	if !ok {
+		s.roomMutex.Unlock()
+		return
+	}
+	delete(r.members, username)
+	delete(r.invited, username)
+	r.kicked[username] = true
+	s.roomMutex.Unlock()
+
+	s.share(ctx, s.membershipChange(room, username))
+	s.publish(ctx, &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_ClientKick{
+			ClientKick: &chat.StreamResponse_Kick{Room: room, Name: username, By: by},
+		},
+	})
+}
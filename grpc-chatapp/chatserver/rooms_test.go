@@ -0,0 +1,232 @@
+package chatserver
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// newRoomsServer returns a server where alice owns one room of every visibility
+// and bob is a member that has not joined any of them
+func newRoomsServer(t *testing.T) *server {
+
+	s := newServer(log.NewNopLogger())
+	s.addClientName("alice", "tkn-alice")
+	s.addClientName("bob", "tkn-bob")
+	s.setRole("", "alice", chat.Role_OWNER)
+	s.setRole("", "bob", chat.Role_MEMBER)
+
+	for name, visibility := range map[string]chat.Visibility{
+		"public":  chat.Visibility_PUBLIC,
+		"private": chat.Visibility_PRIVATE,
+		"invite":  chat.Visibility_INVITE_ONLY,
+	} {
+		_, err := s.CreateRoom(context.Background(), &chat.CreateRoomRequest{
+			Token:      "tkn-alice",
+			Name:       name,
+			Visibility: visibility,
+		})
+		if err != nil {
+			t.Fatalf("CreateRoom(%v) error = %v", name, err)
+		}
+	}
+	return s
+}
+
+func roomNames(t *testing.T, s *server, tkn string) []string {
+
+	res, err := s.ListRooms(context.Background(), &chat.ListRoomsRequest{Token: tkn})
+	if err != nil {
+		t.Fatalf("ListRooms() error = %v", err)
+	}
+	var names []string
+	for _, r := range res.Rooms {
+		names = append(names, r.Name)
+	}
+	return names
+}
+
+func TestListRoomsHidesPrivateRooms(t *testing.T) {
+
+	s := newRoomsServer(t)
+	tests := []struct {
+		token string
+		want  []string
+	}{
+		{"tkn-alice", []string{"invite", "lobby", "private", "public"}},
+		{"tkn-bob", []string{"invite", "lobby", "public"}},
+	}
+
+	for _, tt := range tests {
+		got := roomNames(t, s, tt.token)
+		if len(got) != len(tt.want) {
+			t.Fatalf("ListRooms(%v) = %v, want %v", tt.token, got, tt.want)
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Fatalf("ListRooms(%v) = %v, want %v", tt.token, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestJoinRoom(t *testing.T) {
+
+	tests := []struct {
+		room string
+		code codes.Code
+	}{
+		{"public", codes.OK},
+		{"invite", codes.PermissionDenied},
+		{"private", codes.NotFound},
+		{"missing", codes.NotFound},
+	}
+
+	for _, tt := range tests {
+		s := newRoomsServer(t)
+		_, err := s.JoinRoom(context.Background(), &chat.JoinRoomRequest{Token: "tkn-bob", Room: tt.room})
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("JoinRoom(%v) code = %v, want %v", tt.room, code, tt.code)
+		}
+	}
+}
+
+func TestInviteAndAccept(t *testing.T) {
+
+	for _, name := range []string{"private", "invite"} {
+		s := newRoomsServer(t)
+
+		_, err := s.AcceptInvite(context.Background(), &chat.AcceptInviteRequest{Token: "tkn-bob", Room: name})
+		if code := status.Code(err); code != codes.NotFound {
+			t.Fatalf("AcceptInvite(%v) without invite code = %v, want %v", name, code, codes.NotFound)
+		}
+		_, err = s.Invite(context.Background(), &chat.InviteRequest{Token: "tkn-bob", Room: "public", Username: "alice"})
+		if code := status.Code(err); code != codes.PermissionDenied {
+			t.Fatalf("Invite() from non-member code = %v, want %v", code, codes.PermissionDenied)
+		}
+
+		_, err = s.Invite(context.Background(), &chat.InviteRequest{Token: "tkn-alice", Room: name, Username: "bob"})
+		if err != nil {
+			t.Fatalf("Invite(%v) error = %v", name, err)
+		}
+		_, err = s.AcceptInvite(context.Background(), &chat.AcceptInviteRequest{Token: "tkn-bob", Room: name})
+		if err != nil {
+			t.Fatalf("AcceptInvite(%v) error = %v", name, err)
+		}
+		if !s.canReadRoom("bob", name) {
+			t.Fatalf("bob cannot read %v after accepting the invite", name)
+		}
+	}
+}
+
+func TestBroadcastSkipsNonMembers(t *testing.T) {
+
+	s := newRoomsServer(t)
+	alice, _ := s.OpenStream("tkn-alice")
+	bob, _ := s.OpenStream("tkn-bob")
+	go s.broadcast()
+	defer close(s.CommonChannel)
+
+	for _, room := range []string{"private", "public"} {
+		s.publish(context.Background(), &chat.StreamResponse{
+			Event: &chat.StreamResponse_ClientMessage{
+				ClientMessage: &chat.StreamResponse_Message{Name: "alice", Message: "hi", Room: room},
+			},
+		})
+	}
+
+	ctx := context.Background()
+	for _, want := range []string{"private", "public"} {
+		ev, _ := alice.Pop(ctx)
+		if got := ev.res.GetClientMessage().Room; got != want {
+			t.Fatalf("alice received a message for %v, want %v", got, want)
+		}
+	}
+	ev, _ := bob.Pop(ctx)
+	if got := ev.res.GetClientMessage().Room; got != "public" {
+		t.Fatalf("bob received a message for %v, want public", got)
+	}
+}
+
+func TestKick(t *testing.T) {
+
+	ctx := context.Background()
+	s := newRoomsServer(t)
+	s.addClientName("carol", "tkn-carol")
+	s.setRole("", "carol", chat.Role_MEMBER)
+	for _, tkn := range []string{"tkn-bob", "tkn-carol"} {
+		if _, err := s.JoinRoom(ctx, &chat.JoinRoomRequest{Token: tkn, Room: "public"}); err != nil {
+			t.Fatalf("JoinRoom() error = %v", err)
+		}
+	}
+	// bob moderates the public room only
+	s.setRole("public", "bob", chat.Role_MODERATOR)
+
+	tests := []struct {
+		name     string
+		token    string
+		room     string
+		username string
+		code     codes.Code
+	}{
+		{"member", "tkn-carol", "public", "bob", codes.PermissionDenied},
+		{"moderator of another room", "tkn-bob", "invite", "carol", codes.PermissionDenied},
+		{"moderator kicks the owner", "tkn-bob", "public", "alice", codes.PermissionDenied},
+		{"yourself", "tkn-bob", "public", "bob", codes.FailedPrecondition},
+		{"lobby", "tkn-alice", "lobby", "carol", codes.FailedPrecondition},
+		{"private room of another user", "tkn-bob", "private", "alice", codes.PermissionDenied},
+		{"moderator", "tkn-bob", "public", "carol", codes.OK},
+	}
+	for _, tt := range tests {
+		_, err := s.Kick(ctx, &chat.KickRequest{Token: tt.token, Room: tt.room, Username: tt.username})
+		if code := status.Code(err); code != tt.code {
+			t.Errorf("%v: Kick() code = %v, want %v", tt.name, code, tt.code)
+		}
+	}
+
+	if s.canReadRoom("carol", "public") {
+		t.Fatal("carol can read the public room after being kicked out")
+	}
+	if err := s.post(ctx, "carol", "public", "", "back"); status.Code(err) != codes.NotFound {
+		t.Fatalf("post() after the kick error = %v, want %v", err, codes.NotFound)
+	}
+	if _, err := s.JoinRoom(ctx, &chat.JoinRoomRequest{Token: "tkn-carol", Room: "public"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("JoinRoom() after the kick error = %v, want %v", err, codes.PermissionDenied)
+	}
+
+	// A member cannot undo the kick, a moderator can
+	s.setRole("public", "bob", chat.Role_MEMBER)
+	if _, err := s.Invite(ctx, &chat.InviteRequest{Token: "tkn-bob", Room: "public", Username: "carol"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Invite() of a kicked user by a member error = %v, want %v", err, codes.PermissionDenied)
+	}
+	if _, err := s.Invite(ctx, &chat.InviteRequest{Token: "tkn-alice", Room: "public", Username: "carol"}); err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	if _, err := s.JoinRoom(ctx, &chat.JoinRoomRequest{Token: "tkn-carol", Room: "public"}); err != nil {
+		t.Fatalf("JoinRoom() after the invite error = %v", err)
+	}
+}
+
+func TestPostTakesTheRoomRole(t *testing.T) {
+
+	ctx := context.Background()
+	s := newRoomsServer(t)
+	s.setRole("public", "bob", chat.Role_GUEST)
+
+	if err := s.post(ctx, "bob", "public", "", "hi"); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("post() as a guest of the room error = %v, want %v", err, codes.PermissionDenied)
+	}
+	if err := s.post(ctx, "bob", "", "", "hi"); err != nil {
+		t.Fatalf("post() to the lobby error = %v", err)
+	}
+	if _, err := s.Invite(ctx, &chat.InviteRequest{Token: "tkn-bob", Room: "public", Username: "carol"}); status.Code(err) != codes.PermissionDenied {
+		t.Fatalf("Invite() as a guest of the room error = %v, want %v", err, codes.PermissionDenied)
+	}
+	if role := s.getRole("invite", "alice"); role != chat.Role_OWNER {
+		t.Fatalf("role of the creator of the room = %v, want %v", role, chat.Role_OWNER)
+	}
+}
@@ -0,0 +1,751 @@
+package chatserver
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/logging"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/queue"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tokenHeader         = "x-chat-token"
+	tokenSize           = 4
+	responseChannelSize = 20
+	streamChannelSize   = 100
+	chatServiceName     = "chat.Chat"
+)
+
+var tracer = otel.Tracer("github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver")
+
+// event is a response on its way to the clients along with the trace context it belongs to
+type event struct {
+	ctx context.Context
+	res *chat.StreamResponse
+}
+
+type server struct {
+	CommonChannel chan event
+	ClientName    map[string]string
+	// lastSeen is when the sessions were last used, by token, guarded by nameMutex
+	lastSeen                          map[string]time.Time
+	ClientStream                      map[string]*queue.Queue[event]
+	ClientRole                        map[roleKey]chat.Role
+	Rooms                             map[string]*room
+	nameMutex, streamMutex, roleMutex sync.RWMutex
+	roomMutex                         sync.RWMutex
+	logger, broadcastLogger           log.Logger
+	metrics                           *metrics
+	// sessionTimeout is how long a session lasts without a stream open nor a call made
+	sessionTimeout time.Duration
+	// broker carries the published events to the common channel of every instance
+	broker Broker
+	// shared is set when the broker connects several instances
+	shared bool
+	// webhooks sends the events to the outgoing webhooks, nil when there are none
+	webhooks *webhook.Dispatcher
+	// federation relays the messages of the shared rooms to the peers, nil when not federated
+	federation *federation
+	// incoming are the incoming webhooks, set up before serving
+	incoming []IncomingWebhook
+	// reserved are the names nobody can log in under, guarded by roleMutex
+	reserved map[string]bool
+	// bots are the names of the bots by API key, set up before serving
+	bots map[string]string
+	// limiter bounds how fast every identity posts, nil when there is no limit
+	limiter *limiter
+	// commands are the slash commands registered by the bots, answers until when the bots may post to the
+	// rooms they were invoked in, both guarded by commandMutex
+	commands     map[string]command
+	answers      map[roleKey]time.Time
+	commandMutex sync.RWMutex
+
+	// draining is set when the shutdown starts and closed once the common channel is closed,
+	// both are guarded by closeMutex
+	draining, closed bool
+	closeMutex       sync.RWMutex
+	// streamsClosed is set, under streamMutex, once the client queues are closed
+	streamsClosed bool
+	broadcastDone chan struct{}
+	streams       sync.WaitGroup
+}
+
+func newServer(logger log.Logger) *server {
+	s := &server{
+		CommonChannel:   make(chan event, responseChannelSize),
+		ClientName:      make(map[string]string),
+		lastSeen:        make(map[string]time.Time),
+		ClientStream:    make(map[string]*queue.Queue[event]),
+		ClientRole:      make(map[roleKey]chat.Role),
+		reserved:        make(map[string]bool),
+		commands:        make(map[string]command),
+		answers:         make(map[roleKey]time.Time),
+		Rooms:           map[string]*room{lobbyRoom: newRoom(lobbyRoom, chat.Visibility_PUBLIC)},
+		logger:          logger,
+		broadcastLogger: logger,
+		broadcastDone:   make(chan struct{}),
+		sessionTimeout:  DefaultSessionTimeout,
+	}
+	s.metrics = newMetrics(s)
+	s.useBroker(NewLocalBroker())
+	return s
+}
+
+// useBroker subscribes the server to the events published through b
+func (s *server) useBroker(b Broker) error {
+	s.broker = b
+	return b.Subscribe(s.deliver)
+}
+
+// log returns the request scoped logger of ctx
+func (s *server) log(ctx context.Context) log.Logger {
+	return logging.FromContext(ctx, s.logger)
+}
+
+// username resolves the user making the request for the request scoped logger
+func (s *server) username(ctx context.Context, req interface{}) string {
+
+	var tkn string
+	switch r := req.(type) {
+	case *chat.LoginRequest:
+		return r.Username
+	case interface{ GetToken() string }:
+		tkn = r.GetToken()
+	default:
+		tkn, _ = s.extractToken(ctx)
+	}
+	name, _ := s.getClientName(tkn)
+	return name
+}
+
+func (s *server) generateToken() (string, error) {
+
+	level.Debug(s.logger).Log("message", "started generating token")
+	txt := make([]byte, tokenSize)
+	_, err := rand.Read(txt)
+	if err != nil {
+		level.Error(s.logger).Log("error", "error while generating the token")
+		return "", err
+	}
+	level.Debug(s.logger).Log("message", "finished generating token")
+	return fmt.Sprintf("%x", txt), nil
+}
+
+func (s *server) addClientName(username string, tkn string) {
+
+	s.nameMutex.Lock()
+	defer s.nameMutex.Unlock()
+	level.Debug(s.logger).Log("message", "adding the client name", "client", username, "token", tkn)
+	s.ClientName[tkn] = username
+	s.lastSeen[tkn] = time.Now()
+
+}
+
+// claimClientName adds the token of the user unless the name is used by another login, the previous token
+// of the user is dropped
+func (s *server) claimClientName(username, tkn, previous string) bool {
+
+	s.nameMutex.Lock()
+	defer s.nameMutex.Unlock()
+	if s.ClientName[previous] == username {
+		delete(s.ClientName, previous)
+		delete(s.lastSeen, previous)
+	}
+	for _, name := range s.ClientName {
+		if name == username {
+			return false
+		}
+	}
+	level.Debug(s.logger).Log("message", "adding the client name", "client", username, "token", tkn)
+	s.ClientName[tkn] = username
+	s.lastSeen[tkn] = time.Now()
+	return true
+}
+
+func (s *server) getClientName(tkn string) (string, bool) {
+
+	s.nameMutex.RLock()
+	defer s.nameMutex.RUnlock()
+	level.Debug(s.logger).Log("message", "getting the client name", "token", tkn)
+	name, ok := s.ClientName[tkn]
+	if !ok {
+		// The API keys of the bots are tokens that never expire
+		name, ok = s.bots[tkn]
+	}
+	return name, ok
+}
+
+func (s *server) removeClientName(tkn string) string {
+
+	s.nameMutex.Lock()
+	defer s.nameMutex.Unlock()
+	level.Debug(s.logger).Log("message", "removing the client token", "token", tkn)
+	username := s.ClientName[tkn]
+	delete(s.ClientName, tkn)
+	delete(s.lastSeen, tkn)
+
+	return username
+}
+
+func (s *server) Login(ctx context.Context, req *chat.LoginRequest) (*chat.LoginResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new client login request", "req", req)
+	if s.isDraining() {
+		s.metrics.loginFailures.Inc()
+		return nil, errShuttingDown
+	}
+	if req.Username == "" {
+		s.metrics.loginFailures.Inc()
+		return nil, status.Error(codes.InvalidArgument, "username is required")
+	}
+	// The separator is kept for the users of the federated deployments
+	if strings.Contains(req.Username, domainSeparator) {
+		s.metrics.loginFailures.Inc()
+		return nil, status.Error(codes.InvalidArgument, "username cannot contain "+domainSeparator)
+	}
+	// The roles and the rooms belong to the name, nobody can take it over while it is in use
+	if s.isReserved(req.Username) {
+		s.metrics.loginFailures.Inc()
+		return nil, errNameTaken
+	}
+	if s.isRemoteUser(ctx, req.Username) {
+		s.metrics.loginFailures.Inc()
+		return nil, errNameTaken
+	}
+	tkn, err := s.generateToken()
+	if err != nil {
+		level.Error(s.log(ctx)).Log("error", "login failed for the request", "req", req)
+		s.metrics.loginFailures.Inc()
+		return nil, status.Error(codes.Internal, "failed to generate the token")
+	}
+	// Add the token in the client name
+	if !s.claimClientName(req.Username, tkn, req.Token) {
+		s.metrics.loginFailures.Inc()
+		return nil, errNameTaken
+	}
+	s.assignRole(ctx, req.Username)
+	s.announceUsers(ctx)
+	// Send in a notif that broadcast is successful
+	level.Info(s.log(ctx)).Log("message", "login is successful", "req", req)
+	s.publish(ctx, &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_ClientLogin{
+			ClientLogin: &chat.StreamResponse_Login{
+				Name: req.Username,
+			},
+		},
+	})
+
+	// Return a response
+	return &chat.LoginResponse{
+		Token: tkn,
+	}, nil
+
+}
+
+func (s *server) Logout(ctx context.Context, req *chat.LogoutRequest) (*chat.LogoutResponse, error) {
+
+	level.Info(s.log(ctx)).Log("message", "new client logout request", "req", req)
+	tkn := req.Token
+	// Remove the name from the Client Name map
+	username := s.removeClientName(tkn)
+	if username == "" {
+		// Already logged out, e.g. by the page and then by the WebSocket bridge when it closed
+		return &chat.LogoutResponse{}, nil
+	}
+	s.endSession(ctx, username)
+	level.Info(s.log(ctx)).Log("message", "logout is successful", "req", req)
+	// Return a response
+	return &chat.LogoutResponse{}, nil
+}
+
+// endSession releases the name and tells the chat that the user left, once the token of the session is removed
+func (s *server) endSession(ctx context.Context, username string) {
+
+	if username != "" {
+		s.releaseName(ctx, username)
+	}
+	s.announceUsers(ctx)
+	// Send in a broadcast that the client has been removed
+	s.publish(ctx, &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_ClientLogout{
+			ClientLogout: &chat.StreamResponse_Logout{
+				Name: username,
+			},
+		},
+	})
+}
+
+// publish hands the response over to the broker, which delivers it to every instance
+func (s *server) publish(ctx context.Context, res *chat.StreamResponse) {
+
+	if err := s.broker.Publish(ctx, res); err != nil {
+		level.Error(s.log(ctx)).Log("error", "failed to publish the event, dropping it", "err", err)
+		s.metrics.droppedEvents.WithLabelValues(dropPublishFailed).Inc()
+		return
+	}
+	// The webhooks are called by the instance that took the event in, so that the instances sharing a
+	// broker do not call them once each
+	if s.webhooks != nil {
+		s.webhooks.Dispatch(res, s.isPublicRoom(res.GetClientMessage().GetRoom()))
+	}
+}
+
+// deliver pushes the response to the common channel, the span records how long it waited for room in it.
+// Responses delivered once the channel is closed on shutdown are dropped.
+func (s *server) deliver(ctx context.Context, res *chat.StreamResponse) {
+
+	// The changes of the state are applied and stop there, the clients never see them
+	if change := res.GetStateChange(); change != nil {
+		s.apply(change)
+		return
+	}
+	if inv := res.GetCommandInvocation(); inv != nil {
+		s.answer(inv)
+	}
+	s.remember(res)
+	if s.federation != nil {
+		s.federation.record(res)
+	}
+	s.closeMutex.RLock()
+	defer s.closeMutex.RUnlock()
+	if s.closed {
+		level.Warn(s.broadcastLogger).Log("message", "common channel is closed, dropping the event")
+		s.metrics.droppedEvents.WithLabelValues(dropClosed).Inc()
+		return
+	}
+
+	_, span := tracer.Start(ctx, "publish")
+	s.CommonChannel <- event{ctx: ctx, res: res}
+	span.End()
+}
+
+func (s *server) ListUsers(ctx context.Context, req *chat.ListUsersRequest) (*chat.ListUsersResponse, error) {
+
+	if _, ok := s.getClientName(req.Token); !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+
+	users := s.localUsers()
+	// The users of the other instances are left out rather than failing the call when the broker is down
+	remote, err := s.broker.RemoteUsers(ctx)
+	if err != nil {
+		level.Warn(s.log(ctx)).Log("message", "failed to list the users of the other instances", "err", err)
+	}
+	seen := make(map[string]bool)
+	res := &chat.ListUsersResponse{}
+	for _, name := range append(users, remote...) {
+		// The bots connected to the other instances are announced along with the users
+		if !seen[name] && !s.isBot(name) {
+			seen[name] = true
+			res.Usernames = append(res.Usernames, name)
+		}
+	}
+	sort.Strings(res.Usernames)
+	return res, nil
+}
+
+var (
+	// errUserNotFound is returned for the direct messages to users who are not logged in
+	errUserNotFound = status.Error(codes.NotFound, "user not found")
+	// errNameTaken is returned by Login for the names in use and the ones of the bots and the webhooks
+	errNameTaken = status.Error(codes.AlreadyExists, "username is taken")
+)
+
+// isUser reports whether the user is logged in to this instance or, as far as the broker knows, another one
+func (s *server) isUser(ctx context.Context, name string) bool {
+
+	s.nameMutex.RLock()
+	for _, n := range s.ClientName {
+		if n == name {
+			s.nameMutex.RUnlock()
+			return true
+		}
+	}
+	s.nameMutex.RUnlock()
+	return !s.isBot(name) && s.isRemoteUser(ctx, name)
+}
+
+// isRemoteUser reports whether the user, or the bot, is logged in to another instance as far as the broker
+// knows
+func (s *server) isRemoteUser(ctx context.Context, name string) bool {
+
+	remote, err := s.broker.RemoteUsers(ctx)
+	if err != nil {
+		level.Warn(s.log(ctx)).Log("message", "failed to list the users of the other instances", "err", err)
+	}
+	for _, n := range remote {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// localUsers returns the names logged in to this instance, once each
+func (s *server) localUsers() []string {
+
+	s.nameMutex.RLock()
+	defer s.nameMutex.RUnlock()
+	seen := make(map[string]bool)
+	var users []string
+	for _, name := range s.ClientName {
+		if !seen[name] {
+			seen[name] = true
+			users = append(users, name)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// announceUsers tells the other instances who is logged in here, along with the bots connected here so that
+// they know where to hand the invocations over to
+func (s *server) announceUsers(ctx context.Context) {
+
+	users := s.localUsers()
+	s.streamMutex.RLock()
+	for key, name := range s.bots {
+		if _, ok := s.ClientStream[key]; ok {
+			users = append(users, name)
+		}
+	}
+	s.streamMutex.RUnlock()
+	if err := s.broker.SetLocalUsers(ctx, users); err != nil {
+		level.Warn(s.log(ctx)).Log("message", "failed to announce the users to the other instances", "err", err)
+	}
+}
+
+func (s *server) broadcast() {
+
+	defer close(s.broadcastDone)
+	// Once the common channel is closed every client queue is closed as well,
+	// so that the streams end after sending what is left in them
+	defer s.closeStreams()
+
+	for ev := range s.CommonChannel {
+
+		_, span := tracer.Start(ev.ctx, "broadcast")
+		recipients := 0
+		s.streamMutex.RLock()
+		for tkn, stream := range s.ClientStream {
+			// Skip the clients that are not allowed to see the event
+			if !s.canReceive(tkn, ev.res) {
+				continue
+			}
+			// Push in common message into specific client queue, dropping it
+			// instead of stalling every other client when the queue is full
+			if err := stream.TryPush(ev); err != nil {
+				level.Warn(s.broadcastLogger).Log("message", "client queue is full, dropping the event", "token", tkn)
+				s.metrics.droppedEvents.WithLabelValues(dropQueueFull).Inc()
+				continue
+			}
+			recipients++
+		}
+		s.streamMutex.RUnlock()
+		span.SetAttributes(attribute.Int("chat.recipients", recipients))
+		span.End()
+	}
+}
+
+func (s *server) OpenStream(tkn string) (*queue.Queue[event], bool) {
+	stream := queue.New[event](streamChannelSize, queue.DropNewest)
+	s.streamMutex.Lock()
+	defer s.streamMutex.Unlock()
+	if s.streamsClosed {
+		return nil, false
+	}
+	level.Debug(s.logger).Log("message", "opening the stream", "token", tkn)
+	s.ClientStream[tkn] = stream
+	return stream, true
+}
+
+// CloseStream removes the stream unless the client opened another one since, the session lasts for the
+// session timeout from then on
+func (s *server) CloseStream(tkn string, stream *queue.Queue[event]) {
+	s.streamMutex.Lock()
+	level.Debug(s.logger).Log("message", "closing the stream", "token", tkn)
+	if s.ClientStream[tkn] == stream {
+		delete(s.ClientStream, tkn)
+	}
+	s.streamMutex.Unlock()
+	s.touch(tkn)
+}
+
+func (s *server) extractToken(ctx context.Context) (string, bool) {
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md[tokenHeader]) == 0 {
+		return "", false
+	}
+	tkn := md[tokenHeader][0]
+	level.Debug(s.logger).Log("message", "successfully extracted the token", "token", tkn)
+	return tkn, true
+}
+
+// eventStream is the server side of Stream and Subscribe
+type eventStream interface {
+	Context() context.Context
+	SendHeader(metadata.MD) error
+	Send(*chat.StreamResponse) error
+}
+
+// broadcastAll sends the events of the client queue to the client until the queue is closed on
+// shutdown or the client goes away, recvErr reports when the client is done sending
+func (s *server) broadcastAll(srv_stream eventStream, tkn string, stream *queue.Queue[event], recvErr chan error) error {
+
+	logger := logging.FromContext(srv_stream.Context(), s.broadcastLogger)
+	level.Info(logger).Log("message", "started the broadcast for the given client")
+
+	// Stop waiting for events once the client is done sending
+	ctx, cancel := context.WithCancel(srv_stream.Context())
+	defer cancel()
+	received := make(chan error, 1)
+	go func() {
+		select {
+		case err := <-recvErr:
+			received <- err
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	for {
+
+		ev, err := stream.Pop(ctx)
+		switch {
+		case err == queue.ErrClosed:
+			level.Info(logger).Log("message", "client queue flushed, closing the stream")
+			return nil
+
+		case err != nil:
+			select {
+			case err := <-received:
+				if err != nil {
+					return err
+				}
+				// The client closed its side once its last message was published, it is leaving
+				level.Info(logger).Log("message", "client closed the stream")
+				return nil
+			default:
+			}
+			level.Info(logger).Log("message", "closing the broadcast for the given client")
+			return srv_stream.Context().Err()
+
+		default:
+			_, span := tracer.Start(ev.ctx, "send", trace.WithAttributes(attribute.String("chat.token", tkn)))
+			err := srv_stream.Send(ev.res)
+			if err != nil {
+				level.Error(logger).Log("error", "error while sending the stream", "err", err)
+				span.RecordError(err)
+			}
+			span.End()
+		}
+	}
+}
+
+// receive pushes the client messages to the common queue until the client closes its side, the stream that
+// sent a message the server refused is told why
+func (s *server) receive(srv_stream chat.Chat_StreamServer, tkn, name string) error {
+
+	logger := s.log(srv_stream.Context())
+	for {
+
+		req, err := srv_stream.Recv()
+		if err == io.EOF {
+			level.Info(logger).Log("message", "client disconnected, closing..")
+			return nil
+		}
+		if err != nil {
+			level.Error(logger).Log("error", "error while receiving ", "err", err)
+			return err
+		}
+
+		if err := s.post(srv_stream.Context(), name, req.Room, req.To, req.Message); err != nil {
+			level.Warn(logger).Log("message", "dropping message", "err", err)
+			s.refuse(srv_stream.Context(), tkn, req, err)
+		}
+	}
+}
+
+// refuse pushes the reason the message was refused for to the stream of the token, the other streams and
+// clients never see it
+func (s *server) refuse(ctx context.Context, tkn string, req *chat.StreamRequest, err error) {
+
+	st := status.Convert(err)
+	res := &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_MessageRefused{
+			MessageRefused: &chat.StreamResponse_Refused{
+				Room:    req.Room,
+				To:      req.To,
+				Message: req.Message,
+				Code:    int32(st.Code()),
+				Reason:  st.Message(),
+			},
+		},
+	}
+	s.streamMutex.RLock()
+	defer s.streamMutex.RUnlock()
+	stream, ok := s.ClientStream[tkn]
+	if !ok {
+		return
+	}
+	if err := stream.TryPush(event{ctx: ctx, res: res}); err != nil {
+		level.Warn(s.log(ctx)).Log("message", "client queue is full, dropping the refusal", "token", tkn)
+		s.metrics.droppedEvents.WithLabelValues(dropQueueFull).Inc()
+	}
+}
+
+// post publishes the message of the user to the room, the lobby when room is empty, or to the recipient
+// when to is set
+func (s *server) post(ctx context.Context, name, room, to, text string) error {
+
+	if to != "" {
+		if !s.isUser(ctx, to) {
+			return errUserNotFound
+		}
+		room = ""
+	} else {
+		if room == "" {
+			room = lobbyRoom
+		}
+		if !s.canReadRoom(name, room) && !s.canAnswer(name, room) {
+			return errRoomNotFound
+		}
+	}
+	// The direct messages take the chat-wide role
+	if !allowed(s.getRole(room, name), actionPost) {
+		return status.Error(codes.PermissionDenied, "posting is not permitted for the role")
+	}
+	// The invocations count as well, the bots answer them in the room
+	if !s.limiter.allow(name) {
+		s.metrics.rateLimited.Inc()
+		return errRateLimited
+	}
+	if to == "" {
+		// The slash commands go to their bot instead of the room, the bots cannot invoke them so that they
+		// never answer each other
+		if inv, ok := s.invocation(name, room, text); ok && !s.isBot(name) {
+			return s.invoke(ctx, inv)
+		}
+	}
+	id, err := s.generateToken()
+	if err != nil {
+		return status.Error(codes.Internal, "could not generate the message id")
+	}
+
+	s.metrics.messages.Inc()
+	ctx, span := tracer.Start(ctx, "message", trace.WithAttributes(attribute.String("chat.room", room)))
+	defer span.End()
+	s.publish(ctx, &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_ClientMessage{
+			ClientMessage: &chat.StreamResponse_Message{
+				Name:    name,
+				Message: text,
+				Room:    room,
+				To:      to,
+				Id:      id,
+			},
+		},
+	})
+	return nil
+}
+
+func (s *server) Post(ctx context.Context, req *chat.PostRequest) (*chat.PostResponse, error) {
+
+	name, ok := s.getClientName(req.Token)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "invalid token")
+	}
+	if err := s.post(ctx, name, req.Room, req.To, req.Message); err != nil {
+		return nil, err
+	}
+	return &chat.PostResponse{}, nil
+}
+
+func (s *server) Stream(srv_stream chat.Chat_StreamServer) error {
+
+	tkn, ok := s.extractToken(srv_stream.Context())
+	if !ok {
+		return status.Error(codes.Unauthenticated, "missing token header")
+	}
+	return s.serveEvents(srv_stream, tkn, func(name string) error {
+		return s.receive(srv_stream, tkn, name)
+	})
+}
+
+// Subscribe is Stream without the client side, for the clients that cannot stream their messages such as
+// the gRPC-Web ones. They send them with Post.
+func (s *server) Subscribe(req *chat.SubscribeRequest, srv_stream chat.Chat_SubscribeServer) error {
+
+	tkn := req.Token
+	if tkn == "" {
+		tkn, _ = s.extractToken(srv_stream.Context())
+	}
+	if tkn == "" {
+		return status.Error(codes.Unauthenticated, "missing token")
+	}
+	return s.serveEvents(srv_stream, tkn, nil)
+}
+
+// serveEvents registers the stream of the client and sends it the events until it is closed, receive runs
+// alongside when the client streams its messages
+func (s *server) serveEvents(srv_stream eventStream, tkn string, receive func(name string) error) error {
+
+	name, ok := s.getClientName(tkn)
+	if !ok {
+		return status.Error(codes.InvalidArgument, "username not found!")
+	}
+	if s.isDraining() {
+		return errShuttingDown
+	}
+	stream, ok := s.OpenStream(tkn)
+	if !ok {
+		return errShuttingDown
+	}
+	s.streams.Add(1)
+	defer s.streams.Done()
+	if s.isBot(name) {
+		s.announceUsers(srv_stream.Context())
+		defer s.announceUsers(context.Background())
+	}
+	defer s.CloseStream(tkn, stream)
+	s.metrics.connectedStreams.Inc()
+	defer s.metrics.connectedStreams.Dec()
+	// The headers tell the client its stream is registered, it gets every event published from now on
+	if err := srv_stream.SendHeader(metadata.MD{}); err != nil {
+		return err
+	}
+
+	// go routine for receiving the client messages and pushing them to the common queue, a nil recvErr
+	// never fires
+	var recvErr chan error
+	if receive != nil {
+		recvErr = make(chan error, 1)
+		go func() {
+			recvErr <- receive(name)
+		}()
+	}
+	// Send all individual client messages from the individual client queue to the client
+	return s.broadcastAll(srv_stream, tkn, stream, recvErr)
+}
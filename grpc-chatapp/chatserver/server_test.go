@@ -0,0 +1,206 @@
+package chatserver
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/queue"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestListUsers(t *testing.T) {
+
+	s := newServer(log.NewNopLogger())
+	s.addClientName("bob", "tkn-bob")
+	s.addClientName("alice", "tkn-alice")
+	s.addClientName("alice", "tkn-alice-2")
+
+	res, err := s.ListUsers(context.Background(), &chat.ListUsersRequest{Token: "tkn-bob"})
+	if err != nil {
+		t.Fatalf("ListUsers() error = %v", err)
+	}
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(res.Usernames, want) {
+		t.Fatalf("ListUsers() = %v, want %v", res.Usernames, want)
+	}
+
+	_, err = s.ListUsers(context.Background(), &chat.ListUsersRequest{Token: "tkn-unknown"})
+	if code := status.Code(err); code != codes.Unauthenticated {
+		t.Fatalf("ListUsers() with unknown token code = %v, want %v", code, codes.Unauthenticated)
+	}
+}
+
+func TestLogin(t *testing.T) {
+
+	s := newServer(log.NewNopLogger())
+	if err := s.useIncomingWebhooks([]IncomingWebhook{{Room: lobbyRoom, Name: "ci", Token: "secret"}}); err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	alice, err := s.Login(ctx, &chat.LoginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+
+	tests := []struct {
+		name string
+		req  *chat.LoginRequest
+		want codes.Code
+	}{
+		{"name in use", &chat.LoginRequest{Username: "alice"}, codes.AlreadyExists},
+		{"token of another user", &chat.LoginRequest{Username: "alice", Token: "tkn-unknown"}, codes.AlreadyExists},
+		{"reserved name", &chat.LoginRequest{Username: "ci"}, codes.AlreadyExists},
+		{"federated name", &chat.LoginRequest{Username: "bob@teamB"}, codes.InvalidArgument},
+		{"missing name", &chat.LoginRequest{}, codes.InvalidArgument},
+		{"other name", &chat.LoginRequest{Username: "bob"}, codes.OK},
+	}
+	for _, tt := range tests {
+		_, err := s.Login(ctx, tt.req)
+		if code := status.Code(err); code != tt.want {
+			t.Errorf("%v: Login() code = %v, want %v", tt.name, code, tt.want)
+		}
+	}
+
+	// Logging in again with the previous token replaces it
+	again, err := s.Login(ctx, &chat.LoginRequest{Username: "alice", Token: alice.Token})
+	if err != nil {
+		t.Fatalf("Login() with the previous token error = %v", err)
+	}
+	if _, ok := s.getClientName(alice.Token); ok {
+		t.Errorf("the previous token is still valid")
+	}
+	if role := s.getRole("", "alice"); role != chat.Role_OWNER {
+		t.Errorf("role after logging in again = %v, want %v", role, chat.Role_OWNER)
+	}
+
+	// The name is free once logged out
+	if _, err := s.Logout(ctx, &chat.LogoutRequest{Token: again.Token}); err != nil {
+		t.Fatalf("Logout() error = %v", err)
+	}
+	if _, err := s.Login(ctx, &chat.LoginRequest{Username: "alice"}); err != nil {
+		t.Errorf("Login() after logging out error = %v", err)
+	}
+}
+
+func TestDirectMessages(t *testing.T) {
+
+	s := newServer(log.NewNopLogger())
+	streams := make(map[string]*queue.Queue[event])
+	for _, name := range []string{"alice", "bob", "carol"} {
+		s.addClientName(name, "tkn-"+name)
+		s.setRole("", name, chat.Role_MEMBER)
+		streams[name], _ = s.OpenStream("tkn-" + name)
+	}
+	go s.broadcast()
+	defer close(s.CommonChannel)
+
+	ctx := context.Background()
+	_, err := s.Post(ctx, &chat.PostRequest{Token: "tkn-alice", To: "dave", Message: "hi"})
+	if code := status.Code(err); code != codes.NotFound {
+		t.Fatalf("Post() to a missing user code = %v, want %v", code, codes.NotFound)
+	}
+	for _, req := range []*chat.PostRequest{
+		{Token: "tkn-alice", To: "bob", Room: "ignored", Message: "psst"},
+		{Token: "tkn-alice", Message: "hello all"},
+	} {
+		if _, err := s.Post(ctx, req); err != nil {
+			t.Fatalf("Post() error = %v", err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		want []string
+	}{
+		{"alice", []string{"psst", "hello all"}},
+		{"bob", []string{"psst", "hello all"}},
+		{"carol", []string{"hello all"}},
+	}
+	for _, tt := range tests {
+		for _, want := range tt.want {
+			ev, err := streams[tt.name].Pop(ctx)
+			if err != nil {
+				t.Fatalf("Pop() error = %v", err)
+			}
+			msg := ev.res.GetClientMessage()
+			if msg.Message != want {
+				t.Fatalf("%v received %q, want %q", tt.name, msg.Message, want)
+			}
+			if want == "psst" && (msg.To != "bob" || msg.Room != "") {
+				t.Fatalf("direct message sent to %q in %q, want to bob without a room", msg.To, msg.Room)
+			}
+		}
+	}
+}
+
+// recvStream is the server side of a Stream whose client sends reqs and closes its side
+type recvStream struct {
+	chat.Chat_StreamServer
+	reqs []*chat.StreamRequest
+}
+
+func (r *recvStream) Context() context.Context { return context.Background() }
+
+func (r *recvStream) Recv() (*chat.StreamRequest, error) {
+
+	if len(r.reqs) == 0 {
+		return nil, io.EOF
+	}
+	req := r.reqs[0]
+	r.reqs = r.reqs[1:]
+	return req, nil
+}
+
+func TestRefusedMessages(t *testing.T) {
+
+	s := newRoomsServer(t)
+	s.addClientName("carol", "tkn-carol")
+	s.setRole("", "carol", chat.Role_GUEST)
+	s.limiter = newLimiter(RateLimit{PerSecond: 0.001, Burst: 1})
+	bob, _ := s.OpenStream("tkn-bob")
+	carol, _ := s.OpenStream("tkn-carol")
+	bobStream := &recvStream{reqs: []*chat.StreamRequest{
+		{Room: "missing", Message: "hi"},
+		{To: "dave", Message: "psst"},
+		{Room: "public", Message: "hi"},
+		{Room: "public", Message: "again"},
+	}}
+	if err := s.receive(bobStream, "tkn-bob", "bob"); err != nil {
+		t.Fatalf("receive() error = %v", err)
+	}
+	carolStream := &recvStream{reqs: []*chat.StreamRequest{{Message: "hi"}}}
+	if err := s.receive(carolStream, "tkn-carol", "carol"); err != nil {
+		t.Fatalf("receive() error = %v", err)
+	}
+
+	tests := []struct {
+		stream  *queue.Queue[event]
+		message string
+		want    codes.Code
+	}{
+		{bob, "hi", codes.NotFound},
+		{bob, "psst", codes.NotFound},
+		{bob, "again", codes.ResourceExhausted},
+		{carol, "hi", codes.PermissionDenied},
+	}
+	for _, tt := range tests {
+		ev, err := tt.stream.TryPop()
+		if err != nil {
+			t.Fatalf("TryPop() error = %v, want the refusal of %q", err, tt.message)
+		}
+		got := ev.res.GetMessageRefused()
+		if got.GetMessage() != tt.message || codes.Code(got.GetCode()) != tt.want {
+			t.Errorf("refusal = %v, want %q refused with %v", got, tt.message, tt.want)
+		}
+	}
+	// The refusals go to the stream that sent the message alone
+	for name, stream := range map[string]*queue.Queue[event]{"bob": bob, "carol": carol} {
+		if n := stream.Len(); n != 0 {
+			t.Errorf("%v has %d more events, want none", name, n)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package chatserver
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log/level"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+)
+
+// touch records that the session of the token is in use
+func (s *server) touch(tkn string) {
+
+	s.nameMutex.Lock()
+	defer s.nameMutex.Unlock()
+	if _, ok := s.ClientName[tkn]; ok {
+		s.lastSeen[tkn] = time.Now()
+	}
+}
+
+// expire removes the sessions that have no stream open and were not used since before, it returns the names
+// they were logged in under
+func (s *server) expire(before time.Time) []string {
+
+	// The streams are opened under streamMutex, none can be opened for a session being removed
+	s.streamMutex.RLock()
+	defer s.streamMutex.RUnlock()
+	s.nameMutex.Lock()
+	defer s.nameMutex.Unlock()
+	var names []string
+	for tkn, seen := range s.lastSeen {
+		if _, ok := s.ClientStream[tkn]; ok || seen.After(before) {
+			continue
+		}
+		level.Debug(s.logger).Log("message", "the session expired", "token", tkn)
+		names = append(names, s.ClientName[tkn])
+		delete(s.ClientName, tkn)
+		delete(s.lastSeen, tkn)
+	}
+	return names
+}
+
+// expireSessions ends the sessions left without a stream nor a call for the session timeout, e.g. by the
+// clients that crashed, so that their names are released. It returns once the broadcast stopped on shutdown.
+func (s *server) expireSessions() {
+
+	ticker := time.NewTicker(s.sessionTimeout / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-s.broadcastDone:
+			return
+		}
+		for _, name := range s.expire(time.Now().Add(-s.sessionTimeout)) {
+			level.Info(s.logger).Log("message", "the session expired", "client", name)
+			s.endSession(context.Background(), name)
+		}
+	}
+}
+
+// releaseName drops what the user was given under the name: the roles, the memberships and the invites.
+// Nothing proves who logs in under a name, the next user starts afresh. The kicks stay.
+func (s *server) releaseName(ctx context.Context, username string) {
+
+	var changes []*chat.StateChange
+	var rooms []string
+	s.roleMutex.Lock()
+	for k := range s.ClientRole {
+		if k.user == username {
+			delete(s.ClientRole, k)
+			rooms = append(rooms, k.room)
+		}
+	}
+	s.roleMutex.Unlock()
+	for _, room := range rooms {
+		changes = append(changes, s.roleChange(room, username))
+	}
+
+	rooms = nil
+	s.roomMutex.Lock()
+	for name, r := range s.Rooms {
+		if r.members[username] || r.invited[username] {
+			delete(r.members, username)
+			delete(r.invited, username)
+			rooms = append(rooms, name)
+		}
+	}
+	s.roomMutex.Unlock()
+	for _, room := range rooms {
+		changes = append(changes, s.membershipChange(room, username))
+	}
+	s.share(ctx, changes...)
+}
@@ -0,0 +1,121 @@
+package chatserver_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const sessionTimeout = 100 * time.Millisecond
+
+func TestSessionExpiry(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{SessionTimeout: sessionTimeout})
+	client := chat.NewChatClient(s.Dial())
+	ctx := context.Background()
+	login, err := client.Login(ctx, &chat.LoginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	streamCtx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(ctx, "x-chat-token", login.Token))
+	defer cancel()
+	stream, err := client.Stream(streamCtx)
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if _, err := stream.Header(); err != nil {
+		t.Fatalf("Header() error = %v", err)
+	}
+
+	// The open stream keeps the session
+	time.Sleep(3 * sessionTimeout)
+	if _, err := client.Login(ctx, &chat.LoginRequest{Username: "alice"}); status.Code(err) != codes.AlreadyExists {
+		t.Fatalf("Login() while the stream is open error = %v, want %v", err, codes.AlreadyExists)
+	}
+
+	// The client goes away without logging out, the name is released once the session expired
+	cancel()
+	deadline := time.Now().Add(chattest.Timeout)
+	for {
+		_, err := client.Login(ctx, &chat.LoginRequest{Username: "alice"})
+		if err == nil {
+			break
+		}
+		if status.Code(err) != codes.AlreadyExists || time.Now().After(deadline) {
+			t.Fatalf("Login() once the stream dropped error = %v, want the name released", err)
+		}
+		time.Sleep(sessionTimeout / 4)
+	}
+	if _, err := client.ListUsers(ctx, &chat.ListUsersRequest{Token: login.Token}); status.Code(err) != codes.Unauthenticated {
+		t.Fatalf("ListUsers() with the expired token error = %v, want %v", err, codes.Unauthenticated)
+	}
+}
+
+func TestReleasedNameStartsAfresh(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	client := chat.NewChatClient(s.Dial())
+	ctx := context.Background()
+	login := func(name string) string {
+		t.Helper()
+		res, err := client.Login(ctx, &chat.LoginRequest{Username: name})
+		if err != nil {
+			t.Fatalf("Login(%v) error = %v", name, err)
+		}
+		return res.Token
+	}
+	// alice owns the chat and ops, bob owns dev
+	alice, bob := login("alice"), login("bob")
+	for _, room := range []struct{ token, name string }{{alice, "ops"}, {bob, "dev"}} {
+		req := &chat.CreateRoomRequest{Token: room.token, Name: room.name, Visibility: chat.Visibility_PRIVATE}
+		if _, err := client.CreateRoom(ctx, req); err != nil {
+			t.Fatalf("CreateRoom(%v) error = %v", room.name, err)
+		}
+	}
+	if _, err := client.Invite(ctx, &chat.InviteRequest{Token: bob, Room: "dev", Username: "alice"}); err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	for _, tkn := range []string{alice, bob} {
+		if _, err := client.Logout(ctx, &chat.LogoutRequest{Token: tkn}); err != nil {
+			t.Fatalf("Logout() error = %v", err)
+		}
+	}
+
+	// Someone else logs in under the names once they are released, carol keeps the chat from having no
+	// user so that the first of them does not become its owner
+	login("carol")
+	alice, bob = login("alice"), login("bob")
+	tests := []struct {
+		name string
+		call func() error
+	}{
+		{"alice grants a chat-wide role", func() error {
+			_, err := client.GrantRole(ctx, &chat.GrantRoleRequest{Token: alice, Username: "carol", Role: chat.Role_MODERATOR})
+			return err
+		}},
+		{"bob grants a role in dev", func() error {
+			_, err := client.GrantRole(ctx, &chat.GrantRoleRequest{Token: bob, Room: "dev", Username: "carol", Role: chat.Role_MODERATOR})
+			return err
+		}},
+		{"alice posts to ops", func() error {
+			_, err := client.Post(ctx, &chat.PostRequest{Token: alice, Room: "ops", Message: "hi"})
+			return err
+		}},
+		{"alice accepts the invite to dev", func() error {
+			_, err := client.AcceptInvite(ctx, &chat.AcceptInviteRequest{Token: alice, Room: "dev"})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		if err := tt.call(); err == nil {
+			t.Errorf("%v: error = nil, want the call refused", tt.name)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package chatserver
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+var errShuttingDown = status.Error(codes.Unavailable, DefaultShutdownReason)
+
+func (s *server) isDraining() bool {
+
+	s.closeMutex.RLock()
+	defer s.closeMutex.RUnlock()
+	return s.draining
+}
+
+// closeStreams closes every client queue and refuses the streams opened afterwards
+func (s *server) closeStreams() {
+
+	s.streamMutex.Lock()
+	defer s.streamMutex.Unlock()
+	level.Debug(s.broadcastLogger).Log("message", "closing the client queues")
+	for tkn, stream := range s.ClientStream {
+		stream.Close()
+		delete(s.ClientStream, tkn)
+	}
+	s.streamsClosed = true
+}
+
+// shutdown drains the server: it stops accepting logins and streams, sends the notice to the clients
+// along with the deadline of ctx they will be disconnected by, closes the common channel and waits for
+// the broadcast to flush it and for every stream to send what is left in its client queue and end.
+func (s *server) shutdown(ctx context.Context, notice *chat.StreamResponse_Shutdown) error {
+
+	s.closeMutex.Lock()
+	s.draining = true
+	s.closeMutex.Unlock()
+
+	shutdown := proto.Clone(notice).(*chat.StreamResponse_Shutdown)
+	if deadline, ok := ctx.Deadline(); ok {
+		shutdown.Deadline, _ = ptypes.TimestampProto(deadline)
+	}
+	level.Info(s.logger).Log("message", "sending shutdown notification", "reason", shutdown.Reason)
+	// The notice is for the clients of this instance only
+	s.deliver(context.Background(), &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event:     &chat.StreamResponse_ServerShutdown{ServerShutdown: shutdown},
+	})
+
+	// Publishers hold the read lock while sending, so nothing is sent on the closed channel
+	level.Info(s.logger).Log("message", "closing the channel")
+	s.closeMutex.Lock()
+	s.closed = true
+	close(s.CommonChannel)
+	s.closeMutex.Unlock()
+
+	drained := make(chan struct{})
+	go func() {
+		<-s.broadcastDone
+		s.streams.Wait()
+		close(drained)
+	}()
+
+	select {
+	case <-drained:
+		level.Info(s.logger).Log("message", "all the streams are closed")
+		return nil
+	case <-ctx.Done():
+		level.Warn(s.logger).Log("message", "grace period is over, streams are still open")
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,139 @@
+package chatserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+)
+
+// membership returns the membership of the user in the room, false when it has none
+func (r *room) membership(username string) (chat.StateChange_Membership_Status, bool) {
+
+	switch {
+	case r.members[username]:
+		return chat.StateChange_Membership_MEMBER, true
+	case r.invited[username]:
+		return chat.StateChange_Membership_INVITED, true
+	case r.kicked[username]:
+		return chat.StateChange_Membership_KICKED, true
+	}
+	return 0, false
+}
+
+// setMembership gives the user the membership in the room, none when removed
+func (r *room) setMembership(username string, st chat.StateChange_Membership_Status, removed bool) {
+
+	delete(r.members, username)
+	delete(r.invited, username)
+	delete(r.kicked, username)
+	if removed {
+		return
+	}
+	switch st {
+	case chat.StateChange_Membership_MEMBER:
+		r.members[username] = true
+	case chat.StateChange_Membership_INVITED:
+		r.invited[username] = true
+	case chat.StateChange_Membership_KICKED:
+		r.kicked[username] = true
+	}
+}
+
+// roomChange is the creation of the room
+func (s *server) roomChange(name string) *chat.StateChange {
+
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	change := &chat.StateChange{Key: "room/" + name}
+	if r, ok := s.Rooms[name]; ok {
+		change.Change = &chat.StateChange_Room{Room: &chat.Room{Name: name, Visibility: r.visibility}}
+	}
+	return change
+}
+
+// membershipChange is the current membership of the user in the room
+func (s *server) membershipChange(room, username string) *chat.StateChange {
+
+	s.roomMutex.RLock()
+	defer s.roomMutex.RUnlock()
+	m := &chat.StateChange_Membership{Room: room, User: username}
+	removed := true
+	if r, ok := s.Rooms[room]; ok {
+		m.Status, ok = r.membership(username)
+		removed = !ok
+	}
+	return &chat.StateChange{
+		Key:     fmt.Sprintf("member/%v/%v", room, username),
+		Removed: removed,
+		Change:  &chat.StateChange_Membership_{Membership: m},
+	}
+}
+
+// roleChange is the current role of the user in the room, room is empty for the chat-wide one
+func (s *server) roleChange(room, username string) *chat.StateChange {
+
+	s.roleMutex.RLock()
+	defer s.roleMutex.RUnlock()
+	role, ok := s.ClientRole[roleKey{room, username}]
+	return &chat.StateChange{
+		Key:     fmt.Sprintf("role/%v/%v", room, username),
+		Removed: !ok,
+		Change:  &chat.StateChange_Role{Role: &chat.StateChange_Grant{Room: room, User: username, Role: role}},
+	}
+}
+
+// share hands the changes this instance made over to the broker, so that every instance sharing it applies
+// them. Applying is idempotent, the changes come back to this instance as well.
+func (s *server) share(ctx context.Context, changes ...*chat.StateChange) {
+
+	for _, change := range changes {
+		s.publish(ctx, &chat.StreamResponse{
+			Timestamp: ptypes.TimestampNow(),
+			Event:     &chat.StreamResponse_StateChange{StateChange: change},
+		})
+	}
+}
+
+// apply makes the change published by an instance, this one included
+func (s *server) apply(change *chat.StateChange) {
+
+	switch c := change.Change.(type) {
+	case *chat.StateChange_Room:
+		s.roomMutex.Lock()
+		defer s.roomMutex.Unlock()
+		if r, ok := s.Rooms[c.Room.Name]; ok {
+			r.visibility = c.Room.Visibility
+			return
+		}
+		s.Rooms[c.Room.Name] = newRoom(c.Room.Name, c.Room.Visibility)
+
+	case *chat.StateChange_Membership_:
+		s.roomMutex.Lock()
+		defer s.roomMutex.Unlock()
+		if r, ok := s.Rooms[c.Membership.Room]; ok {
+			r.setMembership(c.Membership.User, c.Membership.Status, change.Removed)
+		}
+
+	case *chat.StateChange_Role:
+		s.roleMutex.Lock()
+		defer s.roleMutex.Unlock()
+		k := roleKey{c.Role.Room, c.Role.User}
+		if change.Removed {
+			delete(s.ClientRole, k)
+			return
+		}
+		s.ClientRole[k] = c.Role.Role
+
+	case *chat.StateChange_Command:
+		s.commandMutex.Lock()
+		defer s.commandMutex.Unlock()
+		s.commands[c.Command.Command] = command{bot: c.Command.Bot, description: c.Command.Description}
+
+	case *chat.StateChange_ApprovedWebhook:
+		if s.webhooks != nil {
+			s.webhooks.Approve(c.ApprovedWebhook)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+// Package chattest runs a chat server in the test process on an in-memory listener and hands out
+// clients connected to it:
+//
+//	s := chattest.NewServer(t, chatserver.Options{})
+//	alice, bob := s.Client("alice"), s.Client("bob")
+//	alice.Send(ctx, "", "hello")
+//	msg := bob.Next(chatclient.Message{}).(chatclient.Message)
+//
+// The server and the clients are stopped when the test ends.
+package chattest
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/test/bufconn"
+)
+
+const (
+	// Addr is the address of the server, any address dials it with DialOptions
+	Addr           = "bufconn"
+	bufferSize     = 1 << 20
+	shutdownPeriod = 5 * time.Second
+	// Timeout bounds the waits of the clients for an event
+	Timeout = 5 * time.Second
+)
+
+type Server struct {
+	*chatserver.Server
+	t   testing.TB
+	lis *bufconn.Listener
+}
+
+// NewServer starts a server, it is shut down when the test ends
+func NewServer(t testing.TB, opts chatserver.Options) *Server {
+
+	t.Helper()
+	srv, err := chatserver.NewServer(opts)
+	if err != nil {
+		t.Fatalf("chatserver.NewServer() error = %v", err)
+	}
+	s := &Server{Server: srv, t: t, lis: bufconn.Listen(bufferSize)}
+
+	served := make(chan struct{})
+	go func() {
+		defer close(served)
+		s.Serve(s.lis)
+	}()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), shutdownPeriod)
+		defer cancel()
+		s.Shutdown(ctx)
+		<-served
+	})
+	return s
+}
+
+// DialOptions dial the server whatever the address
+func (s *Server) DialOptions() []grpc.DialOption {
+	return []grpc.DialOption{
+		grpc.WithInsecure(),
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return s.lis.Dial() }),
+	}
+}
+
+// Dial opens a connection to the server, it is closed when the test ends
+func (s *Server) Dial() *grpc.ClientConn {
+
+	s.t.Helper()
+	cc, err := grpc.Dial(Addr, s.DialOptions()...)
+	if err != nil {
+		s.t.Fatalf("Dial() error = %v", err)
+	}
+	s.t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+// Client logs in as name, joins the rooms and returns once the stream is open
+func (s *Server) Client(name string, rooms ...string) *Client {
+
+	s.t.Helper()
+	return s.ClientWithConfig(chatclient.Config{Username: name, Rooms: rooms})
+}
+
+// ClientWithConfig connects a client configured with cfg, the servers and the dial options are set to reach
+// the test server
+func (s *Server) ClientWithConfig(cfg chatclient.Config) *Client {
+
+	s.t.Helper()
+	cfg.Servers = []string{Addr}
+	cfg.DialOptions = append(s.DialOptions(), cfg.DialOptions...)
+	sdk, err := chatclient.New(cfg)
+	if err != nil {
+		s.t.Fatalf("chatclient.New() error = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c := &Client{Client: sdk, t: s.t, cancel: cancel, done: make(chan error, 1)}
+	go func() { c.done <- sdk.Run(ctx) }()
+	s.t.Cleanup(func() { c.Close() })
+	c.Next(chatclient.Connected{})
+	return c
+}
+
+// Client is a chat client running until Close is called or the test ends
+type Client struct {
+	*chatclient.Client
+	t      testing.TB
+	cancel context.CancelFunc
+	done   chan error
+
+	closeOnce sync.Once
+	err       error
+}
+
+// Next waits for the next event of the same type as want, the events of other types are skipped.
+// The test fails if none comes within Timeout.
+func (c *Client) Next(want chatclient.Event) chatclient.Event {
+
+	c.t.Helper()
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case ev, ok := <-c.Events():
+			if !ok {
+				c.t.Fatalf("%v: events closed while waiting for a %T", c.Name(), want)
+			}
+			if reflect.TypeOf(ev) == reflect.TypeOf(want) {
+				return ev
+			}
+		case <-timeout:
+			c.t.Fatalf("%v: timed out waiting for a %T", c.Name(), want)
+		}
+	}
+}
+
+// Wait waits for the client to stop on its own, e.g. on shutdown, and returns the error of Run
+func (c *Client) Wait() error {
+
+	c.t.Helper()
+	timeout := time.After(Timeout)
+	for {
+		select {
+		case _, ok := <-c.Events():
+			if !ok {
+				return c.Close()
+			}
+		case <-timeout:
+			c.t.Fatalf("%v: timed out waiting for the client to stop", c.Name())
+		}
+	}
+}
+
+// Close logs out once the server got everything that was sent and returns the error of Run
+func (c *Client) Close() error {
+
+	c.closeOnce.Do(func() {
+		c.cancel()
+		for range c.Events() {
+		}
+		c.err = <-c.done
+	})
+	return c.err
+}
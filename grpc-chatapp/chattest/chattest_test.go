@@ -0,0 +1,155 @@
+package chattest_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestMain(m *testing.M) {
+	// The timers of the gRPC-Web wrapper are run by a goroutine started on init
+	goleak.VerifyTestMain(m, goleak.IgnoreTopFunction("github.com/desertbit/timer.timerRoutine"))
+}
+
+func TestLogin(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	alice := s.Client("alice")
+	s.Client("bob")
+
+	if login := alice.Next(chatclient.Login{}).(chatclient.Login); login.Name != "bob" {
+		t.Fatalf("alice saw %v log in, want bob", login.Name)
+	}
+	users, err := alice.ListUsers(context.Background())
+	if err != nil {
+		t.Fatalf("ListUsers() error = %v", err)
+	}
+	if want := []string{"alice", "bob"}; !reflect.DeepEqual(users, want) {
+		t.Fatalf("ListUsers() = %v, want %v", users, want)
+	}
+
+	_, err = chat.NewChatClient(s.Dial()).Login(context.Background(), &chat.LoginRequest{})
+	if code := status.Code(err); code != codes.InvalidArgument {
+		t.Fatalf("Login() without a username code = %v, want %v", code, codes.InvalidArgument)
+	}
+}
+
+func TestBroadcast(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	alice, bob, carol := s.Client("alice"), s.Client("bob"), s.Client("carol")
+
+	ctx := context.Background()
+	if err := alice.CreateRoom(ctx, "ops", chat.Visibility_PRIVATE); err != nil {
+		t.Fatalf("CreateRoom() error = %v", err)
+	}
+	if err := alice.Invite(ctx, "ops", "bob"); err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	if err := bob.AcceptInvite(ctx, "ops"); err != nil {
+		t.Fatalf("AcceptInvite() error = %v", err)
+	}
+	if err := alice.Send(ctx, "", "hello"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := alice.Send(ctx, "ops", "deploying"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	tests := []struct {
+		client *chattest.Client
+		want   []chatclient.Message
+	}{
+		{alice, []chatclient.Message{{Name: "alice", Room: "lobby", Text: "hello"}, {Name: "alice", Room: "ops", Text: "deploying"}}},
+		{bob, []chatclient.Message{{Name: "alice", Room: "lobby", Text: "hello"}, {Name: "alice", Room: "ops", Text: "deploying"}}},
+		{carol, []chatclient.Message{{Name: "alice", Room: "lobby", Text: "hello"}}},
+	}
+	for _, tt := range tests {
+		for _, want := range tt.want {
+			got := tt.client.Next(chatclient.Message{}).(chatclient.Message)
+			if got.Name != want.Name || got.Room != want.Room || got.Text != want.Text {
+				t.Fatalf("%v received %v in %v from %v, want %v in %v from %v",
+					tt.client.Name(), got.Text, got.Room, got.Name, want.Text, want.Room, want.Name)
+			}
+		}
+	}
+
+	// carol is not in ops, the next message she gets is from the lobby
+	if err := bob.Send(ctx, "", "bye"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := carol.Next(chatclient.Message{}).(chatclient.Message); got.Text != "bye" {
+		t.Fatalf("carol received %q, want bye", got.Text)
+	}
+}
+
+func TestLogout(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	alice, bob := s.Client("alice"), s.Client("bob")
+
+	if err := bob.Send(context.Background(), "", "last words"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if err := bob.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	// What bob sent before leaving is delivered before his logout
+	if msg := alice.Next(chatclient.Message{}).(chatclient.Message); msg.Text != "last words" {
+		t.Fatalf("alice received %q, want the last words of bob", msg.Text)
+	}
+	if logout := alice.Next(chatclient.Logout{}).(chatclient.Logout); logout.Name != "bob" {
+		t.Fatalf("alice saw %v log out, want bob", logout.Name)
+	}
+	users, err := alice.ListUsers(context.Background())
+	if err != nil {
+		t.Fatalf("ListUsers() error = %v", err)
+	}
+	if want := []string{"alice"}; !reflect.DeepEqual(users, want) {
+		t.Fatalf("ListUsers() = %v, want %v", users, want)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{
+		ShutdownReason:     "upgrade",
+		RestartETA:         time.Minute,
+		AlternateAddresses: []string{"backup:50051"},
+	})
+	alice, bob := s.Client("alice"), s.Client("bob")
+	cc := s.Dial()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := s.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() error = %v", err)
+	}
+
+	for _, c := range []*chattest.Client{alice, bob} {
+		shutdown := c.Next(chatclient.Shutdown{}).(chatclient.Shutdown)
+		if shutdown.Reason != "upgrade" || shutdown.Deadline.IsZero() || shutdown.RestartETA.IsZero() ||
+			!reflect.DeepEqual(shutdown.Alternates, []string{"backup:50051"}) {
+			t.Fatalf("%v received the shutdown notice %+v", c.Name(), shutdown)
+		}
+		// The clients do not reconnect, they stop once the server ended their stream
+		if err := c.Wait(); status.Code(err) != codes.Unavailable {
+			t.Fatalf("%v stopped with %v, want %v", c.Name(), err, codes.Unavailable)
+		}
+	}
+
+	_, err := chat.NewChatClient(cc).Login(context.Background(), &chat.LoginRequest{Username: "carol"})
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Fatalf("Login() after shutdown code = %v, want %v", code, codes.Unavailable)
+	}
+}
@@ -3,153 +3,317 @@ package main
 import (
 	"bufio"
 	"context"
+	"errors"
+	"flag"
 	"fmt"
-	"io"
 	"log"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/golang/protobuf/ptypes"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
 	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/tracing"
 	"google.golang.org/grpc"
-	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
 )
 
-const tokenHeader = "x-chat-token"
+// errQuit is returned by the commands once the user is done chatting
+var errQuit = errors.New("user quit")
 
 type client struct {
-	chat.ChatClient
-	Name, Token string
+	*chatclient.Client
+	// lines carries the lines typed by the user, it is closed when they quit
+	lines chan string
+	ui    ui
+
+	// mu guards the room messages are posted to and the commands of the bots, the ui joins rooms from its own
+	// goroutine
+	mu   sync.Mutex
+	room string
+	bots []*chat.Command
 }
 
-func Client() *client {
-	return &client{}
+func (c *client) currentRoom() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.room
 }
 
-func (c *client) login() (string, error) {
-	ctx := context.Background()
-
-	res, err := c.ChatClient.Login(ctx, &chat.LoginRequest{
-		Username: c.Name,
-	})
-	if err != nil {
-		return "", nil
-	}
+func (c *client) setRoom(room string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.room = room
+}
 
-	return res.Token, nil
+func (c *client) botCommands() []*chat.Command {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bots
 }
 
-func (c *client) logout() error {
+// refresh shows the rooms, the users and the commands of the bots currently known to the server
+func (c *client) refresh() {
 
 	ctx := context.Background()
-	_, err := c.ChatClient.Logout(ctx, &chat.LogoutRequest{
-		Token: c.Token,
-	})
+	if rooms, err := c.ListRooms(ctx); err == nil {
+		var names []string
+		for _, room := range rooms {
+			names = append(names, room.Name)
+		}
+		c.ui.setRooms(names, c.currentRoom())
+	}
+	if users, err := c.ListUsers(ctx); err == nil {
+		c.ui.setUsers(users)
+	}
+	// The bots log in and out like the users, their commands are fetched again then
+	if bots, err := c.ListCommands(ctx); err == nil {
+		c.mu.Lock()
+		c.bots = bots
+		c.mu.Unlock()
+		var names []string
+		for _, cmd := range bots {
+			names = append(names, cmd.Command)
+		}
+		c.ui.setCommands(names)
+	}
+}
 
-	return err
+// joinRoom joins room and posts the next messages there
+func (c *client) joinRoom(room string) {
 
+	if err := c.Join(context.Background(), room); err != nil {
+		c.ui.notice(time.Now(), fmt.Sprintf("could not join %v: %v", room, err))
+		return
+	}
+	c.setRoom(room)
+	c.ui.notice(time.Now(), fmt.Sprintf("now talking in %v", room))
+	c.refresh()
 }
 
-func (c *client) send(client chat.Chat_StreamClient) {
+func (c *client) post(ctx context.Context, message string) error {
+	return c.Send(ctx, c.currentRoom(), message)
+}
 
-	reader := bufio.NewReader(os.Stdin)
+// send posts the lines typed by the user and runs their commands until they quit
+func (c *client) send(ctx context.Context, quit func()) {
 
 	for {
 
 		select {
-		case <-client.Context().Done():
-			fmt.Println("client send loop disconnected")
-		default:
-			txt, _ := reader.ReadString('\n')
-			message := strings.Trim(txt, "\n")
-			err := client.Send(&chat.StreamRequest{Message: message, Name: c.Name})
+		case <-ctx.Done():
+			return
+		case line, ok := <-c.lines:
+			if !ok {
+				quit()
+				return
+			}
+			cmd, args, isCommand, err := parse(line, c.botCommands())
 			if err != nil {
-				log.Fatalf("failed to send message %v", err)
+				c.ui.notice(time.Now(), err.Error())
+				continue
+			}
+			if isCommand {
+				err := cmd.run(c, args)
+				if err == errQuit {
+					quit()
+					return
+				}
+				if err != nil {
+					c.ui.notice(time.Now(), fmt.Sprintf("/%v failed: %v", cmd.name, err))
+				}
+				continue
+			}
+			if strings.HasPrefix(line, commandPrefix+commandPrefix) {
+				line = strings.TrimPrefix(line, commandPrefix)
+			}
+			if err := c.post(ctx, line); err != nil {
+				c.ui.notice(time.Now(), fmt.Sprintf("failed to send message %v", err))
 			}
 		}
 	}
 
 }
 
-func (c *client) receive(client chat.Chat_StreamClient) {
-
-	for {
-		res, err := client.Recv()
-
-		if err == io.EOF {
-			fmt.Printf("Stream closed by server")
-			return
+// show puts the event on the screen
+func (c *client) show(ev chatclient.Event) {
+
+	switch ev := ev.(type) {
+	case chatclient.Connected:
+		c.ui.notice(time.Now(), fmt.Sprintf("connected to %v as %v", ev.Addr, ev.Name))
+		// Keep talking in the same room if it was joined again
+		room := ""
+		for _, r := range ev.Rooms {
+			if r == c.currentRoom() || room == "" {
+				room = r
+			}
 		}
-
-		if err != nil {
-			log.Fatalf("Unknown Error at receive : %v", err)
-			return
+		c.setRoom(room)
+		go c.refresh()
+	case chatclient.Disconnected:
+		if ev.Err != nil {
+			c.ui.notice(time.Now(), fmt.Sprintf("lost the connection to %v: %v", ev.Addr, ev.Err))
+		} else {
+			c.ui.notice(time.Now(), "stream closed by server")
 		}
-
-		ts := res.Timestamp
-		var tm time.Time
-		t, err := ptypes.Timestamp(ts)
-		if err != nil {
-			tm = time.Now()
-		}
-		tm = t.In(time.Local)
-
-		switch evnt := res.Event.(type) {
-		case *chat.StreamResponse_ClientMessage:
-			fmt.Printf("[%v|%v] %v\n", tm, evnt.ClientMessage.Name, evnt.ClientMessage.Message)
-		case *chat.StreamResponse_ServerShutdown:
-			fmt.Printf("%v --- the server is shutting down\n", tm)
-		default:
-			fmt.Println("Default case of receive")
+	case chatclient.Reconnecting:
+		c.ui.notice(time.Now(), fmt.Sprintf("connecting to %v at %v", ev.Addr, ev.At.Format(time.Kitchen)))
+	case chatclient.Message:
+		c.ui.message(ev)
+	case chatclient.Kick:
+		c.ui.notice(ev.Time, fmt.Sprintf("%v was kicked out of %v by %v", ev.Name, ev.Room, ev.By))
+	case chatclient.Refused:
+		c.ui.notice(ev.Time, fmt.Sprintf("failed to send message %q: %v", ev.Text, ev.Reason))
+	case chatclient.Login:
+		c.ui.notice(ev.Time, fmt.Sprintf("%v joined", ev.Name))
+		go c.refresh()
+	case chatclient.Logout:
+		c.ui.notice(ev.Time, fmt.Sprintf("%v left", ev.Name))
+		go c.refresh()
+	case chatclient.Shutdown:
+		c.ui.notice(ev.Time, fmt.Sprintf("the server is shutting down: %v", ev.Reason))
+		if !ev.Deadline.IsZero() {
+			c.ui.notice(ev.Time, fmt.Sprintf("disconnecting by %v", ev.Deadline))
 		}
-
 	}
 }
 
-func (c *client) stream() {
-
-	md := metadata.New(map[string]string{tokenHeader: c.Token})
-	ctx := context.Background()
-	ctx = metadata.NewOutgoingContext(ctx, md)
-
-	client, err := c.ChatClient.Stream(ctx)
-	if err != nil {
-		log.Fatalf("Error on stream : %v", err)
+// chat keeps the user connected, waiting for a restarting server or failing over to another one, until they quit
+func (c *client) chat() error {
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+
+	sending := false
+	for ev := range c.Events() {
+		c.show(ev)
+		// The commands need a login, the lines typed before wait for it
+		if _, ok := ev.(chatclient.Connected); ok && !sending {
+			sending = true
+			go c.send(ctx, cancel)
+		}
 	}
-
-	defer client.CloseSend()
-	go c.send(client)
-	c.receive(client)
+	return <-done
 }
 
 func main() {
 
-	fmt.Println("Hello, I'm a client")
-	cc, err := grpc.Dial("localhost:50051", grpc.WithInsecure())
+	configPath := flag.String("config", defaultConfigPath(), "config file holding the profiles")
+	profileName := flag.String("profile", "", "profile of the config file to use, its default profile when empty")
+	serverList := flag.String("servers", defaultAddress, "comma separated addresses of the servers to connect to, in order")
+	username := flag.String("username", "", "name to chat as, asked for when neither set nor configured")
+	roomList := flag.String("rooms", "", "comma separated rooms to join, messages go to the first one")
+	themeName := flag.String("theme", "", "colours of the full screen interface: dark or light, the terminal colours when empty")
+	useTLS := flag.Bool("tls", false, "connect to the servers over TLS")
+	tlsCA := flag.String("tls.ca", "", "CA certificate to check the server certificate against, the system pool when empty")
+	tlsCert := flag.String("tls.cert", "", "client certificate, for servers asking for one")
+	tlsKey := flag.String("tls.key", "", "key of the client certificate")
+	tlsServerName := flag.String("tls.server-name", "", "name expected in the server certificate, the host of the address when empty")
+	traceExporter := flag.String("trace.exporter", tracing.ExporterNone, "where to export the traces: none, stdout or file")
+	traceFile := flag.String("trace.file", "client-traces.json", "file the traces are written to with the file exporter")
+	plain := flag.Bool("plain", false, "use the line mode instead of the full screen interface")
+	flag.Parse()
+
+	p, err := loadProfile(*configPath, *profileName)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// The flags set on the command line win over the profile
+	flag.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "servers":
+			servers := strings.Split(*serverList, ",")
+			p.Address, p.Alternates = servers[0], servers[1:]
+		case "username":
+			p.Username = *username
+		case "rooms":
+			p.Rooms = strings.Split(*roomList, ",")
+		case "theme":
+			p.Theme = *themeName
+		case "tls":
+			p.TLS.Enabled = *useTLS
+		case "tls.ca":
+			p.TLS.CA = *tlsCA
+		case "tls.cert":
+			p.TLS.Cert = *tlsCert
+		case "tls.key":
+			p.TLS.Key = *tlsKey
+		case "tls.server-name":
+			p.TLS.ServerName = *tlsServerName
+		}
+	})
+	th, ok := themes[p.Theme]
+	if !ok {
+		log.Fatalf("unknown theme %q", p.Theme)
+	}
+	creds, err := p.TLS.credentials()
 	if err != nil {
-		log.Fatalf("could not connect: %v", err)
+		log.Fatal(err)
 	}
 
-	defer cc.Close()
+	shutdownTracing, err := tracing.Setup("chat-client", *traceExporter, *traceFile)
+	if err != nil {
+		log.Fatalf("could not set up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	cfg := chatclient.Config{
+		Servers:  p.servers(),
+		Username: p.Username,
+		Rooms:    p.Rooms,
+		DialOptions: []grpc.DialOption{
+			creds,
+			grpc.WithUnaryInterceptor(tracing.UnaryClientInterceptor),
+			grpc.WithStreamInterceptor(tracing.StreamClientInterceptor),
+		},
+	}
+
+	if flag.NArg() > 0 {
+		command, ok := scriptCommands[flag.Arg(0)]
+		if !ok {
+			err = usageError("unknown command %q, the commands are send, tail and history", flag.Arg(0))
+		} else {
+			err = command(cfg, flag.Args()[1:])
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, status.Convert(err).Message())
+		}
+		shutdownTracing(context.Background())
+		os.Exit(exitCode(err))
+	}
 
-	c := Client()
-	c.ChatClient = chat.NewChatClient(cc)
+	fmt.Println("Hello, I'm a client")
 
 	reader := bufio.NewReader(os.Stdin)
-	fmt.Println("Enter your username:")
-	username, _ := reader.ReadString('\n')
-
-	c.Name = strings.Trim(username, "\n")
-	c.Token, err = c.login()
+	if cfg.Username == "" {
+		fmt.Println("Enter your username:")
+		username, _ := reader.ReadString('\n')
+		cfg.Username = strings.Trim(username, "\n")
+	}
+	cfg.Reconnect = true
+	sdk, err := chatclient.New(cfg)
 	if err != nil {
-		log.Fatalf("failed to login %v", err)
+		log.Fatal(err)
 	}
 
-	c.stream()
-	fmt.Println("Logging out..")
-	if err := c.logout(); err != nil {
-		log.Fatalf("Failed to logout.. %v", err)
+	c := &client{Client: sdk, lines: make(chan string)}
+	if *plain {
+		c.ui = newPlainUI(reader)
+	} else {
+		c.ui = newTUI(c.joinRoom, th)
 	}
 
+	go func() {
+		if err := c.ui.run(c.lines); err != nil {
+			log.Printf("the interface stopped: %v", err)
+		}
+	}()
+	err = c.chat()
+	c.ui.stop()
+	if err != nil {
+		log.Fatal(err)
+	}
 }
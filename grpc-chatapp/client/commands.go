@@ -0,0 +1,260 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+)
+
+const (
+	commandPrefix = "/"
+	// actionPrefix marks a message as an action, it is shown as "* name does something"
+	actionPrefix = "/me "
+)
+
+type command struct {
+	name  string
+	usage string
+	help  string
+	// minArgs and maxArgs bound the number of arguments, with rest the last argument takes the rest of the line
+	minArgs, maxArgs int
+	rest             bool
+	run              func(c *client, args []string) error
+}
+
+// commands is the registry of the slash commands, it is filled in init as /help lists it
+var commands map[string]*command
+
+func init() {
+
+	commands = make(map[string]*command)
+	for _, cmd := range []*command{
+		{name: "help", usage: "/help", help: "list the commands", run: help},
+		{name: "quit", usage: "/quit", help: "log out and leave", run: quit},
+		{name: "nick", usage: "/nick <name>", help: "change your name", minArgs: 1, maxArgs: 1, run: nick},
+		{name: "who", usage: "/who", help: "list the users online", run: who},
+		{name: "rooms", usage: "/rooms", help: "list the rooms", run: rooms},
+		{name: "join", usage: "/join <room>", help: "join a room and talk there", minArgs: 1, maxArgs: 1, run: join},
+		{name: "create", usage: "/create <room> [public|private|invite-only]", help: "create a room", minArgs: 1, maxArgs: 2, run: create},
+		{name: "invite", usage: "/invite <user> [room]", help: "invite a user to a room, the current one by default", minArgs: 1, maxArgs: 2, run: invite},
+		{name: "kick", usage: "/kick <user> [room]", help: "kick a user out of a room, the current one by default", minArgs: 1, maxArgs: 2, run: kick},
+		{name: "accept", usage: "/accept <room>", help: "accept an invite to a room", minArgs: 1, maxArgs: 1, run: accept},
+		{name: "msg", usage: "/msg <user> <text>", help: "send a message to the user alone", minArgs: 2, maxArgs: 2, rest: true, run: msg},
+		{name: "me", usage: "/me <action>", help: "tell the room what you are doing", minArgs: 1, maxArgs: 1, rest: true, run: me},
+	} {
+		commands[cmd.name] = cmd
+	}
+}
+
+// parse splits a line typed by the user into a command and its arguments. ok is false
+// for plain messages and the commands of the bots, which are sent as they are, "//" escapes
+// a message starting with a slash.
+func parse(line string, bots []*chat.Command) (cmd *command, args []string, ok bool, err error) {
+
+	if !strings.HasPrefix(line, commandPrefix) || strings.HasPrefix(line, commandPrefix+commandPrefix) {
+		return nil, nil, false, nil
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(line, commandPrefix))
+	if len(fields) == 0 {
+		return nil, nil, true, fmt.Errorf("missing command, try /help")
+	}
+	cmd, found := commands[fields[0]]
+	if !found {
+		for _, bot := range bots {
+			if bot.Command == fields[0] {
+				return nil, nil, false, nil
+			}
+		}
+		return nil, nil, true, fmt.Errorf("unknown command /%v, try /help", fields[0])
+	}
+
+	args = fields[1:]
+	if cmd.rest && len(args) > cmd.maxArgs {
+		// Keep the spacing of the free text
+		parts := strings.SplitN(strings.TrimSpace(line), " ", cmd.maxArgs+1)
+		args = append(args[:cmd.maxArgs-1], strings.TrimSpace(parts[cmd.maxArgs]))
+	}
+	if len(args) < cmd.minArgs || len(args) > cmd.maxArgs {
+		return nil, nil, true, fmt.Errorf("usage: %v", cmd.usage)
+	}
+	return cmd, args, true, nil
+}
+
+// complete completes the command, of the client or of a bot, or the user name being typed at the end of
+// text. It returns the completed text and, when there are several, the candidates.
+func complete(text string, users, bots []string) (string, []string) {
+
+	start := strings.LastIndex(text, " ") + 1
+	word := text[start:]
+
+	var candidates []string
+	if start == 0 && strings.HasPrefix(word, commandPrefix) {
+		names := append([]string(nil), bots...)
+		for name := range commands {
+			names = append(names, name)
+		}
+		for _, name := range names {
+			if strings.HasPrefix(commandPrefix+name, word) {
+				candidates = append(candidates, commandPrefix+name)
+			}
+		}
+	} else if word != "" {
+		for _, name := range users {
+			if strings.HasPrefix(name, word) {
+				candidates = append(candidates, name)
+			}
+		}
+	}
+	sort.Strings(candidates)
+
+	switch len(candidates) {
+	case 0:
+		return text, nil
+	case 1:
+		return text[:start] + candidates[0] + " ", nil
+	}
+	prefix := candidates[0]
+	for _, candidate := range candidates[1:] {
+		for !strings.HasPrefix(candidate, prefix) {
+			prefix = prefix[:len(prefix)-1]
+		}
+	}
+	return text[:start] + prefix, candidates
+}
+
+func help(c *client, _ []string) error {
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		c.ui.notice(time.Now(), fmt.Sprintf("%-45v %v", commands[name].usage, commands[name].help))
+	}
+	for _, bot := range c.botCommands() {
+		c.ui.notice(time.Now(), fmt.Sprintf("%-45v %v (%v)", commandPrefix+bot.Command, bot.Description, bot.Bot))
+	}
+	return nil
+}
+
+func quit(c *client, _ []string) error {
+	return errQuit
+}
+
+func nick(c *client, args []string) error {
+	c.Rename(args[0])
+	return nil
+}
+
+func who(c *client, _ []string) error {
+
+	users, err := c.ListUsers(context.Background())
+	if err != nil {
+		return err
+	}
+	c.ui.setUsers(users)
+	c.ui.notice(time.Now(), fmt.Sprintf("online: %v", strings.Join(users, ", ")))
+	return nil
+}
+
+func rooms(c *client, _ []string) error {
+
+	rooms, err := c.ListRooms(context.Background())
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, room := range rooms {
+		names = append(names, room.Name)
+	}
+	c.ui.setRooms(names, c.currentRoom())
+	c.ui.notice(time.Now(), fmt.Sprintf("rooms: %v", strings.Join(names, ", ")))
+	return nil
+}
+
+func join(c *client, args []string) error {
+	c.joinRoom(args[0])
+	return nil
+}
+
+func create(c *client, args []string) error {
+
+	visibility := chat.Visibility_PUBLIC
+	if len(args) > 1 {
+		v, ok := chat.Visibility_value[strings.ToUpper(strings.ReplaceAll(args[1], "-", "_"))]
+		if !ok {
+			return fmt.Errorf("usage: %v", commands["create"].usage)
+		}
+		visibility = chat.Visibility(v)
+	}
+
+	if err := c.CreateRoom(context.Background(), args[0], visibility); err != nil {
+		return err
+	}
+	c.setRoom(args[0])
+	c.ui.notice(time.Now(), fmt.Sprintf("now talking in %v", args[0]))
+	c.refresh()
+	return nil
+}
+
+func invite(c *client, args []string) error {
+
+	room := c.currentRoom()
+	if len(args) > 1 {
+		room = args[1]
+	}
+	if room == "" {
+		return fmt.Errorf("usage: %v", commands["invite"].usage)
+	}
+
+	if err := c.Invite(context.Background(), room, args[0]); err != nil {
+		return err
+	}
+	c.ui.notice(time.Now(), fmt.Sprintf("invited %v to %v", args[0], room))
+	return nil
+}
+
+func kick(c *client, args []string) error {
+
+	room := c.currentRoom()
+	if len(args) > 1 {
+		room = args[1]
+	}
+	if room == "" {
+		return fmt.Errorf("usage: %v", commands["kick"].usage)
+	}
+	return c.Kick(context.Background(), room, args[0])
+}
+
+func accept(c *client, args []string) error {
+
+	if err := c.AcceptInvite(context.Background(), args[0]); err != nil {
+		return err
+	}
+	c.setRoom(args[0])
+	c.ui.notice(time.Now(), fmt.Sprintf("now talking in %v", args[0]))
+	c.refresh()
+	return nil
+}
+
+func me(c *client, args []string) error {
+	return c.post(context.Background(), actionPrefix+args[0])
+}
+
+func msg(c *client, args []string) error {
+	return c.SendTo(context.Background(), args[0], args[1])
+}
+
+// action returns the text of an action message
+func action(message string) (string, bool) {
+	if !strings.HasPrefix(message, actionPrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(message, actionPrefix), true
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+)
+
+func TestParse(t *testing.T) {
+
+	tests := []struct {
+		line      string
+		command   string
+		args      []string
+		isCommand bool
+		wantErr   bool
+	}{
+		{line: "hello", isCommand: false},
+		{line: "//join is a command", isCommand: false},
+		{line: "/quit", command: "quit", isCommand: true},
+		{line: "/join  general ", command: "join", args: []string{"general"}, isCommand: true},
+		{line: "/join", isCommand: true, wantErr: true},
+		{line: "/join a b", isCommand: true, wantErr: true},
+		{line: "/create secret private", command: "create", args: []string{"secret", "private"}, isCommand: true},
+		{line: "/me waves  at   everyone", command: "me", args: []string{"waves  at   everyone"}, isCommand: true},
+		{line: "/me", isCommand: true, wantErr: true},
+		{line: "/msg bob see you  at noon", command: "msg", args: []string{"bob", "see you  at noon"}, isCommand: true},
+		{line: "/msg bob", isCommand: true, wantErr: true},
+		{line: "/dance", isCommand: true, wantErr: true},
+		{line: "/roll 2d6", isCommand: false},
+		{line: "/", isCommand: true, wantErr: true},
+	}
+
+	bots := []*chat.Command{{Command: "roll", Description: "roll dice", Bot: "dicebot"}}
+	for _, tt := range tests {
+		cmd, args, isCommand, err := parse(tt.line, bots)
+		if isCommand != tt.isCommand || (err != nil) != tt.wantErr {
+			t.Errorf("parse(%q) isCommand = %v, error = %v, want %v, error %v", tt.line, isCommand, err, tt.isCommand, tt.wantErr)
+			continue
+		}
+		if tt.command == "" {
+			if cmd != nil {
+				t.Errorf("parse(%q) command = /%v, want none", tt.line, cmd.name)
+			}
+			continue
+		}
+		if cmd == nil || cmd.name != tt.command || (len(args) > 0 || len(tt.args) > 0) && !reflect.DeepEqual(args, tt.args) {
+			t.Errorf("parse(%q) = %v %q, want /%v %q", tt.line, cmd, args, tt.command, tt.args)
+		}
+	}
+}
+
+func TestComplete(t *testing.T) {
+
+	users := []string{"alice", "albert", "bob"}
+	tests := []struct {
+		text       string
+		want       string
+		candidates []string
+	}{
+		{"/qu", "/quit ", nil},
+		{"/ro", "/ro", []string{"/roll", "/rooms"}},
+		{"/i", "/invite ", nil},
+		{"/", "/", []string{"/accept", "/create", "/help", "/invite", "/join", "/kick", "/me", "/msg", "/nick", "/quit", "/roll", "/rooms", "/who"}},
+		{"hi b", "hi bob ", nil},
+		{"hi al", "hi al", []string{"albert", "alice"}},
+		{"hi ali", "hi alice ", nil},
+		{"/invite bo", "/invite bob ", nil},
+		{"hi carol", "hi carol", nil},
+		{"", "", nil},
+	}
+
+	for _, tt := range tests {
+		got, candidates := complete(tt.text, users, []string{"roll"})
+		if got != tt.want || !reflect.DeepEqual(candidates, tt.candidates) {
+			t.Errorf("complete(%q) = %q, %v, want %q, %v", tt.text, got, candidates, tt.want, tt.candidates)
+		}
+	}
+}
+
+func TestMsg(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	alice, bob, carol := s.Client("alice"), s.Client("bob"), s.Client("carol")
+
+	cmd, args, _, err := parse("/msg bob see you at noon", nil)
+	if err != nil {
+		t.Fatalf("parse() error = %v", err)
+	}
+	if err := cmd.run(&client{Client: alice.Client}, args); err != nil {
+		t.Fatalf("/msg error = %v", err)
+	}
+	// The message goes to bob and back to alice, carol only gets what comes next
+	for _, c := range []*chattest.Client{bob, alice} {
+		got := c.Next(chatclient.Message{}).(chatclient.Message)
+		if got.Name != "alice" || got.To != "bob" || got.Room != "" || got.Text != "see you at noon" {
+			t.Fatalf("%v received %+v, want the direct message of alice to bob", c.Name(), got)
+		}
+	}
+	if err := alice.Send(context.Background(), "", "hello all"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := carol.Next(chatclient.Message{}).(chatclient.Message); got.To != "" || got.Text != "hello all" {
+		t.Fatalf("carol received %+v, want the message to the lobby", got)
+	}
+}
+
+func TestBotCommands(t *testing.T) {
+
+	const diceKey = "dice-0123456789abcdef"
+	s := chattest.NewServer(t, chatserver.Options{Bots: []chatserver.Bot{{Name: "dice", APIKey: diceKey}}})
+	ctx := context.Background()
+	req := &chat.RegisterCommandRequest{Token: diceKey, Command: "roll", Description: "roll dice"}
+	if _, err := chat.NewChatClient(s.Dial()).RegisterCommand(ctx, req); err != nil {
+		t.Fatalf("RegisterCommand() error = %v", err)
+	}
+	alice := s.Client("alice")
+	c := &client{Client: alice.Client, ui: newPlainUI(nil)}
+	c.refresh()
+
+	line := "/roll 2d6"
+	if _, _, isCommand, err := parse(line, c.botCommands()); isCommand || err != nil {
+		t.Fatalf("parse(%q) isCommand = %v, error = %v, want a message", line, isCommand, err)
+	}
+	// The server hands the line over to the bot, which tells alice it is offline
+	if err := c.post(ctx, line); err != nil {
+		t.Fatalf("post() error = %v", err)
+	}
+	if got := alice.Next(chatclient.Message{}).(chatclient.Message); got.Name != "dice" || got.To != "alice" {
+		t.Fatalf("alice received %+v, want the answer of dice", got)
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"gopkg.in/yaml.v2"
+)
+
+const defaultAddress = "localhost:50051"
+
+// configFile holds the named profiles of the client, e.g.
+//
+//	default: work
+//	profiles:
+//	  work:
+//	    address: chat.example.com:50051
+//	    alternates: [chat-backup.example.com:50051]
+//	    tls:
+//	      enabled: true
+//	      ca: /etc/ssl/chat-ca.pem
+//	    username: alice
+//	    rooms: [ops, lobby]
+//	    theme: light
+type configFile struct {
+	Default  string              `yaml:"default"`
+	Profiles map[string]*profile `yaml:"profiles"`
+}
+
+// profile is the settings to connect to one chat, the flags override them
+type profile struct {
+	Address string `yaml:"address"`
+	// Alternates are the servers to fail over to, in order
+	Alternates []string  `yaml:"alternates"`
+	TLS        tlsConfig `yaml:"tls"`
+	Username   string    `yaml:"username"`
+	// Rooms are joined after every login, the first one is where the messages go
+	Rooms []string `yaml:"rooms"`
+	Theme string   `yaml:"theme"`
+}
+
+type tlsConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// CA is the certificate the server certificate is checked against, the system pool by default
+	CA string `yaml:"ca"`
+	// Cert and Key are the client certificate, for servers asking for one
+	Cert               string `yaml:"cert"`
+	Key                string `yaml:"key"`
+	ServerName         string `yaml:"server_name"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// defaultConfigPath is ~/.config/grpc-chat/config
+func defaultConfigPath() string {
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".config", "grpc-chat", "config")
+}
+
+// loadProfile reads the profile called name from the config file at path, or its default profile when
+// name is empty. A missing config file is only an error when a profile is asked for.
+func loadProfile(path, name string) (*profile, error) {
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && name == "" {
+		return &profile{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read the config: %v", err)
+	}
+
+	var cfg configFile
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		return nil, fmt.Errorf("could not parse %v: %v", path, err)
+	}
+	if name == "" {
+		name = cfg.Default
+	}
+	if name == "" {
+		return &profile{}, nil
+	}
+	p, ok := cfg.Profiles[name]
+	if !ok || p == nil {
+		return nil, fmt.Errorf("no profile %q in %v", name, path)
+	}
+	if _, ok := themes[p.Theme]; !ok {
+		return nil, fmt.Errorf("profile %q: unknown theme %q", name, p.Theme)
+	}
+	return p, nil
+}
+
+// servers lists the addresses to connect to, in order
+func (p *profile) servers() []string {
+
+	address := p.Address
+	if address == "" {
+		address = defaultAddress
+	}
+	return append([]string{address}, p.Alternates...)
+}
+
+// credentials returns how to secure the connections to the servers
+func (t tlsConfig) credentials() (grpc.DialOption, error) {
+
+	if !t.Enabled {
+		return grpc.WithInsecure(), nil
+	}
+
+	cfg := &tls.Config{
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+	if t.CA != "" {
+		pem, err := ioutil.ReadFile(t.CA)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the CA: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", t.CA)
+		}
+	}
+	if t.Cert != "" || t.Key != "" {
+		cert, err := tls.LoadX509KeyPair(t.Cert, t.Key)
+		if err != nil {
+			return nil, fmt.Errorf("could not load the client certificate: %v", err)
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(cfg)), nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const testConfig = `
+default: home
+profiles:
+  home:
+    username: alice
+  work:
+    address: chat.example.com:50051
+    alternates: [backup.example.com:50051]
+    tls:
+      enabled: true
+      server_name: chat.example.com
+    username: alice.smith
+    rooms: [ops, lobby]
+    theme: light
+`
+
+func writeConfig(t *testing.T, content string) string {
+
+	dir, err := ioutil.TempDir("", "grpc-chat")
+	if err != nil {
+		t.Fatalf("TempDir() error = %v", err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	path := filepath.Join(dir, "config")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatalf("WriteFile() error = %v", err)
+	}
+	return path
+}
+
+func TestLoadProfile(t *testing.T) {
+
+	path := writeConfig(t, testConfig)
+	missing := filepath.Join(filepath.Dir(path), "missing")
+	tests := []struct {
+		name    string
+		path    string
+		profile string
+		want    *profile
+		wantErr bool
+	}{
+		{name: "default profile", path: path, want: &profile{Username: "alice"}},
+		{name: "named profile", path: path, profile: "work", want: &profile{
+			Address:    "chat.example.com:50051",
+			Alternates: []string{"backup.example.com:50051"},
+			TLS:        tlsConfig{Enabled: true, ServerName: "chat.example.com"},
+			Username:   "alice.smith",
+			Rooms:      []string{"ops", "lobby"},
+			Theme:      "light",
+		}},
+		{name: "unknown profile", path: path, profile: "school", wantErr: true},
+		{name: "no config", path: missing, want: &profile{}},
+		{name: "profile without config", path: missing, profile: "work", wantErr: true},
+		{name: "unknown field", path: writeConfig(t, "profiles:\n  home:\n    colour: red\n"), profile: "home", wantErr: true},
+		{name: "unknown theme", path: writeConfig(t, "profiles:\n  home:\n    theme: neon\n"), profile: "home", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := loadProfile(tt.path, tt.profile)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%v: loadProfile() error = %v, wantErr %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: loadProfile() = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestProfileServers(t *testing.T) {
+
+	if got, want := (&profile{}).servers(), []string{defaultAddress}; !reflect.DeepEqual(got, want) {
+		t.Errorf("servers() = %v, want %v", got, want)
+	}
+	p := &profile{Address: "a:1", Alternates: []string{"b:1", "c:1"}}
+	if got, want := p.servers(), []string{"a:1", "b:1", "c:1"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("servers() = %v, want %v", got, want)
+	}
+}
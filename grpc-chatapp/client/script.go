@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// sendRetries is how many times send posts again a message refused by the rate limit of the server, waiting
+// sendBackoff before the first retry and twice as long before every other one
+const sendRetries = 6
+
+var sendBackoff = 250 * time.Millisecond
+
+// scriptCommands are the non interactive subcommands, meant for scripts and pipes.
+// They write their output to stdout and exit with the code of the gRPC status they failed with.
+var scriptCommands = map[string]func(cfg chatclient.Config, args []string) error{
+	"send":    sendCommand,
+	"tail":    tailCommand,
+	"history": historyCommand,
+}
+
+// exitCode maps the error of a subcommand to the exit code, the number of its gRPC status code
+func exitCode(err error) int {
+	return int(status.Code(err))
+}
+
+func usageError(format string, a ...interface{}) error {
+	return status.Errorf(codes.InvalidArgument, format, a...)
+}
+
+// connect logs in to the first server that is available and joins the room the subcommand works on,
+// the configured rooms are joined anyway. The returned function logs out and returns the error of the client.
+func connect(cfg chatclient.Config, room string) (*chatclient.Client, func() error, error) {
+
+	if cfg.Username == "" {
+		return nil, nil, usageError("a username is required, set -username or configure one in the profile")
+	}
+	c, err := chatclient.New(cfg)
+	if err != nil {
+		return nil, nil, usageError("%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- c.Run(ctx) }()
+	stop := func() error {
+		cancel()
+		for range c.Events() {
+		}
+		return <-done
+	}
+
+	for ev := range c.Events() {
+		if _, ok := ev.(chatclient.Connected); !ok {
+			continue
+		}
+		if room != "" {
+			if err := c.Join(context.Background(), room); err != nil {
+				stop()
+				return nil, nil, err
+			}
+		}
+		return c, stop, nil
+	}
+	if err := <-done; err != nil {
+		return nil, nil, err
+	}
+	return nil, nil, status.Error(codes.Unavailable, "no server to connect to")
+}
+
+// defaultRoom is the room a subcommand works on when none is given, the first configured room
+func defaultRoom(cfg chatclient.Config, room string) string {
+	if room == "" && len(cfg.Rooms) > 0 {
+		return cfg.Rooms[0]
+	}
+	return room
+}
+
+// printEvent writes the event as a line of JSON
+func printEvent(w io.Writer, res *chat.StreamResponse) error {
+
+	b, err := protojson.Marshal(res)
+	if err != nil {
+		return status.Errorf(codes.Internal, "could not encode the event: %v", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// sendCommand posts the message given as arguments, or every line read from stdin, and stops at the first
+// message the server refuses. The messages sent too fast are posted again once the limit lets them through.
+func sendCommand(cfg chatclient.Config, args []string) error {
+
+	fs := flag.NewFlagSet("send", flag.ContinueOnError)
+	room := fs.String("room", "", "room to post to, the first configured room by default")
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+
+	lines := make(chan string)
+	if fs.NArg() > 0 {
+		go func() {
+			lines <- strings.Join(fs.Args(), " ")
+			close(lines)
+		}()
+	} else {
+		go func() {
+			scanner := bufio.NewScanner(os.Stdin)
+			for scanner.Scan() {
+				if line := scanner.Text(); line != "" {
+					lines <- line
+				}
+			}
+			close(lines)
+		}()
+	}
+
+	target := defaultRoom(cfg, *room)
+	c, stop, err := connect(cfg, target)
+	if err != nil {
+		return err
+	}
+	// Nobody reads the events, drop them so that the client never waits on them
+	go func() {
+		for range c.Events() {
+		}
+	}()
+
+	// Post returns once the server took the message in, or with the reason it refused it
+	for line := range lines {
+		if err := post(c, target, line); err != nil {
+			stop()
+			return err
+		}
+	}
+	return stop()
+}
+
+// post posts the line, backing off while the server refuses it for going over the rate limit
+func post(c *chatclient.Client, room, line string) error {
+
+	backoff := sendBackoff
+	for i := 0; ; i++ {
+		err := c.Post(context.Background(), room, line)
+		if status.Code(err) != codes.ResourceExhausted || i == sendRetries {
+			return err
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// tailCommand writes the events of the chat as JSON lines until it is interrupted
+func tailCommand(cfg chatclient.Config, args []string) error {
+
+	fs := flag.NewFlagSet("tail", flag.ContinueOnError)
+	room := fs.String("room", "", "only write the messages of this room")
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	c, stop, err := connect(cfg, defaultRoom(cfg, *room))
+	if err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-signals:
+			stop()
+			return nil
+		case ev, ok := <-c.Events():
+			if !ok {
+				return stop()
+			}
+			if msg, isMessage := ev.(chatclient.Message); isMessage && *room != "" && msg.Room != *room {
+				continue
+			}
+			server, fromServer := ev.(interface{ Response() *chat.StreamResponse })
+			if !fromServer {
+				continue
+			}
+			if err := printEvent(os.Stdout, server.Response()); err != nil {
+				stop()
+				return err
+			}
+		}
+	}
+}
+
+// historyCommand writes the past messages of a room as JSON lines, oldest first
+func historyCommand(cfg chatclient.Config, args []string) error {
+
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	room := fs.String("room", "", "room to read, the first configured room or the lobby by default")
+	limit := fs.Int("limit", 0, "number of messages to write, all the messages the server kept when 0")
+	if err := fs.Parse(args); err != nil {
+		return usageError("%v", err)
+	}
+
+	target := defaultRoom(cfg, *room)
+	c, stop, err := connect(cfg, target)
+	if err != nil {
+		return err
+	}
+	defer stop()
+
+	messages, err := c.History(context.Background(), target, *limit)
+	if err != nil {
+		return err
+	}
+	for _, msg := range messages {
+		if err := printEvent(os.Stdout, msg.Response()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestExitCode(t *testing.T) {
+
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, 0},
+		{usageError("bad flag"), 3},
+		{status.Error(codes.NotFound, "room not found"), 5},
+		{status.Error(codes.Unavailable, "the server closed the stream"), 14},
+		{errors.New("something else"), 2},
+	}
+
+	for _, tt := range tests {
+		if got := exitCode(tt.err); got != tt.want {
+			t.Errorf("exitCode(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestSendCommand(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	owner := s.Client("alice")
+	ctx, cancel := context.WithTimeout(context.Background(), chattest.Timeout)
+	defer cancel()
+	if err := owner.GrantRole(ctx, "", "guest", chat.Role_GUEST); err != nil {
+		t.Fatalf("GrantRole() error = %v", err)
+	}
+
+	tests := []struct {
+		username string
+		args     []string
+		want     int
+	}{
+		{"ci", []string{"-room", "lobby", "build passed"}, 0},
+		{"ci", []string{"-room", "missing", "build passed"}, int(codes.NotFound)},
+		{"guest", []string{"build passed"}, int(codes.PermissionDenied)},
+	}
+	for _, tt := range tests {
+		cfg := chatclient.Config{Servers: []string{chattest.Addr}, Username: tt.username, DialOptions: s.DialOptions()}
+		if got := exitCode(sendCommand(cfg, tt.args)); got != tt.want {
+			t.Errorf("send %v as %v exit code = %v, want %v", tt.args, tt.username, got, tt.want)
+		}
+	}
+	if got := owner.Next(chatclient.Message{}).(chatclient.Message); got.Name != "ci" || got.Text != "build passed" {
+		t.Fatalf("alice received %q from %v, want the message of ci", got.Text, got.Name)
+	}
+}
+
+func TestSendCommandBacksOff(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{RateLimit: chatserver.RateLimit{PerSecond: 10, Burst: 1}})
+	reader := s.Client("alice")
+	defer func(d time.Duration) { sendBackoff = d }(sendBackoff)
+	sendBackoff = 10 * time.Millisecond
+
+	// Every message after the first one goes over the limit at first
+	cfg := chatclient.Config{Servers: []string{chattest.Addr}, Username: "ci", DialOptions: s.DialOptions()}
+	for i := 0; i < 3; i++ {
+		if err := sendCommand(cfg, []string{"-room", "lobby", fmt.Sprint("build ", i)}); err != nil {
+			t.Fatalf("send #%d error = %v", i, err)
+		}
+	}
+	for i := 0; i < 3; i++ {
+		want := fmt.Sprint("build ", i)
+		if got := reader.Next(chatclient.Message{}).(chatclient.Message); got.Text != want {
+			t.Fatalf("alice received %q, want %q", got.Text, want)
+		}
+	}
+}
@@ -0,0 +1,240 @@
+package main
+
+import (
+	"fmt"
+	"hash/fnv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+)
+
+const historySize = 100
+
+// userColours are the colours usernames are painted with, a user always gets the same one
+var userColours = []string{"red", "green", "yellow", "blue", "fuchsia", "aqua", "orange", "lime", "violet", "teal"}
+
+// theme is the colours of the full screen interface, muted is the colour of the timestamps and notices
+type theme struct {
+	background, text, border tcell.Color
+	muted                    string
+}
+
+// themes are the themes a profile can pick, the empty name is the default one
+var themes = map[string]theme{
+	"":      {background: tcell.ColorDefault, text: tcell.ColorDefault, border: tcell.ColorWhite, muted: "gray"},
+	"dark":  {background: tcell.ColorBlack, text: tcell.ColorWhite, border: tcell.ColorWhite, muted: "gray"},
+	"light": {background: tcell.ColorWhite, text: tcell.ColorBlack, border: tcell.ColorNavy, muted: "darkgray"},
+}
+
+func userColour(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return userColours[h.Sum32()%uint32(len(userColours))]
+}
+
+// tui is the full screen mode: a scrolling message pane, a sidebar with the rooms and
+// the users, and an input line with editing and history
+type tui struct {
+	app      *tview.Application
+	messages *tview.TextView
+	rooms    *tview.List
+	users    *tview.List
+	input    *tview.InputField
+	// selectRoom is called when the user picks a room in the sidebar
+	selectRoom func(room string)
+
+	// history, userNames and botCommands are only touched from the ui goroutine
+	history     []string
+	position    int
+	userNames   []string
+	botCommands []string
+	muted       string
+
+	// senders hand the typed lines over to the client until done is closed
+	senders sync.WaitGroup
+	done    chan struct{}
+}
+
+func newTUI(selectRoom func(room string), th theme) *tui {
+
+	// The primitives pick their colours from the styles when they are created
+	tview.Styles.PrimitiveBackgroundColor = th.background
+	tview.Styles.PrimaryTextColor = th.text
+	tview.Styles.BorderColor = th.border
+	tview.Styles.TitleColor = th.border
+
+	t := &tui{
+		app:        tview.NewApplication(),
+		messages:   tview.NewTextView(),
+		rooms:      tview.NewList(),
+		users:      tview.NewList(),
+		input:      tview.NewInputField(),
+		selectRoom: selectRoom,
+		muted:      th.muted,
+		done:       make(chan struct{}),
+	}
+
+	t.messages.SetDynamicColors(true).SetScrollable(true).SetWordWrap(true)
+	t.messages.SetBorder(true).SetTitle(" messages ")
+	t.messages.SetChangedFunc(func() {
+		t.messages.ScrollToEnd()
+	})
+	t.rooms.ShowSecondaryText(false).SetBorder(true).SetTitle(" rooms ")
+	t.users.ShowSecondaryText(false).SetBorder(true).SetTitle(" users ")
+	t.input.SetLabel("> ").SetFieldBackgroundColor(tcell.ColorDefault)
+
+	sidebar := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(t.rooms, 0, 1, false).
+		AddItem(t.users, 0, 2, false)
+	body := tview.NewFlex().
+		AddItem(t.messages, 0, 4, false).
+		AddItem(sidebar, 24, 0, false)
+	layout := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(body, 0, 1, false).
+		AddItem(t.input, 1, 0, true)
+
+	// Ctrl-R moves the focus to the rooms, Tab or Esc brings it back to the input line
+	t.app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch {
+		case event.Key() == tcell.KeyCtrlR:
+			t.app.SetFocus(t.rooms)
+		case !t.input.HasFocus() && (event.Key() == tcell.KeyTab || event.Key() == tcell.KeyEscape):
+			t.app.SetFocus(t.input)
+		default:
+			return event
+		}
+		return nil
+	})
+	t.app.SetRoot(layout, true)
+	return t
+}
+
+func (t *tui) run(lines chan<- string) error {
+
+	defer func() {
+		close(t.done)
+		t.senders.Wait()
+		close(lines)
+	}()
+
+	t.input.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyUp:
+			if t.position > 0 {
+				t.position--
+				t.input.SetText(t.history[t.position])
+			}
+			return nil
+		case tcell.KeyTab:
+			text, candidates := complete(t.input.GetText(), t.userNames, t.botCommands)
+			t.input.SetText(text)
+			if len(candidates) > 0 {
+				fmt.Fprintf(t.messages, "[%v]%v[-]\n", t.muted, tview.Escape(strings.Join(candidates, " ")))
+			}
+			return nil
+		case tcell.KeyDown:
+			if t.position < len(t.history)-1 {
+				t.position++
+				t.input.SetText(t.history[t.position])
+			} else {
+				t.position = len(t.history)
+				t.input.SetText("")
+			}
+			return nil
+		}
+		return event
+	})
+	t.input.SetDoneFunc(func(key tcell.Key) {
+		if key != tcell.KeyEnter {
+			return
+		}
+		line := t.input.GetText()
+		if line == "" {
+			return
+		}
+		t.input.SetText("")
+		t.history = append(t.history, line)
+		if len(t.history) > historySize {
+			t.history = t.history[1:]
+		}
+		t.position = len(t.history)
+		// Hand the line over without blocking the ui, the client only reads while connected
+		t.senders.Add(1)
+		go func() {
+			defer t.senders.Done()
+			select {
+			case lines <- line:
+			case <-t.done:
+			}
+		}()
+	})
+	t.rooms.SetSelectedFunc(func(index int, room string, _ string, _ rune) {
+		go t.selectRoom(room)
+		t.app.SetFocus(t.input)
+	})
+
+	return t.app.Run()
+}
+
+func (t *tui) stop() {
+	t.app.Stop()
+}
+
+func (t *tui) message(msg chatclient.Message) {
+
+	// The direct messages show who they went to in place of the room
+	where := "#" + msg.Room
+	if msg.To != "" {
+		where = fmt.Sprintf("[%v]@%v[-]", userColour(msg.To), tview.Escape(msg.To))
+	}
+	t.app.QueueUpdateDraw(func() {
+		if text, ok := action(msg.Text); ok {
+			fmt.Fprintf(t.messages, "[%v]%v[-] %v * [%v]%v[-] %v\n",
+				t.muted, msg.Time.Format("15:04:05"), where, userColour(msg.Name), tview.Escape(msg.Name), tview.Escape(text))
+			return
+		}
+		fmt.Fprintf(t.messages, "[%v]%v[-] %v [%v]%v[-]: %v\n",
+			t.muted, msg.Time.Format("15:04:05"), where, userColour(msg.Name), tview.Escape(msg.Name), tview.Escape(msg.Text))
+	})
+}
+
+func (t *tui) notice(tm time.Time, text string) {
+
+	t.app.QueueUpdateDraw(func() {
+		fmt.Fprintf(t.messages, "[%v]%v --- %v[-]\n", t.muted, tm.Format("15:04:05"), tview.Escape(text))
+	})
+}
+
+func (t *tui) setRooms(rooms []string, current string) {
+
+	t.app.QueueUpdateDraw(func() {
+		t.rooms.Clear()
+		for i, room := range rooms {
+			t.rooms.AddItem(room, "", 0, nil)
+			if room == current {
+				t.rooms.SetCurrentItem(i)
+			}
+		}
+	})
+}
+
+func (t *tui) setCommands(commands []string) {
+	t.app.QueueUpdateDraw(func() {
+		t.botCommands = commands
+	})
+}
+
+func (t *tui) setUsers(users []string) {
+
+	t.app.QueueUpdateDraw(func() {
+		t.userNames = users
+		t.users.Clear()
+		for _, name := range users {
+			t.users.AddItem(fmt.Sprintf("[%v]%v[-]", userColour(name), tview.Escape(name)), "", 0, nil)
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+)
+
+// ui shows the chat to the user and collects what they type
+type ui interface {
+	// run shows the ui until the user quits, sending the typed lines to lines and closing it on quit
+	run(lines chan<- string) error
+	// stop closes the ui once the client is done
+	stop()
+	message(msg chatclient.Message)
+	notice(tm time.Time, text string)
+	setRooms(rooms []string, current string)
+	setUsers(users []string)
+	// setCommands sets the commands of the bots, without their slash
+	setCommands(commands []string)
+}
+
+// plainUI is the line mode: it reads lines from stdin and prints the events to stdout
+type plainUI struct {
+	reader *bufio.Reader
+}
+
+func newPlainUI(reader *bufio.Reader) *plainUI {
+	return &plainUI{reader: reader}
+}
+
+func (p *plainUI) run(lines chan<- string) error {
+
+	defer close(lines)
+	for {
+		txt, err := p.reader.ReadString('\n')
+		if message := strings.Trim(txt, "\n"); message != "" {
+			lines <- message
+		}
+		if err != nil {
+			return nil
+		}
+	}
+}
+
+func (p *plainUI) stop() {}
+
+func (p *plainUI) message(msg chatclient.Message) {
+	// The direct messages show who they went to
+	name := msg.Name
+	if msg.To != "" {
+		name += " -> " + msg.To
+	}
+	if text, ok := action(msg.Text); ok {
+		fmt.Printf("[%v] * %v %v\n", msg.Time, name, text)
+		return
+	}
+	fmt.Printf("[%v|%v] %v\n", msg.Time, name, msg.Text)
+}
+
+func (p *plainUI) notice(tm time.Time, text string) {
+	fmt.Printf("%v --- %v\n", tm, text)
+}
+
+func (p *plainUI) setRooms(rooms []string, current string) {}
+
+func (p *plainUI) setUsers(users []string) {}
+
+func (p *plainUI) setCommands(commands []string) {}
@@ -0,0 +1,139 @@
+// dicebot is an example bot: it answers /echo with the text it was given and /roll with dice rolls,
+// e.g. "/roll 2d6". Its account has to be set up on the server with -bots.config.
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/bot"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+)
+
+const (
+	apiKeyEnv = "CHAT_API_KEY"
+	// maxDice and maxSides keep the replies short
+	maxDice  = 100
+	maxSides = 1000
+)
+
+// roll throws the dice of the NdM notation, one six-sided die when empty, and describes the result
+func roll(r *rand.Rand, notation string) (string, error) {
+
+	if notation == "" {
+		notation = "1d6"
+	}
+	i := strings.IndexAny(notation, "dD")
+	if i < 0 {
+		return "", errors.New("dice are written NdM, e.g. 2d6")
+	}
+	n, m := notation[:i], notation[i+1:]
+	if n == "" {
+		n = "1"
+	}
+	dice, err := strconv.Atoi(n)
+	if err != nil || dice < 1 || dice > maxDice {
+		return "", fmt.Errorf("the number of dice goes from 1 to %d", maxDice)
+	}
+	sides, err := strconv.Atoi(m)
+	if err != nil || sides < 2 || sides > maxSides {
+		return "", fmt.Errorf("the number of sides goes from 2 to %d", maxSides)
+	}
+
+	rolls := make([]string, dice)
+	total := 0
+	for i := range rolls {
+		v := r.Intn(sides) + 1
+		total += v
+		rolls[i] = strconv.Itoa(v)
+	}
+	if dice == 1 {
+		return strconv.Itoa(total), nil
+	}
+	return fmt.Sprintf("%v = %d", strings.Join(rolls, " + "), total), nil
+}
+
+// dialCredentials returns how to secure the connections to the servers
+func dialCredentials(useTLS bool, ca, serverName string) (grpc.DialOption, error) {
+
+	if !useTLS {
+		return grpc.WithInsecure(), nil
+	}
+	cfg := &tls.Config{ServerName: serverName}
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("could not read the CA: %v", err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %v", ca)
+		}
+	}
+	return grpc.WithTransportCredentials(credentials.NewTLS(cfg)), nil
+}
+
+func main() {
+
+	serverList := flag.String("servers", "localhost:50051", "comma separated addresses of the servers to connect to, in order")
+	apiKey := flag.String("api-key", "", "API key of the bot account, $"+apiKeyEnv+" when empty")
+	useTLS := flag.Bool("tls", false, "connect to the servers over TLS")
+	tlsCA := flag.String("tls.ca", "", "CA certificate to check the server certificate against, the system pool when empty")
+	tlsServerName := flag.String("tls.server-name", "", "name expected in the server certificate, the host of the address when empty")
+	flag.Parse()
+
+	logger := log.With(log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr)), "ts", log.DefaultTimestampUTC)
+	if *apiKey == "" {
+		*apiKey = os.Getenv(apiKeyEnv)
+	}
+	creds, err := dialCredentials(*useTLS, *tlsCA, *tlsServerName)
+	if err != nil {
+		level.Error(logger).Log("message", "invalid TLS configuration", "err", err)
+		os.Exit(1)
+	}
+	b, err := bot.New(bot.Config{
+		Servers:     strings.Split(*serverList, ","),
+		APIKey:      *apiKey,
+		DialOptions: []grpc.DialOption{creds},
+		Logger:      logger,
+	})
+	if err != nil {
+		level.Error(logger).Log("message", "invalid configuration", "err", err)
+		os.Exit(1)
+	}
+
+	b.Command("echo", "repeats the text after the command", func(ctx context.Context, inv bot.Invocation) string {
+		return inv.Args
+	})
+	// The handlers run one at a time, they can share the source
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	b.Command("roll", "rolls dice, e.g. /roll 2d6", func(ctx context.Context, inv bot.Invocation) string {
+		res, err := roll(r, inv.Args)
+		if err != nil {
+			return fmt.Sprintf("%v: %v", inv.Name, err)
+		}
+		return fmt.Sprintf("%v rolled %v", inv.Name, res)
+	})
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if err := b.Run(ctx); err != nil {
+		level.Error(logger).Log("message", "the server refused the bot", "err", err)
+		os.Exit(1)
+	}
+}
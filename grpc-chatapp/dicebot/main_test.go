@@ -0,0 +1,42 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestRoll(t *testing.T) {
+
+	tests := []struct {
+		notation string
+		ok       bool
+	}{
+		{"", true},
+		{"2d6", true},
+		{"d20", true},
+		{"3D4", true},
+		{"six", false},
+		{"0d6", false},
+		{"101d6", false},
+		{"2d1", false},
+		{"2dx", false},
+	}
+	r := rand.New(rand.NewSource(1))
+	for _, tt := range tests {
+		got, err := roll(r, tt.notation)
+		if (err == nil) != tt.ok {
+			t.Errorf("roll(%q) = %q, %v, want ok %v", tt.notation, got, err, tt.ok)
+		}
+	}
+
+	// Every die lands between 1 and its number of sides
+	for i := 0; i < 100; i++ {
+		if got, _ := roll(r, "1d2"); got != "1" && got != "2" {
+			t.Fatalf("roll(1d2) = %q", got)
+		}
+	}
+	if got, _ := roll(rand.New(rand.NewSource(1)), "3d1000"); !strings.Contains(got, " + ") || !strings.Contains(got, " = ") {
+		t.Errorf("roll(3d1000) = %q, want the dice and their total", got)
+	}
+}
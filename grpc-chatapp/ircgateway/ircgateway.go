@@ -0,0 +1,634 @@
+// Package ircgateway lets IRC clients chat. A connection logs in to the chat with its nickname once it is
+// registered, the channels are the rooms of the chat, e.g. #lobby for the lobby, and a PRIVMSG to a nickname
+// is a direct message. The users of the other clients show up under their chat names.
+//
+// The commands understood are NICK, USER, JOIN, PART, PRIVMSG, NAMES, WHO and QUIT, along with PING and CAP
+// so that the clients keep the connection alive and go on with the registration. Joining a channel that does
+// not exist creates a public room when the role of the user allows it. The chat does not track who is in a
+// room, NAMES and WHO list the users logged in to the chat.
+package ircgateway
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// serverName prefixes the replies of the gateway and is the host of every user
+	serverName = "chat"
+	// maxLineSize bounds the lines read from the clients, some send more than the 512 bytes of the RFC
+	maxLineSize = 4096
+	// registrationTimeout is how long the clients get to send NICK and USER
+	registrationTimeout = 30 * time.Second
+	writeWait           = 10 * time.Second
+	// logoutTimeout bounds the Logout call made when a client goes away
+	logoutTimeout = 5 * time.Second
+)
+
+// ErrClosed is returned by Serve once the server is closed
+var ErrClosed = errors.New("ircgateway: server closed")
+
+// Server serves the chat to IRC clients
+type Server struct {
+	client chat.ChatClient
+	logger log.Logger
+
+	mu        sync.Mutex
+	listeners map[net.Listener]bool
+	conns     map[*conn]bool
+	closed    bool
+	wg        sync.WaitGroup
+}
+
+// New returns a server relaying the IRC clients to the chat service over cc
+func New(cc *grpc.ClientConn, logger log.Logger) *Server {
+	return &Server{
+		client:    chat.NewChatClient(cc),
+		logger:    logger,
+		listeners: make(map[net.Listener]bool),
+		conns:     make(map[*conn]bool),
+	}
+}
+
+// Serve accepts the IRC clients on lis until Close is called, it returns nil then
+func (s *Server) Serve(lis net.Listener) error {
+
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		lis.Close()
+		return ErrClosed
+	}
+	s.listeners[lis] = true
+	s.mu.Unlock()
+
+	level.Info(s.logger).Log("message", "IRC gateway started listening", "address", lis.Addr())
+	for {
+		nc, err := lis.Accept()
+		if err != nil {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			delete(s.listeners, lis)
+			if s.closed {
+				return nil
+			}
+			return err
+		}
+
+		c := newConn(s, nc)
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			nc.Close()
+			continue
+		}
+		s.conns[c] = true
+		s.wg.Add(1)
+		s.mu.Unlock()
+		go func() {
+			defer s.wg.Done()
+			c.serve()
+			s.mu.Lock()
+			delete(s.conns, c)
+			s.mu.Unlock()
+		}()
+	}
+}
+
+// Close stops accepting clients and disconnects those left, waiting for them to be logged out
+func (s *Server) Close() error {
+
+	s.mu.Lock()
+	s.closed = true
+	for lis := range s.listeners {
+		lis.Close()
+	}
+	for c := range s.conns {
+		c.close("server is shutting down")
+	}
+	s.mu.Unlock()
+	s.wg.Wait()
+	return nil
+}
+
+// message is a line sent by a client, the prefix is dropped
+type message struct {
+	command string
+	params  []string
+}
+
+// parse splits the line into its command and parameters, the trailing parameter included
+func parse(line string) (message, bool) {
+
+	if strings.HasPrefix(line, ":") {
+		i := strings.IndexByte(line, ' ')
+		if i < 0 {
+			return message{}, false
+		}
+		line = line[i+1:]
+	}
+	trailing, hasTrailing := "", false
+	if i := strings.Index(line, " :"); i >= 0 {
+		line, trailing, hasTrailing = line[:i], line[i+2:], true
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return message{}, false
+	}
+	m := message{command: strings.ToUpper(fields[0]), params: fields[1:]}
+	if hasTrailing {
+		m.params = append(m.params, trailing)
+	}
+	return m, true
+}
+
+// conn is the session of an IRC client
+type conn struct {
+	srv    *Server
+	nc     net.Conn
+	logger log.Logger
+
+	// nick, user and token are set during the registration, before the events are relayed
+	nick, user string
+	token      string
+	cancel     context.CancelFunc
+	relayed    chan struct{}
+
+	wmu sync.Mutex
+
+	mu sync.Mutex
+	// joined holds the rooms of the channels joined
+	joined map[string]bool
+}
+
+func newConn(s *Server, nc net.Conn) *conn {
+	return &conn{
+		srv:    s,
+		nc:     nc,
+		logger: log.With(s.logger, "remote", nc.RemoteAddr()),
+		joined: make(map[string]bool),
+	}
+}
+
+// serve handles the commands of the client until it quits or goes away, and then logs it out
+func (c *conn) serve() {
+
+	defer c.nc.Close()
+	level.Info(c.logger).Log("message", "IRC client connected")
+	scanner := bufio.NewScanner(c.nc)
+	scanner.Buffer(make([]byte, 512), maxLineSize)
+	c.nc.SetReadDeadline(time.Now().Add(registrationTimeout))
+	for scanner.Scan() {
+		m, ok := parse(scanner.Text())
+		if !ok {
+			continue
+		}
+		if quit := c.handle(m); quit {
+			break
+		}
+	}
+	c.logout()
+	level.Info(c.logger).Log("message", "IRC client disconnected", "nick", c.nick)
+}
+
+// close tells the client why it is disconnected and closes the connection, which ends serve
+func (c *conn) close(reason string) {
+	c.send(serverName, "ERROR", "Closing link: "+reason)
+	c.nc.Close()
+}
+
+// send writes a line to the client, the last parameter is sent as the trailing one
+func (c *conn) send(prefix, command string, params ...string) error {
+
+	var b strings.Builder
+	b.WriteString(":" + prefix + " " + command)
+	for i, p := range params {
+		// The line breaks of the chat messages would end the line early
+		p = strings.NewReplacer("\r", " ", "\n", " ").Replace(p)
+		if i == len(params)-1 {
+			b.WriteString(" :" + p)
+		} else {
+			b.WriteString(" " + p)
+		}
+	}
+	b.WriteString("\r\n")
+
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	c.nc.SetWriteDeadline(time.Now().Add(writeWait))
+	_, err := io.WriteString(c.nc, b.String())
+	return err
+}
+
+// reply sends a numeric reply, addressed to the nickname of the client
+func (c *conn) reply(code string, params ...string) {
+
+	nick := c.nick
+	if nick == "" {
+		nick = "*"
+	}
+	c.send(serverName, code, append([]string{nick}, params...)...)
+}
+
+// prefix is the source of the lines about the user. The federated users, user@domain, are not valid
+// nicknames: they show up as user|domain, with dashes for the dots of the domain, on the host of their domain.
+func prefix(name string) string {
+	if i := strings.LastIndex(name, "@"); i >= 0 {
+		user, domain := name[:i], name[i+1:]
+		return user + "|" + strings.ReplaceAll(domain, ".", "-") + "!" + user + "@" + domain
+	}
+	return name + "!" + name + "@" + serverName
+}
+
+// handle runs the command, it reports whether the client quit
+func (c *conn) handle(m message) bool {
+
+	switch m.command {
+	case "QUIT":
+		c.close("quit")
+		return true
+	case "PING":
+		c.send(serverName, "PONG", serverName, strings.Join(m.params, " "))
+		return false
+	case "PONG":
+		return false
+	case "CAP":
+		c.capability(m.params)
+		return false
+	case "NICK":
+		c.nickname(m.params)
+		return false
+	case "USER":
+		c.username(m.params)
+		return false
+	}
+
+	if c.token == "" {
+		c.reply("451", "You have not registered")
+		return false
+	}
+	switch m.command {
+	case "JOIN":
+		c.join(m.params)
+	case "PART":
+		c.part(m.params)
+	case "PRIVMSG":
+		c.privmsg(m.params)
+	case "NAMES":
+		c.names(m.params)
+	case "WHO":
+		c.who(m.params)
+	default:
+		c.reply("421", m.command, "Unknown command")
+	}
+	return false
+}
+
+// capability answers the capability negotiation, none is supported
+func (c *conn) capability(params []string) {
+
+	if len(params) == 0 {
+		c.reply("461", "CAP", "Not enough parameters")
+		return
+	}
+	switch strings.ToUpper(params[0]) {
+	case "LS", "LIST":
+		c.send(serverName, "CAP", "*", strings.ToUpper(params[0]), "")
+	case "REQ":
+		c.send(serverName, "CAP", "*", "NAK", strings.Join(params[1:], " "))
+	}
+}
+
+func (c *conn) nickname(params []string) {
+
+	if len(params) == 0 || params[0] == "" {
+		c.reply("431", "No nickname given")
+		return
+	}
+	if c.token != "" {
+		c.reply("447", "Cannot change nickname while logged in")
+		return
+	}
+	if strings.ContainsAny(params[0], "!@#,*?:") {
+		c.reply("432", params[0], "Erroneous nickname")
+		return
+	}
+	c.nick = params[0]
+	c.register()
+}
+
+func (c *conn) username(params []string) {
+
+	if c.token != "" {
+		c.reply("462", "You may not reregister")
+		return
+	}
+	if len(params) < 4 {
+		c.reply("461", "USER", "Not enough parameters")
+		return
+	}
+	c.user = params[0]
+	c.register()
+}
+
+// register logs the client in once it gave both its nickname and its user, and starts relaying the events
+func (c *conn) register() {
+
+	if c.nick == "" || c.user == "" {
+		return
+	}
+	ctx := context.Background()
+	res, err := c.srv.client.Login(ctx, &chat.LoginRequest{Username: c.nick})
+	if err != nil {
+		numeric := "432"
+		if status.Code(err) == codes.AlreadyExists {
+			numeric = "433"
+		}
+		c.reply(numeric, c.nick, status.Convert(err).Message())
+		c.nick = ""
+		return
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	stream, err := c.srv.client.Subscribe(ctx, &chat.SubscribeRequest{Token: res.Token})
+	if err == nil {
+		// The headers come once the stream is registered, the client gets every event from then on
+		_, err = stream.Header()
+	}
+	if err != nil {
+		cancel()
+		c.srv.client.Logout(ctx, &chat.LogoutRequest{Token: res.Token})
+		c.close(status.Convert(err).Message())
+		return
+	}
+	c.token, c.cancel, c.relayed = res.Token, cancel, make(chan struct{})
+	go c.relay(ctx, stream)
+	c.nc.SetReadDeadline(time.Time{})
+	level.Info(c.logger).Log("message", "IRC client logged in", "nick", c.nick)
+
+	c.reply("001", "Welcome to the chat "+prefix(c.nick))
+	c.reply("002", "Your host is "+serverName)
+	c.reply("003", "This server bridges IRC to the chat")
+	c.reply("422", "MOTD File is missing")
+}
+
+// logout ends the session of the client in the chat
+func (c *conn) logout() {
+
+	if c.token == "" {
+		return
+	}
+	c.cancel()
+	<-c.relayed
+	ctx, cancel := context.WithTimeout(context.Background(), logoutTimeout)
+	defer cancel()
+	if _, err := c.srv.client.Logout(ctx, &chat.LogoutRequest{Token: c.token}); err != nil {
+		level.Warn(c.logger).Log("message", "failed to log the client out", "nick", c.nick, "err", err)
+	}
+}
+
+// relay sends the events of the chat to the client until the stream ends. The messages of the client come
+// back from the chat, they are not repeated to it.
+func (c *conn) relay(ctx context.Context, stream chat.Chat_SubscribeClient) {
+
+	defer close(c.relayed)
+	for {
+		res, err := stream.Recv()
+		if err != nil {
+			if ctx.Err() == nil {
+				c.close(status.Convert(err).Message())
+			}
+			return
+		}
+
+		switch ev := res.Event.(type) {
+		case *chat.StreamResponse_ClientMessage:
+			msg := ev.ClientMessage
+			switch {
+			case msg.Name == c.nick:
+			case msg.To != "":
+				c.send(prefix(msg.Name), "PRIVMSG", msg.To, msg.Message)
+			case c.isJoined(msg.Room):
+				c.send(prefix(msg.Name), "PRIVMSG", "#"+msg.Room, msg.Message)
+			}
+
+		// IRC has no edits nor deletes, the channel is told about them
+		case *chat.StreamResponse_MessageEdit:
+			edit := ev.MessageEdit
+			if c.isJoined(edit.Room) {
+				c.send(prefix(edit.Name), "NOTICE", "#"+edit.Room, "edited a message: "+edit.Message)
+			}
+
+		case *chat.StreamResponse_MessageDelete:
+			del := ev.MessageDelete
+			if c.isJoined(del.Room) {
+				c.send(prefix(del.Name), "NOTICE", "#"+del.Room, "deleted a message")
+			}
+
+		case *chat.StreamResponse_ClientKick:
+			kick := ev.ClientKick
+			if !c.isJoined(kick.Room) {
+				break
+			}
+			if kick.Name == c.nick {
+				c.mu.Lock()
+				delete(c.joined, kick.Room)
+				c.mu.Unlock()
+			}
+			c.send(prefix(kick.By), "KICK", "#"+kick.Room, kick.Name, "Kicked out of the room")
+
+		case *chat.StreamResponse_ClientLogout:
+			if ev.ClientLogout.Name != c.nick {
+				c.send(prefix(ev.ClientLogout.Name), "QUIT", "Logged out")
+			}
+
+		case *chat.StreamResponse_ServerShutdown:
+			c.close(ev.ServerShutdown.Reason)
+			return
+		}
+	}
+}
+
+func (c *conn) isJoined(room string) bool {
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.joined[room]
+}
+
+// channels splits the comma separated channels of the parameters, nil when there are none
+func channels(params []string) []string {
+
+	if len(params) == 0 || params[0] == "" {
+		return nil
+	}
+	return strings.Split(params[0], ",")
+}
+
+func (c *conn) join(params []string) {
+
+	chans := channels(params)
+	if len(chans) == 0 {
+		c.reply("461", "JOIN", "Not enough parameters")
+		return
+	}
+	ctx := context.Background()
+	for _, ch := range chans {
+		if !strings.HasPrefix(ch, "#") || len(ch) == 1 {
+			c.reply("403", ch, "No such channel")
+			continue
+		}
+		room := ch[1:]
+		if c.isJoined(room) {
+			continue
+		}
+
+		_, err := c.srv.client.JoinRoom(ctx, &chat.JoinRoomRequest{Token: c.token, Room: room})
+		if status.Code(err) == codes.NotFound {
+			_, err = c.srv.client.CreateRoom(ctx, &chat.CreateRoomRequest{Token: c.token, Name: room, Visibility: chat.Visibility_PUBLIC})
+		}
+		switch status.Code(err) {
+		case codes.OK:
+		case codes.PermissionDenied:
+			c.reply("473", ch, "Cannot join channel (+i)")
+			continue
+		default:
+			c.reply("403", ch, "No such channel")
+			continue
+		}
+
+		c.mu.Lock()
+		c.joined[room] = true
+		c.mu.Unlock()
+		c.send(prefix(c.nick), "JOIN", ch)
+		c.names([]string{ch})
+	}
+}
+
+func (c *conn) part(params []string) {
+
+	chans := channels(params)
+	if len(chans) == 0 {
+		c.reply("461", "PART", "Not enough parameters")
+		return
+	}
+	reason := c.nick
+	if len(params) > 1 {
+		reason = params[1]
+	}
+	for _, ch := range chans {
+		room := strings.TrimPrefix(ch, "#")
+		c.mu.Lock()
+		joined := c.joined[room]
+		delete(c.joined, room)
+		c.mu.Unlock()
+		if !joined {
+			c.reply("442", ch, "You're not on that channel")
+			continue
+		}
+		c.send(prefix(c.nick), "PART", ch, reason)
+	}
+}
+
+func (c *conn) privmsg(params []string) {
+
+	if len(params) == 0 {
+		c.reply("411", "No recipient given (PRIVMSG)")
+		return
+	}
+	if len(params) < 2 || params[1] == "" {
+		c.reply("412", "No text to send")
+		return
+	}
+	ctx := context.Background()
+	for _, target := range strings.Split(params[0], ",") {
+		if strings.HasPrefix(target, "#") {
+			room := target[1:]
+			if !c.isJoined(room) {
+				c.reply("404", target, "Cannot send to channel")
+				continue
+			}
+			if _, err := c.srv.client.Post(ctx, &chat.PostRequest{Token: c.token, Room: room, Message: params[1]}); err != nil {
+				c.reply("404", target, status.Convert(err).Message())
+			}
+			continue
+		}
+
+		_, err := c.srv.client.Post(ctx, &chat.PostRequest{Token: c.token, To: target, Message: params[1]})
+		switch status.Code(err) {
+		case codes.OK:
+		case codes.NotFound:
+			c.reply("401", target, "No such nick/channel")
+		default:
+			c.reply("404", target, status.Convert(err).Message())
+		}
+	}
+}
+
+// users returns the names logged in to the chat
+func (c *conn) users() ([]string, error) {
+
+	res, err := c.srv.client.ListUsers(context.Background(), &chat.ListUsersRequest{Token: c.token})
+	if err != nil {
+		return nil, err
+	}
+	return res.Usernames, nil
+}
+
+func (c *conn) names(params []string) {
+
+	chans := channels(params)
+	if len(chans) == 0 {
+		c.mu.Lock()
+		for room := range c.joined {
+			chans = append(chans, "#"+room)
+		}
+		c.mu.Unlock()
+	}
+	users, err := c.users()
+	if err != nil {
+		level.Warn(c.logger).Log("message", "failed to list the users", "err", err)
+	}
+	for _, ch := range chans {
+		if len(users) > 0 {
+			c.reply("353", "=", ch, strings.Join(users, " "))
+		}
+		c.reply("366", ch, "End of /NAMES list")
+	}
+}
+
+func (c *conn) who(params []string) {
+
+	mask := "*"
+	if len(params) > 0 {
+		mask = params[0]
+	}
+	users, err := c.users()
+	if err != nil {
+		level.Warn(c.logger).Log("message", "failed to list the users", "err", err)
+	}
+	for _, name := range users {
+		ch := mask
+		if !strings.HasPrefix(mask, "#") {
+			if mask != "*" && mask != name {
+				continue
+			}
+			ch = "*"
+		}
+		c.reply("352", ch, name, serverName, serverName, name, "H", "0 "+name)
+	}
+	c.reply("315", mask, "End of /WHO list")
+}
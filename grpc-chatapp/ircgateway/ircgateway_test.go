@@ -0,0 +1,215 @@
+package ircgateway_test
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/ircgateway"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+)
+
+// ircClient speaks raw IRC lines to the gateway
+type ircClient struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func dial(t *testing.T, addr string) *ircClient {
+
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &ircClient{t: t, conn: conn, r: bufio.NewReader(conn)}
+}
+
+func (c *ircClient) send(line string) {
+
+	c.t.Helper()
+	if _, err := c.conn.Write([]byte(line + "\r\n")); err != nil {
+		c.t.Fatalf("failed to send %q: %v", line, err)
+	}
+}
+
+// expect reads lines until one contains want and returns it
+func (c *ircClient) expect(want string) string {
+
+	c.t.Helper()
+	c.conn.SetReadDeadline(time.Now().Add(chattest.Timeout))
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			c.t.Fatalf("failed to read a line with %q: %v", want, err)
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if strings.Contains(line, want) {
+			return line
+		}
+	}
+}
+
+// register logs the client in as nick
+func (c *ircClient) register(nick string) {
+
+	c.t.Helper()
+	c.send("NICK " + nick)
+	c.send("USER " + nick + " 0 * :" + nick)
+	c.expect(" 001 " + nick + " ")
+}
+
+func TestGateway(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	srv := ircgateway.New(s.Dial(), log.NewNopLogger())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() { served <- srv.Serve(lis) }()
+	addr := lis.Addr().String()
+	bob := s.Client("bob")
+
+	alice := dial(t, addr)
+	alice.send("CAP LS 302")
+	alice.expect("CAP * LS")
+	alice.send("JOIN #lobby")
+	alice.expect(" 451 * ")
+	alice.register("alice")
+	if login := bob.Next(chatclient.Login{}).(chatclient.Login); login.Name != "alice" {
+		t.Fatalf("bob saw %v log in, want alice", login.Name)
+	}
+
+	alice.send("JOIN #lobby")
+	alice.expect(":alice!alice@chat JOIN :#lobby")
+	if names := alice.expect(" 353 alice = #lobby :"); !strings.HasSuffix(names, ":alice bob") {
+		t.Fatalf("NAMES replied %q, want alice and bob", names)
+	}
+	alice.expect(" 366 alice #lobby ")
+
+	// Channels are rooms both ways
+	ctx := context.Background()
+	if err := bob.Send(ctx, "lobby", "hi irc"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	alice.expect(":bob!bob@chat PRIVMSG #lobby :hi irc")
+	bob.Next(chatclient.Message{})
+	alice.send("PRIVMSG #lobby :hello grpc")
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Name != "alice" || got.Room != "lobby" || got.Text != "hello grpc" {
+		t.Fatalf("bob received %q in %v from %v, want alice's message in the lobby", got.Text, got.Room, got.Name)
+	}
+
+	// PRIVMSG to a nickname is a direct message
+	alice.send("PRIVMSG bob :psst")
+	got := bob.Next(chatclient.Message{}).(chatclient.Message)
+	if msg := got.Response().GetClientMessage(); msg.Name != "alice" || msg.To != "bob" || msg.Message != "psst" {
+		t.Fatalf("bob received %v, want a direct message from alice", msg)
+	}
+	carol := dial(t, addr)
+	carol.send("NICK alice")
+	carol.send("USER carol 0 * :carol")
+	carol.expect(" 433 ")
+	carol.register("carol")
+	carol.send("PRIVMSG alice :hey")
+	alice.expect(":carol!carol@chat PRIVMSG alice :hey")
+
+	tests := []struct {
+		line, want string
+	}{
+		{"PRIVMSG #ops :hi", " 404 alice #ops "},
+		{"PRIVMSG dave :hi", " 401 alice dave "},
+		{"PRIVMSG bob", " 412 alice "},
+		{"PART #ops", " 442 alice #ops "},
+		{"JOIN ops", " 403 alice ops "},
+		{"NICK alicia", " 447 alice "},
+		{"KICK #lobby bob", " 421 alice KICK "},
+		{"PING :12345", "PONG chat :12345"},
+	}
+	for _, tt := range tests {
+		alice.send(tt.line)
+		alice.expect(tt.want)
+	}
+
+	// Joining a missing channel creates the room
+	alice.send("JOIN #ops")
+	alice.expect(":alice!alice@chat JOIN :#ops")
+	carol.send("JOIN #ops")
+	carol.expect(":carol!carol@chat JOIN :#ops")
+	carol.send("PRIVMSG #ops :standup?")
+	alice.expect(":carol!carol@chat PRIVMSG #ops :standup?")
+
+	alice.send("WHO #ops")
+	for _, name := range []string{"alice", "bob", "carol"} {
+		alice.expect(" 352 alice #ops " + name + " chat chat " + name + " H :0 " + name)
+	}
+	alice.expect(" 315 alice #ops ")
+
+	// Edits and deletes are noticed, a kick parts the channel
+	if err := bob.CreateRoom(ctx, "dev", chat.Visibility_PUBLIC); err != nil {
+		t.Fatalf("CreateRoom() error = %v", err)
+	}
+	alice.send("JOIN #dev")
+	alice.expect(":alice!alice@chat JOIN :#dev")
+	if err := bob.Send(ctx, "dev", "typo"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	alice.expect(":bob!bob@chat PRIVMSG #dev :typo")
+	// bob's earlier messages in the lobby and #ops come first
+	msg := bob.Next(chatclient.Message{}).(chatclient.Message)
+	for msg.Room != "dev" {
+		msg = bob.Next(chatclient.Message{}).(chatclient.Message)
+	}
+	if err := bob.EditMessage(ctx, "dev", msg.ID, "fixed"); err != nil {
+		t.Fatalf("EditMessage() error = %v", err)
+	}
+	alice.expect(":bob!bob@chat NOTICE #dev :edited a message: fixed")
+	if err := bob.DeleteMessage(ctx, "dev", msg.ID); err != nil {
+		t.Fatalf("DeleteMessage() error = %v", err)
+	}
+	alice.expect(":bob!bob@chat NOTICE #dev :deleted a message")
+	if err := bob.Kick(ctx, "dev", "alice"); err != nil {
+		t.Fatalf("Kick() error = %v", err)
+	}
+	alice.expect(":bob!bob@chat KICK #dev alice :Kicked out of the room")
+	alice.send("PART #dev")
+	alice.expect(" 442 alice #dev ")
+
+	// Leaving the lobby stops its messages, the ones of #ops still come
+	alice.send("PART #lobby")
+	alice.expect(":alice!alice@chat PART #lobby :alice")
+	if err := bob.Send(ctx, "lobby", "anyone?"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	carol.send("PRIVMSG #ops :after")
+	if line := alice.expect(" PRIVMSG "); !strings.HasSuffix(line, "#ops :after") {
+		t.Fatalf("alice received %q after leaving the lobby", line)
+	}
+
+	carol.send("QUIT :bye")
+	carol.expect("ERROR :Closing link")
+	alice.expect(":carol!carol@chat QUIT :Logged out")
+	if logout := bob.Next(chatclient.Logout{}).(chatclient.Logout); logout.Name != "carol" {
+		t.Fatalf("bob saw %v log out, want carol", logout.Name)
+	}
+
+	// Closing the server logs the clients out
+	srv.Close()
+	alice.expect("ERROR :Closing link: server is shutting down")
+	if logout := bob.Next(chatclient.Logout{}).(chatclient.Logout); logout.Name != "alice" {
+		t.Fatalf("bob saw %v log out, want alice", logout.Name)
+	}
+	if err := <-served; err != nil {
+		t.Fatalf("Serve() error = %v", err)
+	}
+}
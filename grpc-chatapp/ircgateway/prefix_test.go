@@ -0,0 +1,19 @@
+package ircgateway
+
+import "testing"
+
+func TestPrefix(t *testing.T) {
+
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"alice", "alice!alice@" + serverName},
+		{"bob@chat.example.com", "bob|chat-example-com!bob@chat.example.com"},
+	}
+	for _, tt := range tests {
+		if got := prefix(tt.name); got != tt.want {
+			t.Errorf("prefix(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
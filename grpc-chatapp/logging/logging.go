@@ -0,0 +1,209 @@
+// Package logging builds the go-kit loggers used by the chat server, with a
+// selectable output format, per component levels and request scoped fields.
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Supported output formats
+const (
+	FormatLogfmt = "logfmt"
+	FormatJSON   = "json"
+)
+
+const requestIDHeader = "x-request-id"
+
+// Levels holds the default level and the per component overrides. It implements
+// flag.Value and parses values like "info,broadcast=debug,rooms=warn".
+type Levels struct {
+	Default    string
+	Components map[string]string
+}
+
+func (l *Levels) String() string {
+
+	if l == nil {
+		return ""
+	}
+	parts := []string{l.Default}
+	for name, lvl := range l.Components {
+		parts = append(parts, name+"="+lvl)
+	}
+	sort.Strings(parts[1:])
+	return strings.Join(parts, ",")
+}
+
+func (l *Levels) Set(value string) error {
+
+	levels := Levels{Default: "info", Components: make(map[string]string)}
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, lvl := "", part
+		if i := strings.Index(part, "="); i >= 0 {
+			name, lvl = part[:i], part[i+1:]
+		}
+		if _, err := allow(lvl); err != nil {
+			return err
+		}
+		if name == "" {
+			levels.Default = lvl
+		} else {
+			levels.Components[name] = lvl
+		}
+	}
+	*l = levels
+	return nil
+}
+
+func (l *Levels) level(component string) string {
+
+	if lvl, ok := l.Components[component]; ok {
+		return lvl
+	}
+	if l.Default == "" {
+		return "info"
+	}
+	return l.Default
+}
+
+func allow(lvl string) (level.Option, error) {
+
+	switch lvl {
+	case "debug":
+		return level.AllowDebug(), nil
+	case "info":
+		return level.AllowInfo(), nil
+	case "warn":
+		return level.AllowWarn(), nil
+	case "error":
+		return level.AllowError(), nil
+	case "none":
+		return level.AllowNone(), nil
+	}
+	return nil, fmt.Errorf("unknown log level %q", lvl)
+}
+
+// Logging hands out the loggers of the different components
+type Logging struct {
+	base   log.Logger
+	levels Levels
+}
+
+// New returns a Logging writing to w in the given format
+func New(w io.Writer, format string, levels Levels) (*Logging, error) {
+
+	var base log.Logger
+	switch format {
+	case FormatLogfmt, "":
+		base = log.NewLogfmtLogger(log.NewSyncWriter(w))
+	case FormatJSON:
+		base = log.NewJSONLogger(log.NewSyncWriter(w))
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return &Logging{base: base, levels: levels}, nil
+}
+
+// Component returns the logger of the named component, filtered at its level
+// and stamping every line with the time it was written
+func (l *Logging) Component(name string) log.Logger {
+
+	opt, err := allow(l.levels.level(name))
+	if err != nil {
+		opt = level.AllowInfo()
+	}
+	logger := level.NewFilter(l.base, opt)
+	return log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller, "component", name)
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger
+func NewContext(ctx context.Context, logger log.Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, logger)
+}
+
+// FromContext returns the logger carried by ctx, or fallback when there is none
+func FromContext(ctx context.Context, fallback log.Logger) log.Logger {
+
+	if logger, ok := ctx.Value(contextKey{}).(log.Logger); ok {
+		return logger
+	}
+	return fallback
+}
+
+// UsernameFunc resolves the user making a request, req is nil for streams
+type UsernameFunc func(ctx context.Context, req interface{}) string
+
+func requestID(ctx context.Context) string {
+
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(requestIDHeader); len(ids) > 0 && ids[0] != "" {
+			return ids[0]
+		}
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return ""
+	}
+	return fmt.Sprintf("%x", id)
+}
+
+func requestLogger(ctx context.Context, logger log.Logger, method string, username string) (log.Logger, string) {
+
+	id := requestID(ctx)
+	keyvals := []interface{}{"request_id", id, "method", method}
+	if p, ok := peer.FromContext(ctx); ok {
+		keyvals = append(keyvals, "peer", p.Addr.String())
+	}
+	if username != "" {
+		keyvals = append(keyvals, "username", username)
+	}
+	return log.With(logger, keyvals...), id
+}
+
+// UnaryServerInterceptor attaches a logger carrying the request id, the peer address and the
+// username to the context of every call, and returns the request id in the response header
+func UnaryServerInterceptor(logger log.Logger, username UsernameFunc) grpc.UnaryServerInterceptor {
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		reqLogger, id := requestLogger(ctx, logger, info.FullMethod, username(ctx, req))
+		grpc.SetHeader(ctx, metadata.Pairs(requestIDHeader, id))
+		return handler(NewContext(ctx, reqLogger), req)
+	}
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor is the streaming counterpart of UnaryServerInterceptor
+func StreamServerInterceptor(logger log.Logger, username UsernameFunc) grpc.StreamServerInterceptor {
+
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx := ss.Context()
+		reqLogger, id := requestLogger(ctx, logger, info.FullMethod, username(ctx, nil))
+		ss.SetHeader(metadata.Pairs(requestIDHeader, id))
+		return handler(srv, &serverStream{ServerStream: ss, ctx: NewContext(ctx, reqLogger)})
+	}
+}
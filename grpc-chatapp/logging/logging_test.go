@@ -0,0 +1,108 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/go-kit/kit/log/level"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestLevelsSet(t *testing.T) {
+
+	tests := []struct {
+		value      string
+		def        string
+		components map[string]string
+		err        bool
+	}{
+		{"debug", "debug", map[string]string{}, false},
+		{"info,broadcast=debug", "info", map[string]string{"broadcast": "debug"}, false},
+		{"broadcast=debug, server=warn", "info", map[string]string{"broadcast": "debug", "server": "warn"}, false},
+		{"verbose", "", nil, true},
+		{"server=loud", "", nil, true},
+	}
+
+	for _, tt := range tests {
+		var l Levels
+		err := l.Set(tt.value)
+		if (err != nil) != tt.err {
+			t.Fatalf("Set(%q) error = %v, want error %v", tt.value, err, tt.err)
+		}
+		if tt.err {
+			continue
+		}
+		if l.Default != tt.def {
+			t.Errorf("Set(%q) default = %v, want %v", tt.value, l.Default, tt.def)
+		}
+		if len(l.Components) != len(tt.components) {
+			t.Fatalf("Set(%q) components = %v, want %v", tt.value, l.Components, tt.components)
+		}
+		for name, lvl := range tt.components {
+			if l.Components[name] != lvl {
+				t.Errorf("Set(%q) level of %v = %v, want %v", tt.value, name, l.Components[name], lvl)
+			}
+		}
+	}
+}
+
+func TestComponentLevels(t *testing.T) {
+
+	var buf bytes.Buffer
+	logs, err := New(&buf, FormatJSON, Levels{Default: "warn", Components: map[string]string{"broadcast": "debug"}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	level.Debug(logs.Component("server")).Log("message", "hidden")
+	level.Debug(logs.Component("broadcast")).Log("message", "shown")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("logged %d lines, want 1: %v", len(lines), lines)
+	}
+	var line map[string]string
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatalf("line is not JSON: %v", err)
+	}
+	if line["message"] != "shown" || line["component"] != "broadcast" || line["ts"] == "" {
+		t.Fatalf("unexpected line %v", line)
+	}
+	if !strings.HasPrefix(line["caller"], "logging_test.go:") {
+		t.Fatalf("caller = %v, want the test file", line["caller"])
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+
+	var buf bytes.Buffer
+	logs, err := New(&buf, FormatLogfmt, Levels{Default: "info"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	interceptor := UnaryServerInterceptor(logs.Component("server"), func(context.Context, interface{}) string {
+		return "alice"
+	})
+
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4242}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(requestIDHeader, "req-1"))
+	info := &grpc.UnaryServerInfo{FullMethod: "/chat.Chat/Login"}
+	_, err = interceptor(ctx, nil, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		level.Info(FromContext(ctx, nil)).Log("message", "handled")
+		return nil, nil
+	})
+	if err != nil {
+		t.Fatalf("interceptor error = %v", err)
+	}
+
+	for _, want := range []string{"request_id=req-1", "peer=127.0.0.1:4242", "username=alice", "method=/chat.Chat/Login", "message=handled"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("log line %q does not contain %q", buf.String(), want)
+		}
+	}
+}
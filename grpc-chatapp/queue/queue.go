@@ -0,0 +1,214 @@
+// Package queue provides a bounded FIFO queue backed by a ring buffer. Unlike a buffered channel it
+// can evict its oldest element when full, report its length and drops, and wait with a context.
+package queue
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Policy decides what Push and TryPush do when the queue is full
+type Policy int
+
+const (
+	// Block makes Push wait for room, TryPush returns ErrFull
+	Block Policy = iota
+	// DropNewest rejects the new element with ErrFull
+	DropNewest
+	// DropOldest evicts the oldest element to make room for the new one
+	DropOldest
+)
+
+func (p Policy) String() string {
+	switch p {
+	case Block:
+		return "block"
+	case DropNewest:
+		return "drop-newest"
+	case DropOldest:
+		return "drop-oldest"
+	}
+	return "unknown"
+}
+
+var (
+	ErrFull   = errors.New("queue: full")
+	ErrEmpty  = errors.New("queue: empty")
+	ErrClosed = errors.New("queue: closed")
+)
+
+type Queue[T any] struct {
+	policy Policy
+
+	mu sync.Mutex
+	// buf holds count elements starting at head, wrapping around
+	buf         []T
+	head, count int
+	closed      bool
+	dropped     uint64
+	// changed is closed whenever an element is added or removed or the queue is closed, the waiters
+	// select on it along with their context. It is only made when somebody waits.
+	changed chan struct{}
+}
+
+// New returns an empty queue holding up to capacity elements, it panics if capacity is not positive
+func New[T any](capacity int, policy Policy) *Queue[T] {
+
+	if capacity <= 0 {
+		panic("queue: capacity has to be positive")
+	}
+	return &Queue[T]{policy: policy, buf: make([]T, capacity)}
+}
+
+func (q *Queue[T]) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.count
+}
+
+func (q *Queue[T]) Cap() int {
+	return len(q.buf)
+}
+
+// Dropped returns the number of elements rejected or evicted because the queue was full
+func (q *Queue[T]) Dropped() uint64 {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.dropped
+}
+
+// notify wakes the waiters up, q.mu has to be held
+func (q *Queue[T]) notify() {
+	if q.changed != nil {
+		close(q.changed)
+		q.changed = nil
+	}
+}
+
+// wait returns the channel closed on the next change, q.mu has to be held
+func (q *Queue[T]) wait() <-chan struct{} {
+	if q.changed == nil {
+		q.changed = make(chan struct{})
+	}
+	return q.changed
+}
+
+// push adds v or applies the overflow policy, q.mu has to be held. It returns ErrFull when
+// the queue blocks and is full, the caller decides whether to wait.
+func (q *Queue[T]) push(v T) error {
+
+	if q.closed {
+		return ErrClosed
+	}
+	if q.count == len(q.buf) {
+		switch q.policy {
+		case DropOldest:
+			var zero T
+			q.buf[q.head] = zero
+			q.head = (q.head + 1) % len(q.buf)
+			q.count--
+			q.dropped++
+		case DropNewest:
+			q.dropped++
+			return ErrFull
+		default:
+			return ErrFull
+		}
+	}
+	q.buf[(q.head+q.count)%len(q.buf)] = v
+	q.count++
+	q.notify()
+	return nil
+}
+
+// pop removes the oldest element, q.mu has to be held
+func (q *Queue[T]) pop() (T, error) {
+
+	var zero T
+	if q.count == 0 {
+		if q.closed {
+			return zero, ErrClosed
+		}
+		return zero, ErrEmpty
+	}
+	v := q.buf[q.head]
+	// Let go of the element for the garbage collector
+	q.buf[q.head] = zero
+	q.head = (q.head + 1) % len(q.buf)
+	q.count--
+	q.notify()
+	return v, nil
+}
+
+// TryPush adds v without waiting, it returns ErrFull when there is no room and the policy does not evict
+func (q *Queue[T]) TryPush(v T) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.push(v)
+}
+
+// Push adds v, waiting for room with the Block policy until ctx is done. The other policies never wait.
+func (q *Queue[T]) Push(ctx context.Context, v T) error {
+
+	for {
+		q.mu.Lock()
+		err := q.push(v)
+		if err != ErrFull || q.policy != Block {
+			q.mu.Unlock()
+			return err
+		}
+		changed := q.wait()
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// TryPop removes the oldest element without waiting. It returns ErrEmpty when there is none,
+// or ErrClosed once the queue is closed and drained.
+func (q *Queue[T]) TryPop() (T, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.pop()
+}
+
+// Pop removes the oldest element, waiting for one until ctx is done. It returns ErrClosed
+// once the queue is closed and drained.
+func (q *Queue[T]) Pop(ctx context.Context) (T, error) {
+
+	for {
+		q.mu.Lock()
+		v, err := q.pop()
+		if err != ErrEmpty {
+			q.mu.Unlock()
+			return v, err
+		}
+		changed := q.wait()
+		q.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			var zero T
+			return zero, ctx.Err()
+		}
+	}
+}
+
+// Close stops the queue from taking new elements, the ones it holds can still be popped. Waiting pushes
+// return ErrClosed. Closing a closed queue does nothing.
+func (q *Queue[T]) Close() {
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return
+	}
+	q.closed = true
+	q.notify()
+}
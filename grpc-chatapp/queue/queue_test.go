@@ -0,0 +1,231 @@
+package queue
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// drain pops everything the queue holds
+func drain(q *Queue[int]) []int {
+
+	var got []int
+	for {
+		v, err := q.TryPop()
+		if err != nil {
+			return got
+		}
+		got = append(got, v)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+
+	tests := []struct {
+		policy      Policy
+		wantErrs    []error
+		want        []int
+		wantDropped uint64
+	}{
+		{Block, []error{nil, nil, nil, ErrFull, ErrFull}, []int{1, 2, 3}, 0},
+		{DropNewest, []error{nil, nil, nil, ErrFull, ErrFull}, []int{1, 2, 3}, 2},
+		{DropOldest, []error{nil, nil, nil, nil, nil}, []int{3, 4, 5}, 2},
+	}
+
+	for _, tt := range tests {
+		q := New[int](3, tt.policy)
+		var errs []error
+		for v := 1; v <= 5; v++ {
+			errs = append(errs, q.TryPush(v))
+		}
+		if !reflect.DeepEqual(errs, tt.wantErrs) {
+			t.Errorf("%v: TryPush() errors = %v, want %v", tt.policy, errs, tt.wantErrs)
+		}
+		if q.Len() != 3 || q.Dropped() != tt.wantDropped {
+			t.Errorf("%v: Len() = %v, Dropped() = %v, want 3, %v", tt.policy, q.Len(), q.Dropped(), tt.wantDropped)
+		}
+		if got := drain(q); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v: popped %v, want %v", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestWrapAround(t *testing.T) {
+
+	q := New[int](3, Block)
+	var got []int
+	for v := 0; v < 10; v++ {
+		if err := q.TryPush(v); err != nil {
+			t.Fatalf("TryPush(%v) error = %v", v, err)
+		}
+		if v%2 == 1 {
+			got = append(got, drain(q)...)
+		}
+	}
+	if want := []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("popped %v, want %v", got, want)
+	}
+	if _, err := q.TryPop(); err != ErrEmpty {
+		t.Fatalf("TryPop() on an empty queue error = %v, want %v", err, ErrEmpty)
+	}
+}
+
+func TestClose(t *testing.T) {
+
+	q := New[int](3, Block)
+	q.TryPush(1)
+	q.Close()
+	q.Close()
+
+	if err := q.TryPush(2); err != ErrClosed {
+		t.Fatalf("TryPush() after Close error = %v, want %v", err, ErrClosed)
+	}
+	if v, err := q.Pop(context.Background()); v != 1 || err != nil {
+		t.Fatalf("Pop() = %v, %v, want the element pushed before Close", v, err)
+	}
+	if _, err := q.Pop(context.Background()); err != ErrClosed {
+		t.Fatalf("Pop() on a drained closed queue error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestWait(t *testing.T) {
+
+	ctx := context.Background()
+	timeout := func(d time.Duration) context.Context {
+		ctx, cancel := context.WithTimeout(ctx, d)
+		t.Cleanup(cancel)
+		return ctx
+	}
+
+	q := New[int](1, Block)
+	if _, err := q.Pop(timeout(10 * time.Millisecond)); err != context.DeadlineExceeded {
+		t.Fatalf("Pop() on an empty queue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	q.TryPush(1)
+	if err := q.Push(timeout(10*time.Millisecond), 2); err != context.DeadlineExceeded {
+		t.Fatalf("Push() on a full queue error = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	// A waiting push goes through once there is room
+	pushed := make(chan error, 1)
+	go func() { pushed <- q.Push(ctx, 2) }()
+	time.Sleep(10 * time.Millisecond)
+	if v, err := q.Pop(ctx); v != 1 || err != nil {
+		t.Fatalf("Pop() = %v, %v, want 1", v, err)
+	}
+	if err := <-pushed; err != nil {
+		t.Fatalf("Push() error = %v", err)
+	}
+
+	// A waiting pop gets the next element, or ErrClosed when the queue is closed
+	q.TryPop()
+	popped := make(chan int, 1)
+	go func() {
+		v, _ := q.Pop(ctx)
+		popped <- v
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.TryPush(3)
+	if v := <-popped; v != 3 {
+		t.Fatalf("Pop() = %v, want 3", v)
+	}
+	closed := make(chan error, 1)
+	go func() {
+		_, err := q.Pop(ctx)
+		closed <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	q.Close()
+	if err := <-closed; err != ErrClosed {
+		t.Fatalf("Pop() when closing error = %v, want %v", err, ErrClosed)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+
+	const producers, perProducer = 4, 1000
+	q := New[int](8, Block)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for p := 0; p < producers; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			for i := 0; i < perProducer; i++ {
+				if err := q.Push(ctx, p*perProducer+i); err != nil {
+					t.Errorf("Push() error = %v", err)
+					return
+				}
+			}
+		}(p)
+	}
+	go func() {
+		wg.Wait()
+		q.Close()
+	}()
+
+	// Every element comes out once, in the order of its producer
+	last := make([]int, producers)
+	for p := range last {
+		last[p] = -1
+	}
+	n := 0
+	for {
+		v, err := q.Pop(ctx)
+		if err == ErrClosed {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Pop() error = %v", err)
+		}
+		p, i := v/perProducer, v%perProducer
+		if i <= last[p] {
+			t.Fatalf("popped %v after %v from producer %v", i, last[p], p)
+		}
+		last[p] = i
+		n++
+	}
+	if n != producers*perProducer {
+		t.Fatalf("popped %v elements, want %v", n, producers*perProducer)
+	}
+}
+
+func BenchmarkTryPushTryPop(b *testing.B) {
+
+	q := New[int](128, DropOldest)
+	for i := 0; i < b.N; i++ {
+		q.TryPush(i)
+		q.TryPop()
+	}
+}
+
+func BenchmarkPushPop(b *testing.B) {
+
+	q := New[int](128, Block)
+	ctx := context.Background()
+	go func() {
+		for i := 0; i < b.N; i++ {
+			q.Push(ctx, i)
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		q.Pop(ctx)
+	}
+}
+
+// BenchmarkChannel is the buffered channel the queue replaces, for comparison
+func BenchmarkChannel(b *testing.B) {
+
+	ch := make(chan int, 128)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			ch <- i
+		}
+	}()
+	for i := 0; i < b.N; i++ {
+		<-ch
+	}
+}
@@ -0,0 +1,452 @@
+// Package redisbroker connects the instances of the chat server through Redis: the events go through
+// a pub/sub channel and every instance keeps the users logged in to it in a set that expires unless
+// refreshed, so that the users of a crashed instance do not linger. The last state change of every key
+// is kept in a hash, which the instances replay when they subscribe.
+package redisbroker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/proto"
+	"github.com/gomodule/redigo/redis"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	defaultChannel     = "chat"
+	defaultPresenceTTL = 30 * time.Second
+	resubscribeDelay   = time.Second
+)
+
+var errClosed = errors.New("redisbroker: the broker is closed")
+
+type Config struct {
+	// Addr is the address of the Redis server
+	Addr string
+	// Channel is the pub/sub channel and the prefix of the keys, the instances sharing it form one chat.
+	// It is "chat" when empty.
+	Channel string
+	// PresenceTTL is how long the users of an instance are listed once it stopped refreshing them, 30s when zero
+	PresenceTTL time.Duration
+	Logger      log.Logger
+}
+
+// envelope is what goes through the channel: the event and the trace context it was published in. The state
+// changes are numbered so that they are replayed in the order they were published.
+type envelope struct {
+	Trace map[string]string `json:"trace,omitempty"`
+	Event []byte            `json:"event"`
+	Seq   int64             `json:"seq,omitempty"`
+}
+
+type Broker struct {
+	cfg  Config
+	id   string
+	pool *redis.Pool
+
+	mu     sync.Mutex
+	users  []string
+	psc    *redis.PubSubConn
+	closed bool
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+var _ chatserver.Broker = (*Broker)(nil)
+
+// carrier lets the propagator read and write the trace context of an envelope
+type carrier map[string]string
+
+func (c carrier) Get(key string) string { return c[key] }
+
+func (c carrier) Set(key, value string) { c[key] = value }
+
+func (c carrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// New connects to Redis and starts refreshing the users of this instance
+func New(cfg Config) (*Broker, error) {
+
+	if cfg.Addr == "" {
+		return nil, errors.New("redisbroker: the address of the Redis server is required")
+	}
+	if cfg.Channel == "" {
+		cfg.Channel = defaultChannel
+	}
+	if cfg.PresenceTTL == 0 {
+		cfg.PresenceTTL = defaultPresenceTTL
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+
+	b := &Broker{
+		cfg: cfg,
+		id:  hex.EncodeToString(id),
+		pool: &redis.Pool{
+			DialContext: func(ctx context.Context) (redis.Conn, error) {
+				return redis.DialContext(ctx, "tcp", cfg.Addr)
+			},
+			MaxIdle:     4,
+			IdleTimeout: time.Minute,
+		},
+		done: make(chan struct{}),
+	}
+	conn, err := b.pool.GetContext(context.Background())
+	if err == nil {
+		_, err = conn.Do("PING")
+		conn.Close()
+	}
+	if err != nil {
+		b.pool.Close()
+		return nil, fmt.Errorf("redisbroker: could not reach %v: %v", cfg.Addr, err)
+	}
+
+	b.wg.Add(1)
+	go b.refresh()
+	return b, nil
+}
+
+// usersKey is the key of the set holding the users of the instance
+func (b *Broker) usersKey(id string) string {
+	return fmt.Sprintf("%v:users:%v", b.cfg.Channel, id)
+}
+
+// stateKey is the key of the hash holding the last state change of every key
+func (b *Broker) stateKey() string {
+	return b.cfg.Channel + ":state"
+}
+
+func (b *Broker) Publish(ctx context.Context, res *chat.StreamResponse) error {
+
+	event, err := proto.Marshal(res)
+	if err != nil {
+		return err
+	}
+	conn, err := b.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	env := envelope{Trace: make(map[string]string), Event: event}
+	otel.GetTextMapPropagator().Inject(ctx, carrier(env.Trace))
+	change := res.GetStateChange()
+	if change != nil {
+		// A change is published once those it follows from were, the sequence keeps them in that order
+		if env.Seq, err = redis.Int64(redis.DoContext(conn, ctx, "INCR", b.cfg.Channel+":seq")); err != nil {
+			return err
+		}
+	}
+	payload, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	if change == nil {
+		_, err = redis.DoContext(conn, ctx, "PUBLISH", b.cfg.Channel, payload)
+		return err
+	}
+	conn.Send("MULTI")
+	conn.Send("HSET", b.stateKey(), change.Key, payload)
+	conn.Send("PUBLISH", b.cfg.Channel, payload)
+	_, err = redis.DoContext(conn, ctx, "EXEC")
+	return err
+}
+
+// decode returns the event of the payload and the context it was published in
+func decode(payload []byte) (context.Context, *chat.StreamResponse, int64, error) {
+
+	var env envelope
+	res := &chat.StreamResponse{}
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return nil, nil, 0, err
+	}
+	if err := proto.Unmarshal(env.Event, res); err != nil {
+		return nil, nil, 0, err
+	}
+	return otel.GetTextMapPropagator().Extract(context.Background(), carrier(env.Trace)), res, env.Seq, nil
+}
+
+// replay delivers the state changes kept so far in the order they were published
+func (b *Broker) replay(handler func(ctx context.Context, res *chat.StreamResponse)) error {
+
+	conn, err := b.pool.GetContext(context.Background())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	payloads, err := redis.StringMap(conn.Do("HGETALL", b.stateKey()))
+	if err != nil {
+		return err
+	}
+
+	type change struct {
+		ctx context.Context
+		res *chat.StreamResponse
+		seq int64
+	}
+	changes := make([]change, 0, len(payloads))
+	for _, payload := range payloads {
+		ctx, res, seq, err := decode([]byte(payload))
+		if err != nil {
+			level.Warn(b.cfg.Logger).Log("message", "dropping a malformed state change", "err", err)
+			continue
+		}
+		changes = append(changes, change{ctx, res, seq})
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].seq < changes[j].seq })
+	for _, c := range changes {
+		handler(c.ctx, c.res)
+	}
+	return nil
+}
+
+// subscribe opens a connection subscribed to the channel, it returns once Redis confirmed the subscription
+func (b *Broker) subscribe() (*redis.PubSubConn, error) {
+
+	conn, err := b.pool.DialContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	psc := &redis.PubSubConn{Conn: conn}
+	if err := psc.Subscribe(b.cfg.Channel); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	switch v := psc.Receive().(type) {
+	case redis.Subscription:
+	case error:
+		conn.Close()
+		return nil, v
+	default:
+		conn.Close()
+		return nil, fmt.Errorf("redisbroker: unexpected reply %v to the subscription", v)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		conn.Close()
+		return nil, errClosed
+	}
+	b.psc = psc
+	return psc, nil
+}
+
+func (b *Broker) Subscribe(handler func(ctx context.Context, res *chat.StreamResponse)) error {
+
+	psc, err := b.subscribe()
+	if err != nil {
+		return err
+	}
+	// The events published from now on wait in the subscription while the state is replayed
+	if err := b.replay(handler); err != nil {
+		psc.Close()
+		return err
+	}
+	b.wg.Add(1)
+	go b.receive(psc, handler)
+	return nil
+}
+
+// receive delivers the events of the channel until the broker is closed, subscribing again when
+// the connection is lost. The events published in the meantime are lost, the state is replayed again.
+func (b *Broker) receive(psc *redis.PubSubConn, handler func(ctx context.Context, res *chat.StreamResponse)) {
+
+	defer b.wg.Done()
+	for {
+		switch v := psc.Receive().(type) {
+		case redis.Message:
+			ctx, res, _, err := decode(v.Data)
+			if err != nil {
+				level.Warn(b.cfg.Logger).Log("message", "dropping a malformed event", "err", err)
+				continue
+			}
+			handler(ctx, res)
+
+		case error:
+			psc.Close()
+			for {
+				select {
+				case <-b.done:
+					return
+				default:
+				}
+				level.Warn(b.cfg.Logger).Log("message", "lost the subscription, subscribing again", "err", v)
+				var err error
+				if psc, err = b.subscribe(); err == nil {
+					if err = b.replay(handler); err == nil {
+						break
+					}
+					psc.Close()
+				}
+				if err == errClosed {
+					return
+				}
+				v = err
+				select {
+				case <-time.After(resubscribeDelay):
+				case <-b.done:
+					return
+				}
+			}
+		}
+	}
+}
+
+// writeUsers replaces the users of this instance with users and renews their expiry
+func (b *Broker) writeUsers(ctx context.Context, users []string) error {
+
+	conn, err := b.pool.GetContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	key := b.usersKey(b.id)
+	conn.Send("MULTI")
+	conn.Send("DEL", key)
+	if len(users) > 0 {
+		conn.Send("SADD", redis.Args{}.Add(key).AddFlat(users)...)
+		conn.Send("PEXPIRE", key, b.cfg.PresenceTTL.Milliseconds())
+	}
+	_, err = redis.DoContext(conn, ctx, "EXEC")
+	return err
+}
+
+func (b *Broker) SetLocalUsers(ctx context.Context, users []string) error {
+
+	b.mu.Lock()
+	b.users = append([]string(nil), users...)
+	b.mu.Unlock()
+	return b.writeUsers(ctx, users)
+}
+
+// refresh keeps the users of this instance from expiring
+func (b *Broker) refresh() {
+
+	defer b.wg.Done()
+	ticker := time.NewTicker(b.cfg.PresenceTTL / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-b.done:
+			return
+		}
+		b.mu.Lock()
+		users := b.users
+		b.mu.Unlock()
+		ctx, cancel := context.WithTimeout(context.Background(), b.cfg.PresenceTTL/3)
+		if err := b.writeUsers(ctx, users); err != nil {
+			level.Warn(b.cfg.Logger).Log("message", "failed to refresh the users of the instance", "err", err)
+		}
+		cancel()
+	}
+}
+
+// Claim sets the key of the claim unless it is set already, it expires after ttl
+func (b *Broker) Claim(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+
+	conn, err := b.pool.GetContext(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	reply, err := redis.DoContext(conn, ctx, "SET", fmt.Sprintf("%v:claims:%v", b.cfg.Channel, key), b.id, "NX", "PX", ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	return reply != nil, nil
+}
+
+func (b *Broker) RemoteUsers(ctx context.Context) ([]string, error) {
+
+	conn, err := b.pool.GetContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	own := b.usersKey(b.id)
+	seen := make(map[string]bool)
+	var users []string
+	cursor := 0
+	for {
+		reply, err := redis.Values(redis.DoContext(conn, ctx, "SCAN", cursor, "MATCH", b.usersKey("*"), "COUNT", 100))
+		if err != nil {
+			return nil, err
+		}
+		var keys []string
+		if _, err := redis.Scan(reply, &cursor, &keys); err != nil {
+			return nil, err
+		}
+		for _, key := range keys {
+			if key == own {
+				continue
+			}
+			members, err := redis.Strings(redis.DoContext(conn, ctx, "SMEMBERS", key))
+			if err != nil {
+				return nil, err
+			}
+			for _, name := range members {
+				if !seen[name] {
+					seen[name] = true
+					users = append(users, name)
+				}
+			}
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	sort.Strings(users)
+	return users, nil
+}
+
+// Close stops the deliveries and removes the users of this instance right away
+func (b *Broker) Close() error {
+
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+	if b.psc != nil {
+		b.psc.Close()
+	}
+	b.mu.Unlock()
+	b.wg.Wait()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err := b.writeUsers(ctx, nil)
+	if closeErr := b.pool.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
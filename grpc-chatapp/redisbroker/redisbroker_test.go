@@ -0,0 +1,305 @@
+package redisbroker
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestMain(m *testing.M) {
+	// The timers of the gRPC-Web wrapper are run by a goroutine started on init
+	goleak.VerifyTestMain(m, goleak.IgnoreTopFunction("github.com/desertbit/timer.timerRoutine"))
+}
+
+// newBroker returns a broker on mr, closed at the end of the test
+func newBroker(t *testing.T, mr *miniredis.Miniredis, ttl time.Duration) *Broker {
+
+	t.Helper()
+	b, err := New(Config{Addr: mr.Addr(), PresenceTTL: ttl})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func TestInstances(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+	first := chattest.NewServer(t, chatserver.Options{Broker: newBroker(t, mr, 0)})
+	second := chattest.NewServer(t, chatserver.Options{Broker: newBroker(t, mr, 0)})
+
+	alice := first.Client("alice")
+	bob := second.Client("bob")
+	if login := alice.Next(chatclient.Login{}).(chatclient.Login); login.Name != "bob" {
+		t.Fatalf("alice saw %v log in, want bob", login.Name)
+	}
+
+	ctx := context.Background()
+	for _, c := range []*chattest.Client{alice, bob} {
+		users, err := c.ListUsers(ctx)
+		if err != nil {
+			t.Fatalf("ListUsers() error = %v", err)
+		}
+		if want := []string{"alice", "bob"}; !reflect.DeepEqual(users, want) {
+			t.Fatalf("ListUsers() from %v = %v, want %v", c.Name(), users, want)
+		}
+	}
+
+	// Every message goes through Redis, including to the clients of the instance it was sent to
+	for _, tt := range []struct {
+		from *chattest.Client
+		text string
+	}{{alice, "hello"}, {bob, "hi"}} {
+		if err := tt.from.Send(ctx, "", tt.text); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+		for _, c := range []*chattest.Client{alice, bob} {
+			got := c.Next(chatclient.Message{}).(chatclient.Message)
+			if got.Name != tt.from.Name() || got.Text != tt.text {
+				t.Fatalf("%v received %q from %v, want %q from %v", c.Name(), got.Text, got.Name, tt.text, tt.from.Name())
+			}
+		}
+	}
+
+	if err := bob.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if logout := alice.Next(chatclient.Logout{}).(chatclient.Logout); logout.Name != "bob" {
+		t.Fatalf("alice saw %v log out, want bob", logout.Name)
+	}
+	users, err := alice.ListUsers(ctx)
+	if err != nil {
+		t.Fatalf("ListUsers() error = %v", err)
+	}
+	if want := []string{"alice"}; !reflect.DeepEqual(users, want) {
+		t.Fatalf("ListUsers() after bob left = %v, want %v", users, want)
+	}
+}
+
+func TestPresence(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+	const ttl = 30 * time.Second
+	brokers := []*Broker{newBroker(t, mr, ttl), newBroker(t, mr, ttl), newBroker(t, mr, ttl)}
+	ctx := context.Background()
+	for i, users := range [][]string{{"alice"}, {"carol", "bob"}, {"bob", "dave"}} {
+		if err := brokers[i].SetLocalUsers(ctx, users); err != nil {
+			t.Fatalf("SetLocalUsers() error = %v", err)
+		}
+	}
+
+	remote := func(b *Broker) []string {
+		t.Helper()
+		users, err := b.RemoteUsers(ctx)
+		if err != nil {
+			t.Fatalf("RemoteUsers() error = %v", err)
+		}
+		return users
+	}
+
+	tests := []struct {
+		name string
+		step func()
+		want []string
+	}{
+		{"all instances up", func() {}, []string{"bob", "carol", "dave"}},
+		{"users replaced", func() { brokers[1].SetLocalUsers(ctx, []string{"erin"}) }, []string{"bob", "dave", "erin"}},
+		{"instance closed", func() { brokers[2].Close() }, []string{"erin"}},
+		// The refresh is on wall clock time, the set of the instance expires when time jumps past the TTL
+		{"instance stopped refreshing", func() { mr.FastForward(ttl) }, nil},
+	}
+	for _, tt := range tests {
+		tt.step()
+		if got := remote(brokers[0]); !reflect.DeepEqual(got, tt.want) {
+			t.Fatalf("%v: RemoteUsers() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResubscribe(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+	first := chattest.NewServer(t, chatserver.Options{Broker: newBroker(t, mr, 0)})
+	second := chattest.NewServer(t, chatserver.Options{Broker: newBroker(t, mr, 0)})
+	alice, bob := first.Client("alice"), second.Client("bob")
+	alice.Next(chatclient.Login{})
+
+	// Restarting Redis drops the subscriptions, the brokers subscribe again
+	mr.Restart()
+	ctx := context.Background()
+	deadline := time.Now().Add(chattest.Timeout)
+	for mr.PubSubNumSub("chat")["chat"] < 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("the brokers did not subscribe again")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := alice.Send(ctx, "", "still there?"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Text != "still there?" {
+		t.Fatalf("bob received %q, want the message sent after the restart", got.Text)
+	}
+}
+
+// eventually retries the call until it succeeds, the state changes reach the other instances asynchronously
+func eventually(t *testing.T, what string, call func() error) {
+
+	t.Helper()
+	deadline := time.Now().Add(chattest.Timeout)
+	for {
+		err := call()
+		if err == nil {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%v error = %v", what, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestSharedState(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+	const diceKey = "dice-0123456789abcdef"
+	opts := func() chatserver.Options {
+		return chatserver.Options{
+			Broker:           newBroker(t, mr, 0),
+			Bots:             []chatserver.Bot{{Name: "dice", APIKey: diceKey}},
+			IncomingWebhooks: []chatserver.IncomingWebhook{{Room: "ops", Name: "ci", Token: "secret"}},
+		}
+	}
+	first, second := chattest.NewServer(t, opts()), chattest.NewServer(t, opts())
+	alice := first.Client("alice")
+	bob := second.Client("bob")
+	alice.Next(chatclient.Login{})
+
+	// alice is the first user of the chat, the owner of it and of the rooms created, on every instance
+	ctx := context.Background()
+	for room, visibility := range map[string]chat.Visibility{"ops": chat.Visibility_PRIVATE, "dev": chat.Visibility_PUBLIC} {
+		if err := alice.CreateRoom(ctx, room, visibility); err != nil {
+			t.Fatalf("CreateRoom(%v) error = %v", room, err)
+		}
+	}
+	if err := alice.Invite(ctx, "ops", "bob"); err != nil {
+		t.Fatalf("Invite() error = %v", err)
+	}
+	eventually(t, "AcceptInvite() on the other instance", func() error { return bob.AcceptInvite(ctx, "ops") })
+	eventually(t, "GrantRole() to alice by bob", func() error {
+		if err := bob.GrantRole(ctx, "ops", "alice", chat.Role_GUEST); status.Code(err) != codes.PermissionDenied {
+			return fmt.Errorf("%v, want %v", err, codes.PermissionDenied)
+		}
+		return nil
+	})
+	if err := alice.GrantRole(ctx, "", "bob", chat.Role_MODERATOR); err != nil {
+		t.Fatalf("GrantRole() by the owner error = %v", err)
+	}
+
+	// The bot connected to the first instance answers the invocations of the second one
+	client := chat.NewChatClient(first.Dial())
+	streamCtx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(ctx, "x-chat-token", diceKey))
+	defer cancel()
+	dice, err := client.Stream(streamCtx)
+	if err != nil {
+		t.Fatalf("Stream() error = %v", err)
+	}
+	if _, err := dice.Header(); err != nil {
+		t.Fatalf("Header() error = %v", err)
+	}
+	if _, err := client.RegisterCommand(ctx, &chat.RegisterCommandRequest{Token: diceKey, Command: "roll"}); err != nil {
+		t.Fatalf("RegisterCommand() error = %v", err)
+	}
+	eventually(t, "ListCommands() on the other instance", func() error {
+		res, err := chat.NewChatClient(second.Dial()).ListCommands(ctx, &chat.ListCommandsRequest{Token: diceKey})
+		if err == nil && len(res.Commands) != 1 {
+			err = fmt.Errorf("%v commands, want roll", len(res.Commands))
+		}
+		return err
+	})
+	if err := bob.Send(ctx, "ops", "/roll 2d6"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	for {
+		res, err := dice.Recv()
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		if inv := res.GetCommandInvocation(); inv != nil {
+			if inv.Name != "bob" || inv.Room != "ops" || inv.Args != "2d6" {
+				t.Fatalf("dice received %v, want the invocation of bob", inv)
+			}
+			break
+		}
+	}
+	if err := dice.Send(&chat.StreamRequest{Room: "ops", Message: "7"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Name != "dice" || got.Text != "7" {
+		t.Fatalf("bob received %q from %v, want the answer of dice", got.Text, got.Name)
+	}
+
+	// An instance started later replays the state
+	third := chat.NewChatClient(chattest.NewServer(t, opts()).Dial())
+	rooms, err := third.ListRooms(ctx, &chat.ListRoomsRequest{Token: diceKey})
+	if err != nil {
+		t.Fatalf("ListRooms() error = %v", err)
+	}
+	var names []string
+	for _, r := range rooms.Rooms {
+		names = append(names, r.Name)
+	}
+	if want := []string{"dev", "lobby"}; !reflect.DeepEqual(names, want) {
+		t.Fatalf("ListRooms() on the third instance = %v, want %v", names, want)
+	}
+	commands, err := third.ListCommands(ctx, &chat.ListCommandsRequest{Token: diceKey})
+	if err != nil || len(commands.Commands) != 1 || commands.Commands[0].Command != "roll" {
+		t.Fatalf("ListCommands() on the third instance = %v, %v, want roll", commands, err)
+	}
+}
+
+func TestClaim(t *testing.T) {
+
+	mr := miniredis.RunT(t)
+	first, second := newBroker(t, mr, 0), newBroker(t, mr, 0)
+	ctx := context.Background()
+	const ttl = time.Minute
+
+	tests := []struct {
+		name   string
+		broker *Broker
+		key    string
+		want   bool
+	}{
+		{"first claim", first, "federation:teamA:1", true},
+		{"claimed by another instance", second, "federation:teamA:1", false},
+		{"claimed again", first, "federation:teamA:1", false},
+		{"another key", second, "federation:teamA:2", true},
+	}
+	for _, tt := range tests {
+		got, err := tt.broker.Claim(ctx, tt.key, ttl)
+		if err != nil {
+			t.Fatalf("%v: Claim() error = %v", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%v: Claim(%v) = %v, want %v", tt.name, tt.key, got, tt.want)
+		}
+	}
+	mr.FastForward(ttl)
+	if got, _ := second.Claim(ctx, "federation:teamA:1", ttl); !got {
+		t.Errorf("Claim() once the claim expired = %v, want true", got)
+	}
+}
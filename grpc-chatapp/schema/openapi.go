@@ -0,0 +1,8 @@
+package schema
+
+import _ "embed"
+
+// OpenAPI is the OpenAPI 2.0 spec of the REST gateway, generated along with chat.pb.gw.go
+//
+//go:embed chat.swagger.json
+var OpenAPI []byte
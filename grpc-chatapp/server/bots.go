@@ -0,0 +1,14 @@
+package main
+
+import "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+
+// loadBots reads the JSON list of the bot accounts in the file, e.g.
+// [{"name": "dice", "api_key": "0f1e2d3c4b5a69788796a5b4c3d2e1f0"}]
+func loadBots(file string) ([]chatserver.Bot, error) {
+
+	var bots []chatserver.Bot
+	if err := readJSON(file, &bots); err != nil {
+		return nil, err
+	}
+	return bots, nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+)
+
+func TestLoadBots(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "bots.json")
+	content := `[{"name": "dice", "api_key": "0f1e2d3c4b5a69788796a5b4c3d2e1f0"}]`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadBots(file)
+	want := []chatserver.Bot{{Name: "dice", APIKey: "0f1e2d3c4b5a69788796a5b4c3d2e1f0"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("loadBots() = %v, %v, want %v", got, err, want)
+	}
+}
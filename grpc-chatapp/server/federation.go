@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+)
+
+// parsePeers parses a comma separated list of domain=address pairs
+func parsePeers(value string) ([]chatserver.Peer, error) {
+
+	var peers []chatserver.Peer
+	for _, pair := range strings.Split(value, ",") {
+		if pair == "" {
+			continue
+		}
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid peer %q, want domain=address", pair)
+		}
+		peers = append(peers, chatserver.Peer{Domain: parts[0], Address: parts[1]})
+	}
+	return peers, nil
+}
+
+// federationConfig loads the certificates of the federation, rooms and peers are comma separated
+func federationConfig(domain, rooms, peers, certFile, keyFile, caFile string) (*chatserver.Federation, error) {
+
+	cfg := &chatserver.Federation{Domain: domain}
+	if rooms != "" {
+		cfg.Rooms = strings.Split(rooms, ",")
+	}
+	var err error
+	if cfg.Peers, err = parsePeers(peers); err != nil {
+		return nil, err
+	}
+	if cfg.Certificate, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		return nil, err
+	}
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+	cfg.CAs = x509.NewCertPool()
+	if !cfg.CAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificate found in %v", caFile)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+)
+
+func TestParsePeers(t *testing.T) {
+
+	tests := []struct {
+		value   string
+		want    []chatserver.Peer
+		wantErr bool
+	}{
+		{"", nil, false},
+		{"teamB=b.example.com:50052", []chatserver.Peer{{Domain: "teamB", Address: "b.example.com:50052"}}, false},
+		{"teamB=b:50052,teamC=c:50052", []chatserver.Peer{{Domain: "teamB", Address: "b:50052"}, {Domain: "teamC", Address: "c:50052"}}, false},
+		{"b:50052", nil, true},
+		{"teamB=", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parsePeers(tt.value)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parsePeers(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
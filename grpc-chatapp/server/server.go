@@ -2,302 +2,274 @@ package main
 
 import (
 	"context"
-	"crypto/rand"
+	"flag"
 	"fmt"
-	"io"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
-	"sync"
+	"strings"
 	"syscall"
 	"time"
 
-	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
-	"github.com/golang/protobuf/ptypes"
-	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
-	"google.golang.org/grpc"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/metadata"
-	"google.golang.org/grpc/status"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/logging"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/redisbroker"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/tracing"
 )
 
 const (
-	tokenHeader         = "x-chat-token"
-	tokenSize           = 4
-	responseChannelSize = 20
-	streamChannelSize   = 100
-	grpcAddress         = "0.0.0.0:50051"
+	grpcAddress        = "0.0.0.0:50051"
+	metricsAddress     = "0.0.0.0:9090"
+	gatewayAddress     = "0.0.0.0:8080"
+	defaultGracePeriod = 10 * time.Second
+	defaultRateBurst   = 10
 )
 
-type server struct {
-	CommonChannel          chan chat.StreamResponse
-	ClientName             map[string]string
-	ClientStream           map[string]chan chat.StreamResponse
-	nameMutex, streamMutex sync.RWMutex
-	logger                 log.Logger
-}
-
-func (s *server) generateToken() (string, error) {
-
-	level.Debug(s.logger).Log("message", "started generating token")
-	txt := make([]byte, tokenSize)
-	_, err := rand.Read(txt)
-	if err != nil {
-		level.Error(s.logger).Log("error", "error while generating the token")
-		return "", err
+func handleSigterm(ctx context.Context, c chan os.Signal, cancel context.CancelFunc) {
+	select {
+	case <-c:
+	case <-ctx.Done():
 	}
-	level.Debug(s.logger).Log("message", "finished generating token")
-	return fmt.Sprintf("%x", txt), nil
-}
-
-func (s *server) addClientName(username string, tkn string) {
-
-	s.nameMutex.RLock()
-	defer s.nameMutex.RUnlock()
-	level.Debug(s.logger).Log("message", "adding the client name", "client", username, "token", tkn)
-	s.ClientName[tkn] = username
-
+	cancel()
 }
 
-func (s *server) getClientName(tkn string) (string, bool) {
-
-	s.nameMutex.RLock()
-	defer s.nameMutex.RUnlock()
-	level.Debug(s.logger).Log("message", "getting the client name", "token", tkn)
-	name, ok := s.ClientName[tkn]
-	return name, ok
+type config struct {
+	chatserver.Options
+	metricsAddress string
+	gracePeriod    time.Duration
+	// federationAddress is where the peers are served when federated
+	federationAddress string
+	// gatewayAddress is where the REST gateway is served, it is not served when empty
+	gatewayAddress string
+	// ircAddress is where the IRC clients are served, they are not served when empty
+	ircAddress string
 }
 
-func (s *server) removeClientName(tkn string) string {
+// run serves the chat on the listener until it receives SIGTERM or an interrupt, and then shuts it down
+func run(cfg config, lis net.Listener, logs *logging.Logging) error {
 
-	s.nameMutex.RLock()
-	defer s.nameMutex.RUnlock()
-	level.Debug(s.logger).Log("message", "removing the client token", "token", tkn)
-	username := s.ClientName[tkn]
-	delete(s.ClientName, tkn)
-
-	return username
-}
+	// Initialise the initial setup
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-func (s *server) Login(ctx context.Context, req *chat.LoginRequest) (*chat.LoginResponse, error) {
+	// sigterm handler
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c)
+	go handleSigterm(ctx, c, cancel)
 
-	// TODO: handle same name people in the chat
-	// Generate a token
-	level.Info(s.logger).Log("message", "new client login request", "req", req)
-	tkn, err := s.generateToken()
+	logger := logs.Component("main")
+	cfg.Logging = logs
+	s, err := chatserver.NewServer(cfg.Options)
 	if err != nil {
-		level.Error(s.logger).Log("error", "login failed for the request", req)
-	}
-	// Add the token in the client name
-	s.addClientName(req.Username, tkn)
-	// Send in a notif that broadcast is successful
-	level.Info(s.logger).Log("message", "login is successful", "req", req)
-	s.CommonChannel <- chat.StreamResponse{
-		Timestamp: ptypes.TimestampNow(),
-		Event: &chat.StreamResponse_ClientLogin{
-			&chat.StreamResponse_Login{
-				Name: req.Username,
-			},
-		},
-	}
-
-	// Return a response
-	return &chat.LoginResponse{
-		Token: tkn,
-	}, nil
-
-}
-
-func (s *server) Logout(ctx context.Context, req *chat.LogoutRequest) (*chat.LogoutResponse, error) {
-
-	level.Info(s.logger).Log("message", "new client logout request", "req", req)
-	tkn := req.Token
-	// Remove the name from the Client Name map
-	username := s.removeClientName(tkn)
-	// Send in a broadcast that the client has been removed
-	level.Info(s.logger).Log("message", "logout is successful", "req", req)
-	s.CommonChannel <- chat.StreamResponse{
-		Timestamp: ptypes.TimestampNow(),
-		Event: &chat.StreamResponse_ClientLogout{
-			&chat.StreamResponse_Logout{
-				Name: username,
-			},
-		},
+		level.Error(logger).Log("error", "failed to set up the server", "err", err)
+		return err
 	}
-	// Return a response
-	return &chat.LogoutResponse{}, nil
-}
-
-func (s *server) broadcast() {
 
-	for res := range s.CommonChannel {
-
-		s.streamMutex.RLock()
-		for _, stream := range s.ClientStream {
-			// Push in common message into specific client channel
-			stream <- res
+	serveErr := make(chan error, 5)
+	go func() {
+		if err := s.Serve(lis); err != nil {
+			level.Error(logger).Log("error", "failed to listen the server, exiting..", "err", err)
+			serveErr <- err
+			cancel()
 		}
-		s.streamMutex.RUnlock()
-	}
-}
-
-func (s *server) OpenStream(tkn string) chan chat.StreamResponse {
-	stream := make(chan chat.StreamResponse, streamChannelSize)
-	s.streamMutex.RLock()
-	defer s.streamMutex.RUnlock()
-	level.Debug(s.logger).Log("message", "opening the stream", "token", tkn)
-	s.ClientStream[tkn] = stream
-	return stream
-}
-
-func (s *server) CloseStream(tkn string) {
-	s.streamMutex.RLock()
-	defer s.streamMutex.RUnlock()
-	level.Debug(s.logger).Log("message", "closing the stream", "token", tkn)
-	delete(s.ClientStream, tkn)
-}
-
-func (s *server) extractToken(ctx context.Context) (string, bool) {
-
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok || len(md[tokenHeader]) == 0 {
-		return "", false
-	}
-	tkn := md[tokenHeader][0]
-	level.Debug(s.logger).Log("message", "successfully extracted the token", "token", tkn)
-	return tkn, true
-}
-
-func (s *server) broadcastAll(srv_stream chat.Chat_StreamServer, tkn string) {
-
-	stream := s.OpenStream(tkn)
-	defer s.CloseStream(tkn)
-
-	s.nameMutex.RLock()
-	level.Info(s.logger).Log("message", "started the broadcast for the given client", "username", s.ClientName[tkn])
-	s.nameMutex.RUnlock()
-
-	for {
-
-		select {
-		case <-srv_stream.Context().Done():
-			s.nameMutex.RLock()
-			level.Info(s.logger).Log("message", "closing the broadcast for the given client", "username", s.ClientName[tkn])
-			s.nameMutex.RUnlock()
-			return
+	}()
 
-		case res := <-stream:
-			err := srv_stream.Send(&res)
-			if err != nil {
-				level.Error(s.logger).Log("error", "error while sending the stream", err)
-			}
+	if cfg.gatewayAddress != "" {
+		gatewayLis, err := net.Listen("tcp", cfg.gatewayAddress)
+		if err != nil {
+			level.Error(logger).Log("error", "failed to listen for the gateway, exiting..", "err", err)
+			s.Shutdown(ctx)
+			return err
 		}
+		go func() {
+			if err := s.ServeGateway(gatewayLis); err != nil {
+				level.Error(logger).Log("error", "failed to serve the gateway, exiting..", "err", err)
+				serveErr <- err
+				cancel()
+			}
+		}()
 	}
-}
-
-func (s *server) Stream(srv_stream chat.Chat_StreamServer) error {
-
-	tkn, ok := s.extractToken(srv_stream.Context())
-	if !ok {
-		return status.Error(codes.Unauthenticated, "missing token header")
-	}
-	name, ok := s.getClientName(tkn)
-	if !ok {
-		return status.Error(codes.InvalidArgument, "username not found!")
-	}
-
-	// go routine for sending all individual client messages from individual clientQueue to the client
-	go s.broadcastAll(srv_stream, tkn)
-	// Receive messages and push it to the common queue
-	for {
-
-		req, err := srv_stream.Recv()
-		if err == io.EOF {
-			s.nameMutex.RLock()
-			level.Info(s.logger).Log("message", "client disconnected, closing..", "username", s.ClientName[tkn])
-			s.nameMutex.RUnlock()
-			break
-		}
+	if cfg.ircAddress != "" {
+		ircLis, err := net.Listen("tcp", cfg.ircAddress)
 		if err != nil {
-			level.Error(s.logger).Log("error", "error while receiving ", "err", err)
+			level.Error(logger).Log("error", "failed to listen for the IRC clients, exiting..", "err", err)
+			s.Shutdown(ctx)
 			return err
 		}
-
-		s.CommonChannel <- chat.StreamResponse{
-			Timestamp: ptypes.TimestampNow(),
-			Event: &chat.StreamResponse_ClientMessage{
-				&chat.StreamResponse_Message{
-					Name:    name,
-					Message: req.Message,
-				},
-			},
+		go func() {
+			if err := s.ServeIRC(ircLis); err != nil {
+				level.Error(logger).Log("error", "failed to serve the IRC clients, exiting..", "err", err)
+				serveErr <- err
+				cancel()
+			}
+		}()
+	}
+	if cfg.Federation != nil {
+		federationLis, err := net.Listen("tcp", cfg.federationAddress)
+		if err != nil {
+			level.Error(logger).Log("error", "failed to listen for the peers, exiting..", "err", err)
+			s.Shutdown(ctx)
+			return err
 		}
+		go func() {
+			if err := s.ServeFederation(federationLis); err != nil {
+				level.Error(logger).Log("error", "failed to serve the peers, exiting..", "err", err)
+				serveErr <- err
+				cancel()
+			}
+		}()
 	}
 
-	<-srv_stream.Context().Done()
-	return srv_stream.Context().Err()
-}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", s.MetricsHandler())
+	metricsServer := &http.Server{Addr: cfg.metricsAddress, Handler: mux}
+	go func() {
+		if err := metricsServer.ListenAndServe(); err != http.ErrServerClosed {
+			level.Error(logger).Log("error", "failed to serve the metrics, exiting..", "err", err)
+			serveErr <- err
+			cancel()
+		}
+	}()
 
-func handleSigterm(c chan os.Signal, cancel context.CancelFunc) {
-	<-c
-	cancel()
+	<-ctx.Done()
+	shutdownCtx, cancelShutdown := context.WithTimeout(context.Background(), cfg.gracePeriod)
+	defer cancelShutdown()
+	s.Shutdown(shutdownCtx)
+	metricsServer.Close()
+
+	select {
+	case err := <-serveErr:
+		return err
+	default:
+		return nil
+	}
 }
 
 func main() {
 
-	// Initialise the initial setup
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
-
-	// sigterm handler
-	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
-	go handleSigterm(c, cancel)
+	grpcAddress := flag.String("grpc.address", grpcAddress, "address the gRPC server listens on")
+	metricsAddress := flag.String("metrics.address", metricsAddress, "address the metrics are served on")
+	gatewayAddress := flag.String("gateway.address", gatewayAddress, "address the REST gateway is served on, it is not served when empty")
+	ircAddress := flag.String("irc.address", "", "address the IRC clients are served on, e.g. :6667, they are not served when empty")
+	grpcWebOrigins := flag.String("gateway.grpc-web.origins", "", "comma separated origins allowed to call the chat with gRPC-Web from other pages, * allows them all")
+	gracePeriod := flag.Duration("shutdown.grace", defaultGracePeriod, "how long the clients get to disconnect on shutdown")
+	shutdownReason := flag.String("shutdown.reason", chatserver.DefaultShutdownReason, "reason sent to the clients on shutdown")
+	restartETA := flag.Duration("shutdown.restart-eta", 0, "how long until the server is back after a shutdown, 0 when it is not restarting")
+	alternates := flag.String("shutdown.alternates", "", "comma separated addresses of the servers clients can move to on shutdown")
+	rateLimit := flag.Float64("rate-limit", 0, "messages per second every user, bot and incoming webhook may send in the long run, no limit when 0")
+	rateBurst := flag.Int("rate-limit.burst", defaultRateBurst, "messages every user, bot and incoming webhook may send in a row")
+	sessionTimeout := flag.Duration("session.timeout", chatserver.DefaultSessionTimeout, "how long a session lasts without a stream open nor a call made before its name is released")
+	enableReflection := flag.Bool("reflection", false, "enable gRPC server reflection")
+	tlsCert := flag.String("tls.cert", "", "certificate to serve the chat over TLS with, plaintext when empty")
+	tlsKey := flag.String("tls.key", "", "key of the TLS certificate")
+	redisAddress := flag.String("broker.redis.address", "", "Redis server the instances share the chat, its rooms and its roles through, the server runs alone when empty")
+	redisChannel := flag.String("broker.redis.channel", "chat", "Redis channel of the chat, the instances using it form one chat")
+	federationAddress := flag.String("federation.address", "0.0.0.0:50061", "address the federation peers are served on")
+	federationDomain := flag.String("federation.domain", "", "domain of the deployment, the server is not federated when empty")
+	federationRooms := flag.String("federation.rooms", "", "comma separated rooms shared with the peers")
+	federationPeers := flag.String("federation.peers", "", "comma separated domain=address of the peers")
+	federationCert := flag.String("federation.cert", "", "certificate of the domain presented to the peers")
+	federationKey := flag.String("federation.key", "", "key of the federation certificate")
+	federationCA := flag.String("federation.ca", "", "CA certificates the certificates of the peers are verified with")
+	webhooksFile := flag.String("webhooks.config", "", "JSON file listing the outgoing webhooks, none when empty")
+	incomingFile := flag.String("webhooks.incoming", "", "JSON file listing the incoming webhooks served on the gateway, none when empty")
+	botsFile := flag.String("bots.config", "", "JSON file listing the bot accounts and their API keys, none when empty")
+	logFormat := flag.String("log.format", logging.FormatLogfmt, "log format: logfmt or json")
+	logLevels := logging.Levels{Default: "info"}
+	flag.Var(&logLevels, "log.level", "log levels, the default level followed by per component ones e.g. info,broadcast=debug")
+	traceExporter := flag.String("trace.exporter", tracing.ExporterNone, "where to export the traces: none, stdout or file")
+	traceFile := flag.String("trace.file", "server-traces.json", "file the traces are written to with the file exporter")
+	flag.Parse()
 
 	// Initialise the logger
-	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stdout))
-	logger = level.NewFilter(logger, level.AllowInfo())
-	logger = log.With(logger, "ts", time.Now().Format(time.RFC1123), "caller", log.DefaultCaller)
-
-	level.Info(logger).Log("message", "server started listening")
-
-	lis, err := net.Listen("tcp", grpcAddress)
+	logs, err := logging.New(os.Stdout, *logFormat, logLevels)
 	if err != nil {
-		level.Error(logger).Log("error", "failed to listen the server, exiting..")
+		fmt.Fprintf(os.Stderr, "failed to set up logging: %v\n", err)
 		os.Exit(1)
 	}
+	logger := logs.Component("main")
 
-	s := grpc.NewServer()
+	shutdownTracing, err := tracing.Setup("chat-server", *traceExporter, *traceFile)
+	if err != nil {
+		level.Error(logger).Log("error", "failed to set up tracing, exiting..", "err", err)
+		os.Exit(1)
+	}
+	defer shutdownTracing(context.Background())
 
-	customServer := server{
-		CommonChannel: make(chan chat.StreamResponse, responseChannelSize),
-		ClientName:    make(map[string]string),
-		ClientStream:  make(map[string]chan chat.StreamResponse),
-		logger:        logger,
+	lis, err := net.Listen("tcp", *grpcAddress)
+	if err != nil {
+		level.Error(logger).Log("error", "failed to listen the server, exiting..", "err", err)
+		os.Exit(1)
 	}
-	chat.RegisterChatServer(s, &customServer)
-	level.Debug(logger).Log("message", "registered the server")
-	// Have a go routine that would have a map of all channels and push all the messages from the commonChannel
-	// to the individual specific client channel
-	level.Debug(logger).Log("message", "started the broadcast of messages")
-	go customServer.broadcast()
 
-	go func() {
-		if err := s.Serve(lis); err != nil {
-			level.Error(logger).Log("error", "failed to listen the server, exiting..")
-			cancel()
+	cfg := config{
+		Options: chatserver.Options{
+			EnableReflection: *enableReflection,
+			TLSCert:          *tlsCert,
+			TLSKey:           *tlsKey,
+			ShutdownReason:   *shutdownReason,
+			RestartETA:       *restartETA,
+			SessionTimeout:   *sessionTimeout,
+			RateLimit:        chatserver.RateLimit{PerSecond: *rateLimit, Burst: *rateBurst},
+		},
+		metricsAddress:    *metricsAddress,
+		gracePeriod:       *gracePeriod,
+		federationAddress: *federationAddress,
+		gatewayAddress:    *gatewayAddress,
+		ircAddress:        *ircAddress,
+	}
+	if *alternates != "" {
+		cfg.AlternateAddresses = strings.Split(*alternates, ",")
+	}
+	if *grpcWebOrigins != "" {
+		cfg.GRPCWebOrigins = strings.Split(*grpcWebOrigins, ",")
+	}
+	if *redisAddress != "" {
+		broker, err := redisbroker.New(redisbroker.Config{
+			Addr:    *redisAddress,
+			Channel: *redisChannel,
+			Logger:  logs.Component("broker"),
+		})
+		if err != nil {
+			level.Error(logger).Log("error", "failed to connect to the broker, exiting..", "err", err)
+			os.Exit(1)
 		}
-	}()
-
-	<-ctx.Done()
-	level.Info(logger).Log("message", "sending shutdown notification")
-	customServer.CommonChannel <- chat.StreamResponse{
-		Timestamp: ptypes.TimestampNow(),
-		Event:     &chat.StreamResponse_ServerShutdown{},
+		cfg.Broker = broker
+	}
+	if *webhooksFile != "" {
+		cfg.Webhooks, err = loadWebhooks(*webhooksFile)
+		if err != nil {
+			level.Error(logger).Log("error", "failed to load the webhooks, exiting..", "err", err)
+			os.Exit(1)
+		}
+	}
+	if *incomingFile != "" {
+		cfg.IncomingWebhooks, err = loadIncomingWebhooks(*incomingFile)
+		if err != nil {
+			level.Error(logger).Log("error", "failed to load the incoming webhooks, exiting..", "err", err)
+			os.Exit(1)
+		}
+	}
+	if *botsFile != "" {
+		cfg.Bots, err = loadBots(*botsFile)
+		if err != nil {
+			level.Error(logger).Log("error", "failed to load the bots, exiting..", "err", err)
+			os.Exit(1)
+		}
+	}
+	if *federationDomain != "" {
+		cfg.Federation, err = federationConfig(*federationDomain, *federationRooms, *federationPeers, *federationCert, *federationKey, *federationCA)
+		if err != nil {
+			level.Error(logger).Log("error", "failed to set up the federation, exiting..", "err", err)
+			os.Exit(1)
+		}
+	}
+	err = run(cfg, lis, logs)
+	if err != nil {
+		shutdownTracing(context.Background())
+		os.Exit(1)
 	}
-	level.Info(logger).Log("message", "closing the channel")
-	close(customServer.CommonChannel)
-	level.Info(logger).Log("message", "graceful shutdown")
-	s.GracefulStop()
 }
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"net"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/logging"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"go.uber.org/goleak"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestShutdownOnSigterm(t *testing.T) {
+
+	defer goleak.VerifyNone(t, goleak.IgnoreCurrent())
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	logs, err := logging.New(ioutil.Discard, logging.FormatLogfmt, logging.Levels{Default: "info"})
+	if err != nil {
+		t.Fatalf("logging.New() error = %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- run(config{
+			Options: chatserver.Options{
+				ShutdownReason:     "upgrade",
+				RestartETA:         time.Minute,
+				AlternateAddresses: []string{"backup:50051"},
+			},
+			metricsAddress: "127.0.0.1:0",
+			gracePeriod:    5 * time.Second,
+		}, lis, logs)
+	}()
+
+	cc, err := grpc.Dial(lis.Addr().String(), grpc.WithInsecure(), grpc.WithBlock())
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer cc.Close()
+	client := chat.NewChatClient(cc)
+
+	var streams []chat.Chat_StreamClient
+	for _, name := range []string{"alice", "bob"} {
+		res, err := client.Login(context.Background(), &chat.LoginRequest{Username: name})
+		if err != nil {
+			t.Fatalf("Login(%v) error = %v", name, err)
+		}
+		ctx := metadata.NewOutgoingContext(context.Background(), metadata.Pairs("x-chat-token", res.Token))
+		stream, err := client.Stream(ctx)
+		if err != nil {
+			t.Fatalf("Stream(%v) error = %v", name, err)
+		}
+		streams = append(streams, stream)
+	}
+
+	// Keep posting while the server shuts down, sends racing with the shutdown must not panic
+	var senders sync.WaitGroup
+	for _, stream := range streams {
+		senders.Add(1)
+		go func(stream chat.Chat_StreamClient) {
+			defer senders.Done()
+			for stream.Send(&chat.StreamRequest{Message: "hello"}) == nil {
+				time.Sleep(time.Millisecond)
+			}
+		}(stream)
+	}
+
+	// Wait for both streams to be registered before signalling
+	for _, stream := range streams {
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				t.Fatalf("Recv() error = %v", err)
+			}
+			if res.GetClientMessage() != nil {
+				break
+			}
+		}
+	}
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatalf("Kill() error = %v", err)
+	}
+
+	for _, stream := range streams {
+		var shutdown *chat.StreamResponse_Shutdown
+		for {
+			res, err := stream.Recv()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Recv() error = %v, want EOF after the shutdown", err)
+			}
+			if res.GetServerShutdown() != nil {
+				shutdown = res.GetServerShutdown()
+			}
+		}
+		if shutdown == nil || shutdown.Deadline == nil || shutdown.RestartEta == nil {
+			t.Fatalf("stream ended without a shutdown notification carrying a deadline and a restart ETA")
+		}
+		if shutdown.Reason != "upgrade" || len(shutdown.AlternateAddresses) != 1 || shutdown.AlternateAddresses[0] != "backup:50051" {
+			t.Fatalf("unexpected shutdown notification %v", shutdown)
+		}
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatalf("run() did not return after SIGTERM")
+	}
+	senders.Wait()
+
+	_, err = client.Login(context.Background(), &chat.LoginRequest{Username: "carol"})
+	if code := status.Code(err); code != codes.Unavailable {
+		t.Fatalf("Login() after shutdown code = %v, want %v", code, codes.Unavailable)
+	}
+}
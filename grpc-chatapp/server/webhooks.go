@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/webhook"
+)
+
+// loadWebhooks reads the JSON list of the webhook subscriptions in the file, e.g.
+// [{"id": "ci", "room": "builds", "events": ["message"], "url": "https://ci.example.com/hook", "secret": "s3cr3t"}]
+func loadWebhooks(file string) ([]webhook.Subscription, error) {
+
+	var subs []webhook.Subscription
+	if err := readJSON(file, &subs); err != nil {
+		return nil, err
+	}
+	return subs, nil
+}
+
+// loadIncomingWebhooks reads the JSON list of the incoming webhooks in the file, e.g.
+// [{"room": "builds", "name": "ci", "token": "9f8e7d6c5b4a"}]
+func loadIncomingWebhooks(file string) ([]chatserver.IncomingWebhook, error) {
+
+	var hooks []chatserver.IncomingWebhook
+	if err := readJSON(file, &hooks); err != nil {
+		return nil, err
+	}
+	return hooks, nil
+}
+
+func readJSON(file string, v interface{}) error {
+
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid file %v: %w", file, err)
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/webhook"
+)
+
+func TestLoadWebhooks(t *testing.T) {
+
+	tests := []struct {
+		content string
+		want    []webhook.Subscription
+		wantErr bool
+	}{
+		{`[]`, []webhook.Subscription{}, false},
+		{
+			`[{"id": "ci", "room": "builds", "events": ["message"], "url": "https://ci.example.com/hook", "secret": "s3cr3t"}]`,
+			[]webhook.Subscription{{ID: "ci", Room: "builds", Events: []string{"message"}, URL: "https://ci.example.com/hook", Secret: "s3cr3t"}},
+			false,
+		},
+		{`{"id": "ci"}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		file := filepath.Join(t.TempDir(), "webhooks.json")
+		if err := ioutil.WriteFile(file, []byte(tt.content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		got, err := loadWebhooks(file)
+		if (err != nil) != tt.wantErr || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("loadWebhooks(%s) = %v, %v, want %v, error %v", tt.content, got, err, tt.want, tt.wantErr)
+		}
+	}
+
+	if _, err := loadWebhooks(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Errorf("loadWebhooks() of a missing file returned no error")
+	}
+}
+
+func TestLoadIncomingWebhooks(t *testing.T) {
+
+	file := filepath.Join(t.TempDir(), "incoming.json")
+	content := `[{"room": "builds", "name": "ci", "token": "9f8e7d6c5b4a"}]`
+	if err := ioutil.WriteFile(file, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	got, err := loadIncomingWebhooks(file)
+	want := []chatserver.IncomingWebhook{{Room: "builds", Name: "ci", Token: "9f8e7d6c5b4a"}}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("loadIncomingWebhooks() = %v, %v, want %v", got, err, want)
+	}
+}
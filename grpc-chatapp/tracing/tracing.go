@@ -0,0 +1,177 @@
+// Package tracing sets up OpenTelemetry for the chat binaries and carries
+// the trace context across gRPC calls in the request metadata.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.4.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Supported values for the exporter passed to Setup
+const (
+	ExporterNone   = "none"
+	ExporterStdout = "stdout"
+	ExporterFile   = "file"
+)
+
+const instrumentationName = "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/tracing"
+
+// Setup installs the global tracer provider for the service, exporting the spans to
+// stdout or to the file at path, and returns a function flushing them on shutdown.
+// With ExporterNone spans are not recorded but the trace context is still propagated.
+func Setup(service, exporter, path string) (func(context.Context) error, error) {
+
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var w io.Writer
+	closeWriter := func() error { return nil }
+	switch exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		w = os.Stdout
+	case ExporterFile:
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, err
+		}
+		w, closeWriter = f, f.Close
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", exporter)
+	}
+
+	exp, err := stdouttrace.New(stdouttrace.WithWriter(w))
+	if err != nil {
+		closeWriter()
+		return nil, err
+	}
+	res := resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exp), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+
+	return func(ctx context.Context) error {
+		if err := tp.Shutdown(ctx); err != nil {
+			closeWriter()
+			return err
+		}
+		return closeWriter()
+	}, nil
+}
+
+// metadataCarrier adapts the gRPC metadata to the propagation.TextMapCarrier interface
+type metadataCarrier metadata.MD
+
+func (c metadataCarrier) Get(key string) string {
+	vals := metadata.MD(c).Get(key)
+	if len(vals) == 0 {
+		return ""
+	}
+	return vals[0]
+}
+
+func (c metadataCarrier) Set(key, value string) {
+	metadata.MD(c).Set(key, value)
+}
+
+func (c metadataCarrier) Keys() []string {
+	keys := make([]string, 0, len(c))
+	for k := range c {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// inject adds the trace context of ctx to its outgoing metadata
+func inject(ctx context.Context) context.Context {
+
+	md, ok := metadata.FromOutgoingContext(ctx)
+	if ok {
+		md = md.Copy()
+	} else {
+		md = metadata.MD{}
+	}
+	otel.GetTextMapPropagator().Inject(ctx, metadataCarrier(md))
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// extract returns ctx carrying the remote trace context found in its incoming metadata
+func extract(ctx context.Context) context.Context {
+
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, metadataCarrier(md))
+}
+
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, status.Convert(err).Message())
+	}
+	span.End()
+}
+
+// UnaryServerInterceptor starts a server span for every unary call
+func UnaryServerInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+
+	ctx, span := tracer().Start(extract(ctx), info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+	res, err := handler(ctx, req)
+	end(span, err)
+	return res, err
+}
+
+type serverStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *serverStream) Context() context.Context {
+	return s.ctx
+}
+
+// StreamServerInterceptor starts a server span covering the whole stream
+func StreamServerInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+
+	ctx, span := tracer().Start(extract(ss.Context()), info.FullMethod, trace.WithSpanKind(trace.SpanKindServer))
+	err := handler(srv, &serverStream{ServerStream: ss, ctx: ctx})
+	end(span, err)
+	return err
+}
+
+// UnaryClientInterceptor starts a client span for every unary call and propagates it to the server
+func UnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+
+	ctx, span := tracer().Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient))
+	err := invoker(inject(ctx), method, req, reply, cc, opts...)
+	end(span, err)
+	return err
+}
+
+// StreamClientInterceptor starts a client span when a stream is opened and propagates it to the server,
+// the span only covers the stream setup since the client owns the stream lifetime
+func StreamClientInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+
+	ctx, span := tracer().Start(ctx, method, trace.WithSpanKind(trace.SpanKindClient))
+	cs, err := streamer(inject(ctx), desc, cc, method, opts...)
+	end(span, err)
+	return cs, err
+}
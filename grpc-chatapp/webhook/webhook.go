@@ -0,0 +1,437 @@
+// Package webhook POSTs the events of the chat to the services subscribed to them. Every subscription has
+// its own queue and worker, a slow or failing receiver only holds up its own deliveries. A delivery that
+// keeps failing is retried with an exponential backoff and dead-lettered after the last attempt.
+//
+// The body is a JSON Payload. The X-Chat-Signature header holds "sha256=" followed by the hex HMAC-SHA256
+// of the body keyed with the secret of the subscription, see Signature, X-Chat-Event the type of the event
+// and X-Chat-Delivery an id that stays the same across the attempts.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/queue"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+)
+
+// The types of the events
+const (
+	EventMessage = "message"
+	EventLogin   = "login"
+	EventLogout  = "logout"
+)
+
+const (
+	defaultAttempts    = 5
+	defaultBackoff     = time.Second
+	maxBackoff         = time.Minute
+	defaultQueueSize   = 1000
+	defaultDeadLetters = 100
+	defaultTimeout     = 10 * time.Second
+)
+
+// Subscription sends the events of a room to URL
+type Subscription struct {
+	// ID names the subscription in the status
+	ID string `json:"id"`
+	// Room is the room of the messages, the logins and logouts are only sent to the subscriptions without
+	// a room, which get the messages of every public room. The messages of a room that is not public are
+	// only sent once the subscription was approved.
+	Room string `json:"room"`
+	// Events are the types of the events sent, all of them when empty
+	Events []string `json:"events"`
+	URL    string   `json:"url"`
+	// Secret keys the signature of the payloads
+	Secret string `json:"secret"`
+}
+
+// Config sets the subscriptions up, the zero values get the defaults
+type Config struct {
+	Subscriptions []Subscription
+	// Client sends the requests, with a 10s timeout by default
+	Client *http.Client
+	// Attempts is the number of times a delivery is tried before it is dead-lettered, 5 by default
+	Attempts int
+	// Backoff is the wait after the first failed attempt, it doubles after every other one up to a minute.
+	// 1s by default.
+	Backoff time.Duration
+	// QueueSize is the number of deliveries waiting per subscription, the oldest are dropped when full.
+	// 1000 by default.
+	QueueSize int
+	// DeadLetters is the number of dead letters kept per subscription, 100 by default
+	DeadLetters int
+	Logger      log.Logger
+}
+
+// Payload is the body of the requests
+type Payload struct {
+	Event     string    `json:"event"`
+	Timestamp time.Time `json:"timestamp"`
+	Room      string    `json:"room,omitempty"`
+	Name      string    `json:"name"`
+	Message   string    `json:"message,omitempty"`
+}
+
+// Status reports the deliveries of a subscription
+type Status struct {
+	ID     string
+	Room   string
+	Events []string
+	URL    string
+	// Pending is the number of deliveries waiting in the queue
+	Pending int
+	// Delivered counts the deliveries that succeeded, FailedAttempts the attempts that failed along the way
+	Delivered      uint64
+	FailedAttempts uint64
+	// Dropped counts the deliveries evicted from the full queue
+	Dropped      uint64
+	DeadLettered uint64
+	LastError    string
+	LastDelivery time.Time
+	// Approved is set once the subscription was approved
+	Approved bool
+	// DeadLetters are the last deliveries given up on, oldest first
+	DeadLetters []DeadLetter
+}
+
+// DeadLetter is a delivery given up on after its last attempt
+type DeadLetter struct {
+	DeliveryID string
+	Event      string
+	Attempts   int
+	Error      string
+	At         time.Time
+	Payload    []byte
+}
+
+// Signature returns the value of the X-Chat-Signature header for the body
+func Signature(secret string, body []byte) string {
+
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// delivery is an event on its way to a subscription
+type delivery struct {
+	id    string
+	event string
+	body  []byte
+}
+
+// hook is a subscription along with its queue and status
+type hook struct {
+	sub    Subscription
+	events map[string]bool
+	queue  *queue.Queue[*delivery]
+
+	mu     sync.Mutex
+	status Status
+}
+
+// Dispatcher delivers the events to the subscriptions
+type Dispatcher struct {
+	cfg    Config
+	hooks  []*hook
+	logger log.Logger
+
+	// ctx is cancelled when Close gives up on the deliveries in flight
+	ctx     context.Context
+	cancel  context.CancelFunc
+	workers sync.WaitGroup
+}
+
+// New checks the subscriptions and starts their workers, Close has to be called to stop them
+func New(cfg Config) (*Dispatcher, error) {
+
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: defaultTimeout}
+	}
+	if cfg.Attempts <= 0 {
+		cfg.Attempts = defaultAttempts
+	}
+	if cfg.Backoff <= 0 {
+		cfg.Backoff = defaultBackoff
+	}
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = defaultQueueSize
+	}
+	if cfg.DeadLetters <= 0 {
+		cfg.DeadLetters = defaultDeadLetters
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = log.NewNopLogger()
+	}
+
+	d := &Dispatcher{cfg: cfg, logger: cfg.Logger}
+	ids := make(map[string]bool)
+	for _, sub := range cfg.Subscriptions {
+		if sub.ID == "" || ids[sub.ID] {
+			return nil, fmt.Errorf("webhook %q: the id is missing or used twice", sub.ID)
+		}
+		ids[sub.ID] = true
+		if u, err := url.Parse(sub.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("webhook %q: invalid url %q", sub.ID, sub.URL)
+		}
+		h := &hook{
+			sub:    sub,
+			events: make(map[string]bool),
+			queue:  queue.New[*delivery](cfg.QueueSize, queue.DropOldest),
+			status: Status{ID: sub.ID, Room: sub.Room, Events: sub.Events, URL: sub.URL},
+		}
+		for _, ev := range sub.Events {
+			switch ev {
+			case EventMessage, EventLogin, EventLogout:
+				h.events[ev] = true
+			default:
+				return nil, fmt.Errorf("webhook %q: unknown event %q", sub.ID, ev)
+			}
+		}
+		d.hooks = append(d.hooks, h)
+	}
+
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, h := range d.hooks {
+		d.workers.Add(1)
+		go func(h *hook) {
+			defer d.workers.Done()
+			d.run(h)
+		}(h)
+	}
+	return d, nil
+}
+
+// payload returns the payload of the event, false for the events that are not sent such as the direct
+// messages
+func payload(res *chat.StreamResponse) (*Payload, bool) {
+
+	p := &Payload{}
+	if ts, err := ptypes.Timestamp(res.Timestamp); err == nil {
+		p.Timestamp = ts
+	}
+	switch ev := res.Event.(type) {
+	case *chat.StreamResponse_ClientMessage:
+		if ev.ClientMessage.To != "" {
+			return nil, false
+		}
+		p.Event, p.Room, p.Name, p.Message = EventMessage, ev.ClientMessage.Room, ev.ClientMessage.Name, ev.ClientMessage.Message
+	case *chat.StreamResponse_ClientLogin:
+		p.Event, p.Name = EventLogin, ev.ClientLogin.Name
+	case *chat.StreamResponse_ClientLogout:
+		p.Event, p.Name = EventLogout, ev.ClientLogout.Name
+	default:
+		return nil, false
+	}
+	return p, true
+}
+
+// matches reports whether the subscription wants the event and may have it, public tells whether the room
+// of a message is public
+func (h *hook) matches(p *Payload, public bool) bool {
+
+	if len(h.events) > 0 && !h.events[p.Event] {
+		return false
+	}
+	if p.Room == "" || public {
+		return h.sub.Room == "" || h.sub.Room == p.Room
+	}
+	if h.sub.Room != p.Room {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.status.Approved
+}
+
+// Dispatch queues the event for the subscriptions that want it, it never waits. public tells whether the room
+// of a message is public.
+func (d *Dispatcher) Dispatch(res *chat.StreamResponse, public bool) {
+
+	p, ok := payload(res)
+	if !ok {
+		return
+	}
+	var body []byte
+	for _, h := range d.hooks {
+		if !h.matches(p, public) {
+			continue
+		}
+		if body == nil {
+			var err error
+			if body, err = json.Marshal(p); err != nil {
+				level.Error(d.logger).Log("error", "failed to encode the payload", "err", err)
+				return
+			}
+		}
+		// Receivers deduplicate by the ID, a delivery is better dropped than sent with a blank one
+		id := make([]byte, 8)
+		if _, err := rand.Read(id); err != nil {
+			level.Error(d.logger).Log("error", "failed to generate the delivery id, dropping the delivery", "webhook", h.sub.ID, "err", err)
+			continue
+		}
+		if err := h.queue.TryPush(&delivery{id: hex.EncodeToString(id), event: p.Event, body: body}); err != nil {
+			level.Warn(d.logger).Log("message", "dropping the delivery", "webhook", h.sub.ID, "err", err)
+		}
+	}
+}
+
+// run delivers the queued events until the queue is closed and drained
+func (d *Dispatcher) run(h *hook) {
+
+	for {
+		dl, err := h.queue.Pop(d.ctx)
+		if err != nil {
+			return
+		}
+		d.deliver(h, dl)
+	}
+}
+
+// deliver tries the delivery until it succeeds or runs out of attempts, and records the outcome
+func (d *Dispatcher) deliver(h *hook, dl *delivery) {
+
+	backoff := d.cfg.Backoff
+	for attempt := 1; ; attempt++ {
+		err := d.post(h, dl)
+		h.mu.Lock()
+		if err == nil {
+			h.status.Delivered++
+			h.status.LastDelivery = time.Now()
+			h.mu.Unlock()
+			return
+		}
+		h.status.FailedAttempts++
+		h.status.LastError = err.Error()
+		h.mu.Unlock()
+		level.Warn(d.logger).Log("message", "delivery failed", "webhook", h.sub.ID, "delivery", dl.id, "attempt", attempt, "err", err)
+
+		giveUp := attempt >= d.cfg.Attempts
+		if !giveUp {
+			select {
+			case <-time.After(backoff):
+			case <-d.ctx.Done():
+				giveUp = true
+			}
+		}
+		if giveUp {
+			h.deadLetter(dl, attempt, err, d.cfg.DeadLetters)
+			level.Error(d.logger).Log("error", "gave up on the delivery", "webhook", h.sub.ID, "delivery", dl.id, "attempts", attempt)
+			return
+		}
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+func (h *hook) deadLetter(dl *delivery, attempts int, err error, keep int) {
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.status.DeadLettered++
+	h.status.DeadLetters = append(h.status.DeadLetters, DeadLetter{
+		DeliveryID: dl.id,
+		Event:      dl.event,
+		Attempts:   attempts,
+		Error:      err.Error(),
+		At:         time.Now(),
+		Payload:    dl.body,
+	})
+	if len(h.status.DeadLetters) > keep {
+		h.status.DeadLetters = h.status.DeadLetters[len(h.status.DeadLetters)-keep:]
+	}
+}
+
+// post makes one attempt at the delivery, any status but 2xx fails it
+func (d *Dispatcher) post(h *hook, dl *delivery) error {
+
+	req, err := http.NewRequestWithContext(d.ctx, "POST", h.sub.URL, bytes.NewReader(dl.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Chat-Event", dl.event)
+	req.Header.Set("X-Chat-Delivery", dl.id)
+	req.Header.Set("X-Chat-Signature", Signature(h.sub.Secret, dl.body))
+	res, err := d.cfg.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// Reading the body lets the connection be reused
+	io.Copy(ioutil.Discard, io.LimitReader(res.Body, 64<<10))
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("unexpected status %v", res.Status)
+	}
+	return nil
+}
+
+// Approve lets the subscription with the id have the messages of its room, it reports whether there is one
+func (d *Dispatcher) Approve(id string) bool {
+
+	for _, h := range d.hooks {
+		if h.sub.ID == id {
+			h.mu.Lock()
+			h.status.Approved = true
+			h.mu.Unlock()
+			return true
+		}
+	}
+	return false
+}
+
+// Status returns the status of the subscriptions, in the order they were configured
+func (d *Dispatcher) Status() []Status {
+
+	statuses := make([]Status, 0, len(d.hooks))
+	for _, h := range d.hooks {
+		h.mu.Lock()
+		st := h.status
+		st.DeadLetters = append([]DeadLetter(nil), h.status.DeadLetters...)
+		h.mu.Unlock()
+		st.Pending = h.queue.Len()
+		st.Dropped = h.queue.Dropped()
+		statuses = append(statuses, st)
+	}
+	return statuses
+}
+
+// Close stops taking events and delivers those queued until ctx is done, the deliveries left are then
+// dead-lettered or dropped. It returns ctx's error if they were cut short.
+func (d *Dispatcher) Close(ctx context.Context) error {
+
+	for _, h := range d.hooks {
+		h.queue.Close()
+	}
+	done := make(chan struct{})
+	go func() {
+		d.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		d.cancel()
+		return nil
+	case <-ctx.Done():
+		d.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,230 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/webhook"
+)
+
+// receiver records the payloads it gets, failing the first requests of every delivery as told
+type receiver struct {
+	*httptest.Server
+	t      *testing.T
+	secret string
+
+	mu       sync.Mutex
+	failures int
+	attempts map[string]int
+	payloads []webhook.Payload
+	received chan struct{}
+}
+
+func newReceiver(t *testing.T, secret string, failures int) *receiver {
+
+	r := &receiver{t: t, secret: secret, failures: failures, attempts: make(map[string]int), received: make(chan struct{}, 100)}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if got, want := req.Header.Get("X-Chat-Signature"), webhook.Signature(r.secret, body); got != want {
+			t.Errorf("X-Chat-Signature = %q, want %q", got, want)
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		id := req.Header.Get("X-Chat-Delivery")
+		r.attempts[id]++
+		if r.failures < 0 || r.attempts[id] <= r.failures {
+			http.Error(w, "try again", http.StatusServiceUnavailable)
+			return
+		}
+		var p webhook.Payload
+		if err := json.Unmarshal(body, &p); err != nil {
+			t.Errorf("received %s: %v", body, err)
+		}
+		if p.Event != req.Header.Get("X-Chat-Event") {
+			t.Errorf("X-Chat-Event = %q, want %q", req.Header.Get("X-Chat-Event"), p.Event)
+		}
+		r.payloads = append(r.payloads, p)
+		r.received <- struct{}{}
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// wait returns the payloads once n were received
+func (r *receiver) wait(n int) []webhook.Payload {
+
+	r.t.Helper()
+	for i := 0; i < n; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			r.t.Fatalf("received %d payloads, want %d", i, n)
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhook.Payload(nil), r.payloads...)
+}
+
+func message(name, room, text string) *chat.StreamResponse {
+	return &chat.StreamResponse{
+		Timestamp: ptypes.TimestampNow(),
+		Event: &chat.StreamResponse_ClientMessage{
+			ClientMessage: &chat.StreamResponse_Message{Name: name, Room: room, Message: text},
+		},
+	}
+}
+
+func login(name string) *chat.StreamResponse {
+	return &chat.StreamResponse{
+		Event: &chat.StreamResponse_ClientLogin{ClientLogin: &chat.StreamResponse_Login{Name: name}},
+	}
+}
+
+func TestNew(t *testing.T) {
+
+	tests := []struct {
+		name string
+		sub  webhook.Subscription
+		ok   bool
+	}{
+		{"valid", webhook.Subscription{ID: "ci", URL: "https://ci.example.com/hook", Events: []string{"message", "login"}}, true},
+		{"missing id", webhook.Subscription{URL: "https://ci.example.com/hook"}, false},
+		{"invalid url", webhook.Subscription{ID: "ci", URL: "ci.example.com/hook"}, false},
+		{"unknown event", webhook.Subscription{ID: "ci", URL: "https://ci.example.com/hook", Events: []string{"typing"}}, false},
+	}
+	for _, tt := range tests {
+		d, err := webhook.New(webhook.Config{Subscriptions: []webhook.Subscription{tt.sub}})
+		if (err == nil) != tt.ok {
+			t.Errorf("%v: New() error = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if d != nil {
+			d.Close(context.Background())
+		}
+	}
+
+	sub := webhook.Subscription{ID: "ci", URL: "https://ci.example.com/hook"}
+	if _, err := webhook.New(webhook.Config{Subscriptions: []webhook.Subscription{sub, sub}}); err == nil {
+		t.Errorf("New() with the same id twice returned no error")
+	}
+}
+
+func TestDispatch(t *testing.T) {
+
+	builds, presence := newReceiver(t, "builds secret", 0), newReceiver(t, "presence secret", 0)
+	all := newReceiver(t, "all secret", 0)
+	d, err := webhook.New(webhook.Config{Subscriptions: []webhook.Subscription{
+		{ID: "builds", Room: "builds", Events: []string{webhook.EventMessage}, URL: builds.URL, Secret: builds.secret},
+		{ID: "presence", Events: []string{webhook.EventLogin, webhook.EventLogout}, URL: presence.URL, Secret: presence.secret},
+		{ID: "all", Events: []string{webhook.EventMessage}, URL: all.URL, Secret: all.secret},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	d.Dispatch(login("ci"), false)
+	d.Dispatch(message("ci", "lobby", "not for the builds hook"), true)
+	// The builds room is not public, its messages are only sent to the builds hook once approved
+	d.Dispatch(message("ci", "builds", "build #41 failed"), false)
+	if d.Approve("unknown") || !d.Approve("builds") {
+		t.Fatalf("Approve() did not find the builds hook alone")
+	}
+	d.Dispatch(message("ci", "builds", "build #42 passed"), false)
+	direct := message("ci", "", "direct messages are never sent")
+	direct.GetClientMessage().To = "alice"
+	d.Dispatch(direct, false)
+	if err := d.Close(context.Background()); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	got := builds.wait(1)
+	if len(got) != 1 || got[0].Event != "message" || got[0].Room != "builds" || got[0].Name != "ci" || got[0].Message != "build #42 passed" {
+		t.Errorf("the builds hook received %+v, want the message of the builds room", got)
+	}
+	if got[0].Timestamp.IsZero() {
+		t.Errorf("the payload has no timestamp")
+	}
+	if got := presence.wait(1); len(got) != 1 || got[0].Event != "login" || got[0].Name != "ci" {
+		t.Errorf("the presence hook received %+v, want the login of ci", got)
+	}
+	if got := all.wait(1); len(got) != 1 || got[0].Room != "lobby" {
+		t.Errorf("the hook of every room received %+v, want the message of the lobby alone", got)
+	}
+	for _, st := range d.Status() {
+		if st.Delivered != 1 || st.Pending != 0 || st.FailedAttempts != 0 {
+			t.Errorf("%v status = %+v, want one delivery", st.ID, st)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+
+	tests := []struct {
+		name string
+		// failures is the number of failed attempts of every delivery, all of them when negative
+		failures       int
+		delivered      uint64
+		failedAttempts uint64
+		deadLettered   uint64
+	}{
+		{"succeeds after retrying", 2, 1, 2, 0},
+		{"dead-lettered", -1, 0, 3, 1},
+	}
+	for _, tt := range tests {
+		r := newReceiver(t, "secret", tt.failures)
+		d, err := webhook.New(webhook.Config{
+			Subscriptions: []webhook.Subscription{{ID: "ci", URL: r.URL, Secret: r.secret}},
+			Attempts:      3,
+			Backoff:       time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("%v: New() error = %v", tt.name, err)
+		}
+		d.Dispatch(message("ci", "builds", "build #42 passed"), true)
+		if err := d.Close(context.Background()); err != nil {
+			t.Fatalf("%v: Close() error = %v", tt.name, err)
+		}
+
+		st := d.Status()[0]
+		if st.Delivered != tt.delivered || st.FailedAttempts != tt.failedAttempts || st.DeadLettered != tt.deadLettered {
+			t.Errorf("%v: status = %+v, want %v delivered, %v failed attempts and %v dead-lettered",
+				tt.name, st, tt.delivered, tt.failedAttempts, tt.deadLettered)
+		}
+		if tt.deadLettered == 0 {
+			continue
+		}
+		if len(st.DeadLetters) != 1 || st.DeadLetters[0].Attempts != 3 || st.DeadLetters[0].Event != "message" || st.LastError == "" {
+			t.Errorf("%v: dead letters = %+v, last error %q, want the message after 3 attempts", tt.name, st.DeadLetters, st.LastError)
+		}
+	}
+}
+
+func TestCloseGivesUp(t *testing.T) {
+
+	r := newReceiver(t, "secret", -1)
+	d, err := webhook.New(webhook.Config{
+		Subscriptions: []webhook.Subscription{{ID: "ci", URL: r.URL, Secret: r.secret}},
+		Backoff:       time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	d.Dispatch(message("ci", "builds", "build #42 passed"), true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := d.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("Close() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if st := d.Status()[0]; st.DeadLettered != 1 {
+		t.Fatalf("status = %+v, want the delivery dead-lettered", st)
+	}
+}
@@ -0,0 +1,237 @@
+// Package wsbridge lets browsers chat. It bridges WebSocket connections to the Stream RPC, exchanging
+// StreamRequest and StreamResponse messages as JSON text frames, and serves a page to chat from.
+//
+// The token of the user goes in the token query parameter, browsers cannot set the headers of a
+// WebSocket, or in the Authorization header. The frames sent look like {"message": "hi", "room": "ops"}
+// and the frames received like the responses of the REST gateway, e.g.
+// {"timestamp": "...", "client_message": {"name": "alice", "message": "hi", "room": "ops"}}.
+package wsbridge
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/gorilla/websocket"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	tokenHeader = "x-chat-token"
+	// maxFrameSize bounds the frames read from the browsers
+	maxFrameSize = 64 << 10
+	// pingPeriod keeps the idle connections from being cut by proxies, the browser has pongWait to answer
+	pingPeriod = 30 * time.Second
+	pongWait   = 2 * pingPeriod
+	writeWait  = 10 * time.Second
+	// logoutTimeout bounds the Logout call made when the browser goes away
+	logoutTimeout = 5 * time.Second
+)
+
+//go:embed static
+var static embed.FS
+
+var marshaler = jsonpb.Marshaler{OrigName: true}
+
+// Page serves the page to chat from a browser, along with the REST gateway and the bridge on /ws
+func Page() http.Handler {
+
+	sub, err := fs.Sub(static, "static")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(sub))
+}
+
+type bridge struct {
+	client   chat.ChatClient
+	logger   log.Logger
+	upgrader websocket.Upgrader
+}
+
+// New returns the handler upgrading the requests to WebSocket and bridging them to the Stream RPC over cc.
+// Only the pages of the same origin may connect.
+func New(cc *grpc.ClientConn, logger log.Logger) http.Handler {
+	return &bridge{client: chat.NewChatClient(cc), logger: logger}
+}
+
+// token returns the token of the query or of the Authorization header
+func token(r *http.Request) string {
+
+	if tkn := r.URL.Query().Get("token"); tkn != "" {
+		return tkn
+	}
+	auth := r.Header.Get("Authorization")
+	if len(auth) > len("Bearer ") && strings.EqualFold(auth[:len("Bearer ")], "Bearer ") {
+		return auth[len("Bearer "):]
+	}
+	return auth
+}
+
+func (b *bridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	tkn := token(r)
+	if tkn == "" {
+		http.Error(w, "missing token", http.StatusUnauthorized)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(metadata.AppendToOutgoingContext(r.Context(), tokenHeader, tkn))
+	defer cancel()
+	stream, err := b.client.Stream(ctx)
+	if err != nil {
+		http.Error(w, status.Convert(err).Message(), http.StatusBadGateway)
+		return
+	}
+
+	conn, err := b.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already replied
+		level.Warn(b.logger).Log("message", "failed to upgrade to WebSocket", "err", err)
+		return
+	}
+	defer conn.Close()
+	level.Info(b.logger).Log("message", "browser connected", "remote", r.RemoteAddr)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.write(conn, stream)
+	}()
+	b.read(conn, stream)
+	// The server ends the stream once it got everything that was sent
+	stream.CloseSend()
+	select {
+	case <-done:
+	case <-time.After(writeWait):
+		cancel()
+		<-done
+	}
+	b.logout(tkn)
+	level.Info(b.logger).Log("message", "browser disconnected", "remote", r.RemoteAddr)
+}
+
+// logout ends the session of the token, a closed tab would otherwise keep the name taken. Logging out
+// twice is harmless, the page may have done it already.
+func (b *bridge) logout(tkn string) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), logoutTimeout)
+	defer cancel()
+	if _, err := b.client.Logout(ctx, &chat.LogoutRequest{Token: tkn}); err != nil {
+		level.Warn(b.logger).Log("message", "failed to log the browser out", "err", err)
+	}
+}
+
+// read sends the frames of the browser to the stream until the browser goes away
+func (b *bridge) read(conn *websocket.Conn, stream chat.Chat_StreamClient) {
+
+	conn.SetReadLimit(maxFrameSize)
+	conn.SetReadDeadline(time.Now().Add(pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
+	for {
+		_, frame, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				level.Warn(b.logger).Log("message", "failed to read from the browser", "err", err)
+			}
+			return
+		}
+		req := &chat.StreamRequest{}
+		if err := jsonpb.Unmarshal(bytes.NewReader(frame), req); err != nil {
+			level.Warn(b.logger).Log("message", "dropping a malformed frame", "err", err)
+			continue
+		}
+		if err := stream.Send(req); err != nil {
+			return
+		}
+	}
+}
+
+// write sends the events of the stream to the browser until the stream ends, and then closes the WebSocket
+// with the reason. It pings the browser in the meantime.
+func (b *bridge) write(conn *websocket.Conn, stream chat.Chat_StreamClient) {
+
+	events := make(chan *chat.StreamResponse)
+	recvErr := make(chan error, 1)
+	go func() {
+		for {
+			res, err := stream.Recv()
+			if err != nil {
+				recvErr <- err
+				return
+			}
+			events <- res
+		}
+	}()
+
+	ticker := time.NewTicker(pingPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case res := <-events:
+			frame, err := marshaler.MarshalToString(res)
+			if err != nil {
+				level.Error(b.logger).Log("error", "failed to encode the event", "err", err)
+				continue
+			}
+			conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				// The reader notices the broken connection as well, what is left of the stream is drained
+				go drain(events, recvErr)
+				return
+			}
+
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				go drain(events, recvErr)
+				return
+			}
+
+		case err := <-recvErr:
+			msg := websocket.FormatCloseMessage(closeCode(err), status.Convert(err).Message())
+			conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(writeWait))
+			// Give the browser a moment to answer the close frame, then unblock the reader
+			conn.SetReadDeadline(time.Now().Add(writeWait))
+			return
+		}
+	}
+}
+
+// drain discards the events until the stream ends, so that the receiving goroutine exits
+func drain(events <-chan *chat.StreamResponse, recvErr <-chan error) {
+	for {
+		select {
+		case <-events:
+		case <-recvErr:
+			return
+		}
+	}
+}
+
+// closeCode maps the error ending the stream to the code the WebSocket is closed with. The browsers
+// cannot tell why the upgrade failed, an unknown token is reported when closing instead.
+func closeCode(err error) int {
+
+	if err == io.EOF {
+		return websocket.CloseNormalClosure
+	}
+	switch status.Code(err) {
+	case codes.Unauthenticated, codes.InvalidArgument:
+		return websocket.ClosePolicyViolation
+	}
+	return websocket.CloseTryAgainLater
+}
@@ -0,0 +1,153 @@
+package wsbridge_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/gorilla/websocket"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatclient"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chatserver"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/chattest"
+	chat "github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/schema"
+	"github.com/yashrsharma44/grpc-chat-app/grpc-chatapp/wsbridge"
+)
+
+// frame is the part of the events the tests look at
+type frame struct {
+	ClientMessage *struct {
+		Name, Message, Room string
+	} `json:"client_message"`
+	ServerShutdown *struct{ Reason string } `json:"server_shutdown"`
+}
+
+// next reads frames until one satisfies ok
+func next(t *testing.T, conn *websocket.Conn, ok func(frame) bool) frame {
+
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(chattest.Timeout))
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			t.Fatalf("ReadMessage() error = %v", err)
+		}
+		var f frame
+		if err := json.Unmarshal(data, &f); err != nil {
+			t.Fatalf("received %s: %v", data, err)
+		}
+		if ok(f) {
+			return f
+		}
+	}
+}
+
+func TestBridge(t *testing.T) {
+
+	s := chattest.NewServer(t, chatserver.Options{})
+	cc := s.Dial()
+	srv := httptest.NewServer(wsbridge.New(cc, log.NewNopLogger()))
+	defer srv.Close()
+	url := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx := context.Background()
+	login, err := chat.NewChatClient(cc).Login(ctx, &chat.LoginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Login() error = %v", err)
+	}
+	bob := s.Client("bob")
+
+	tests := []struct {
+		name   string
+		url    string
+		header http.Header
+		want   int
+	}{
+		{"no token", url, nil, http.StatusUnauthorized},
+		{"header", url, http.Header{"Authorization": {"Bearer " + login.Token}}, http.StatusSwitchingProtocols},
+	}
+	for _, tt := range tests {
+		conn, res, err := websocket.DefaultDialer.Dial(tt.url, tt.header)
+		if res == nil {
+			t.Fatalf("%v: Dial() error = %v", tt.name, err)
+		}
+		if res.StatusCode != tt.want {
+			t.Errorf("%v: upgrade status = %v, want %v", tt.name, res.StatusCode, tt.want)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+
+	// Closing the WebSocket logs the browser out, the name is free again
+	if logout := bob.Next(chatclient.Logout{}).(chatclient.Logout); logout.Name != "alice" {
+		t.Fatalf("bob saw %v log out, want alice", logout.Name)
+	}
+	login, err = chat.NewChatClient(cc).Login(ctx, &chat.LoginRequest{Username: "alice"})
+	if err != nil {
+		t.Fatalf("Login() once disconnected error = %v", err)
+	}
+
+	// The browsers cannot see the status of the upgrade, an unknown token closes the WebSocket instead
+	conn, _, err := websocket.DefaultDialer.Dial(url+"?token=nope", nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	if _, _, err := conn.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		t.Fatalf("ReadMessage() with an unknown token error = %v, want a policy violation", err)
+	}
+	conn.Close()
+
+	conn, _, err = websocket.DefaultDialer.Dial(url+"?token="+login.Token, nil)
+	if err != nil {
+		t.Fatalf("Dial() error = %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.WriteMessage(websocket.TextMessage, []byte(`{"message": "hi from the browser"}`)); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+	if got := bob.Next(chatclient.Message{}).(chatclient.Message); got.Name != "alice" || got.Text != "hi from the browser" {
+		t.Fatalf("bob received %q from %v, want the message of the browser", got.Text, got.Name)
+	}
+	// A malformed frame is dropped without closing the connection
+	conn.WriteMessage(websocket.TextMessage, []byte(`not json`))
+
+	if err := bob.Send(ctx, "", "hi alice"); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	f := next(t, conn, func(f frame) bool { return f.ClientMessage != nil && f.ClientMessage.Name == "bob" })
+	if f.ClientMessage.Message != "hi alice" || f.ClientMessage.Room != "lobby" {
+		t.Fatalf("browser received %+v, want the message of bob", f.ClientMessage)
+	}
+
+	// On shutdown the browser gets the notice and the WebSocket is closed
+	shutdownCtx, cancel := context.WithTimeout(ctx, chattest.Timeout)
+	defer cancel()
+	go s.Shutdown(shutdownCtx)
+	next(t, conn, func(f frame) bool { return f.ServerShutdown != nil })
+	_, _, err = conn.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+		t.Fatalf("ReadMessage() after shutdown error = %v, want a normal closure", err)
+	}
+}
+
+func TestPage(t *testing.T) {
+
+	srv := httptest.NewServer(wsbridge.Page())
+	defer srv.Close()
+	res, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK || !strings.Contains(string(body), `/ws?token=`) {
+		t.Fatalf("GET / = %v, want the chat page", res.StatusCode)
+	}
+}